@@ -0,0 +1,76 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RewriteBatch reads newline delimited `OLD NEW` import path pairs from the
+// input and rewrites the imports of each within the current directory, logging
+// the outcome of every line. Empty lines and # comments are ignored. The number
+// of failed lines is returned.
+func RewriteBatch(input io.Reader) int {
+	return newConversion(DefaultOptions()).rewriteBatch(input)
+}
+
+// rewriteBatch runs the batch rewrites of RewriteBatch within the project of the
+// conversion.
+func (c *conversion) rewriteBatch(input io.Reader) int {
+	var (
+		scanner = bufio.NewScanner(input)
+		line    int
+		failed  int
+	)
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			logError("Line %d: invalid instruction %q, expected `OLD NEW`", line, text)
+			failed++
+			continue
+		}
+		files, err := c.rewriteImportPath(fields[0], fields[1])
+		if err != nil {
+			logError("Line %d: failed to rewrite %s to %s: %v", line, fields[0], fields[1], err)
+			failed++
+			continue
+		}
+		c.logInfo("Line %d: rewrote %s to %s in %d files", line, fields[0], fields[1], files)
+	}
+	if err := scanner.Err(); err != nil {
+		logError("Failed to read instructions: %v", err)
+		failed++
+	}
+	return failed
+}
+
+// rewriteImportPath rewrites a single import path (and all its subpackages) to
+// a new one in all the Go files within the current directory, returning the
+// number of modified files. The files go through the same rewrite, formatting
+// and validation as during a conversion, and are only written if all of them
+// could be rewritten.
+func (c *conversion) rewriteImportPath(oldpath, newpath string) (int, error) {
+	writes, err := c.rewriteTree(map[string]string{oldpath: newpath}, "", nil, nil, nil, new(Report), new(bytes.Buffer))
+	if err != nil {
+		return 0, err
+	}
+	if err := validateWrites(writes); err != nil {
+		return 0, fmt.Errorf("failed to validate rewritten files: %v", err)
+	}
+	if err := c.commitWrites(writes, func(string) error { return nil }); err != nil {
+		return 0, fmt.Errorf("failed to write rewritten files: %v", err)
+	}
+	return len(writes), nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io"
+	"testing"
+)
+
+// Tests that batch rewrites streamed through a pipe are applied one after the
+// other, keeping the line endings and trailing newline of the files and tidying
+// them up the same way as a conversion does.
+func TestRewriteBatch(t *testing.T) {
+	mem := memProject(t, map[string]string{
+		"a.go":     "package p\r\n\r\nimport (\r\n\t\"github.com/old/foo\"\r\n\t\"github.com/old/bar/sub\"\r\n)",
+		"b/b.go":   "package b\n\nimport \"github.com/old/foobar\"\n",
+		"c/c.go":   "package c\n\nimport \"fmt\"\n",
+		"c/README": "github.com/old/foo\n",
+	})
+	c := newConversion(Options{FS: mem, Quiet: true})
+
+	reader, writer := io.Pipe()
+	go func() {
+		io.WriteString(writer, "# Move everything to the new org\n\n")
+		io.WriteString(writer, "github.com/old/foo github.com/new/foo\n")
+		io.WriteString(writer, "github.com/old/baz\n")
+		io.WriteString(writer, "github.com/old/bar github.com/new/bar\n")
+		writer.Close()
+	}()
+	if failed := c.rewriteBatch(reader); failed != 1 {
+		t.Errorf("failed instruction count mismatch: have %d, want 1", failed)
+	}
+	want := map[string]string{
+		"a.go":     "package p\r\n\r\nimport (\r\n\t\"github.com/new/bar/sub\"\r\n\t\"github.com/new/foo\"\r\n)",
+		"b/b.go":   "package b\n\nimport \"github.com/old/foobar\"\n",
+		"c/c.go":   "package c\n\nimport \"fmt\"\n",
+		"c/README": "github.com/old/foo\n",
+	}
+	for path, content := range want {
+		blob, err := mem.ReadFile(path)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", path, err)
+			continue
+		}
+		if string(blob) != content {
+			t.Errorf("%s: content mismatch: have %q, want %q", path, blob, content)
+		}
+	}
+}
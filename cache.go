@@ -0,0 +1,116 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// embedCache memoizes the embed/vendor decisions of import paths, optionally
+// persisting them to disk so later runs don't need to hit the network again.
+// It is safe for concurrent use: parallel workers deciding on the same path
+// wait for a single probe instead of issuing duplicates.
+type embedCache struct {
+	decisions map[string]bool          // Decisions made so far, keyed by import path
+	pending   map[string]*pendingProbe // Probes in flight, keyed by import path
+	file      string                   // Optional file to persist the decisions into
+	lock      sync.Mutex
+}
+
+// pendingProbe is a probe in flight, whose waiters receive its decision even if
+// it was not conclusive enough to be cached.
+type pendingProbe struct {
+	done  chan struct{} // Closed when the probe finished
+	embed bool          // Decision of the probe, valid after done is closed
+}
+
+// newEmbedCache creates an empty decision cache, persisted into the given file
+// if it's not empty.
+func newEmbedCache(file string) *embedCache {
+	return &embedCache{
+		decisions: make(map[string]bool),
+		pending:   make(map[string]*pendingProbe),
+		file:      file,
+	}
+}
+
+// loadEmbedCache creates a decision cache persisted into the given file, loading
+// any decisions already stored in it.
+func loadEmbedCache(file string) (*embedCache, error) {
+	cache := newEmbedCache(file)
+
+	blob, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(blob, &cache.decisions); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// cached returns a previously made decision for an import path, if any.
+func (c *embedCache) cached(path string) (bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	embed, ok := c.decisions[path]
+	return embed, ok
+}
+
+// decide returns the cached decision for an import path or runs the probe to
+// make one. Concurrent calls for the same path share a single probe. Decisions
+// the probe flags as inconclusive (e.g. made to be safe after a server error)
+// are returned, but not cached. The error is only about failing to persist the
+// decision, which is valid regardless.
+func (c *embedCache) decide(path string, probe func() (embed bool, conclusive bool)) (bool, error) {
+	c.lock.Lock()
+	if embed, ok := c.decisions[path]; ok {
+		c.lock.Unlock()
+		return embed, nil
+	}
+	if wait, ok := c.pending[path]; ok {
+		c.lock.Unlock()
+		<-wait.done
+		return wait.embed, nil
+	}
+	wait := &pendingProbe{done: make(chan struct{})}
+	c.pending[path] = wait
+	c.lock.Unlock()
+
+	embed, conclusive := probe()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	wait.embed = embed
+	delete(c.pending, path)
+	close(wait.done)
+
+	if !conclusive {
+		return embed, nil
+	}
+	c.decisions[path] = embed
+	return embed, c.persist()
+}
+
+// persist atomically writes the decisions into the backing file, if any. The
+// caller must hold the cache lock.
+func (c *embedCache) persist() error {
+	if c.file == "" {
+		return nil
+	}
+	blob, err := json.MarshalIndent(c.decisions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.file, append(blob, '\n'), 0644)
+}
@@ -0,0 +1,265 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Tests that concurrent decisions on the same path share a single probe, whose
+// result reaches every waiter even if it's too inconclusive to be cached.
+func TestEmbedCacheDecide(t *testing.T) {
+	tests := []struct {
+		name       string
+		conclusive bool
+	}{
+		{"conclusive", true},
+		{"inconclusive", false},
+	}
+	for _, tt := range tests {
+		var (
+			cache   = newEmbedCache("")
+			started = make(chan struct{})
+			release = make(chan struct{})
+			once    sync.Once
+			probes  int32
+		)
+		probe := func() (bool, bool) {
+			atomic.AddInt32(&probes, 1)
+			once.Do(func() { close(started) })
+			<-release
+			return true, tt.conclusive
+		}
+		results := make([]bool, 4)
+
+		var pend sync.WaitGroup
+		pend.Add(len(results))
+		go func() {
+			defer pend.Done()
+			results[0], _ = cache.decide("github.com/a/foo", probe)
+		}()
+		<-started
+		for i := 1; i < len(results); i++ {
+			go func(i int) {
+				defer pend.Done()
+				results[i], _ = cache.decide("github.com/a/foo", probe)
+			}(i)
+		}
+		// Give everyone time to queue up on the pending probe before finishing it
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		pend.Wait()
+
+		if probes := atomic.LoadInt32(&probes); probes != 1 {
+			t.Errorf("%s: probe count mismatch: have %d, want 1", tt.name, probes)
+		}
+		for i, embed := range results {
+			if !embed {
+				t.Errorf("%s: decision %d mismatch: have vendor, want embed", tt.name, i)
+			}
+		}
+		if _, ok := cache.cached("github.com/a/foo"); ok != tt.conclusive {
+			t.Errorf("%s: cached mismatch: have %v, want %v", tt.name, ok, tt.conclusive)
+		}
+	}
+}
+
+// Tests that hammering a persisted cache from many goroutines probes every path
+// exactly once, hands everyone the same decisions and leaves a consistent file
+// behind on disk.
+func TestEmbedCacheConcurrent(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   int  // Number of distinct paths decided
+		workers int  // Number of goroutines deciding on all of them
+		persist bool // Whether the cache is backed by a file
+	}{
+		{"single path", 1, 64, true},
+		{"many paths", 128, 16, true},
+		{"in memory", 128, 16, false},
+	}
+	for _, tt := range tests {
+		var file string
+		if tt.persist {
+			file = filepath.Join(t.TempDir(), "cache.json")
+		}
+		cache := newEmbedCache(file)
+
+		want := make(map[string]bool)
+		probes := make([]int32, tt.paths)
+		for i := 0; i < tt.paths; i++ {
+			want[fmt.Sprintf("github.com/org/pkg%d", i)] = i%2 == 0
+		}
+		var (
+			pend sync.WaitGroup
+			errs = make(chan error, tt.workers)
+		)
+		for w := 0; w < tt.workers; w++ {
+			pend.Add(1)
+			go func(w int) {
+				defer pend.Done()
+
+				// Start each worker at a different path to spread the contention
+				for j := 0; j < tt.paths; j++ {
+					i := (w + j) % tt.paths
+					path := fmt.Sprintf("github.com/org/pkg%d", i)
+
+					embed, err := cache.decide(path, func() (bool, bool) {
+						atomic.AddInt32(&probes[i], 1)
+						return i%2 == 0, true
+					})
+					if err != nil {
+						errs <- fmt.Errorf("failed to persist decision for %s: %v", path, err)
+						return
+					}
+					if embed != want[path] {
+						errs <- fmt.Errorf("decision mismatch for %s: have embed %v, want %v", path, embed, want[path])
+						return
+					}
+				}
+			}(w)
+		}
+		pend.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		for i := range probes {
+			if probes[i] != 1 {
+				t.Errorf("%s: probe count mismatch for path %d: have %d, want 1", tt.name, i, probes[i])
+			}
+		}
+		if !tt.persist {
+			continue
+		}
+		loaded, err := loadEmbedCache(file)
+		if err != nil {
+			t.Fatalf("%s: failed to reload persisted cache: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(loaded.decisions, want) {
+			t.Errorf("%s: persisted decisions mismatch: have %v, want %v", tt.name, loaded.decisions, want)
+		}
+	}
+}
+
+// Tests that the dependencies only mode classifies the dependencies over the
+// network into the cache without changing any file, so that a later conversion
+// with the same cache runs fully offline.
+func TestConvertDependenciesOnly(t *testing.T) {
+	probes := probeServer(t)
+
+	mem := memProject(t, gxProject)
+	before := fsFiles(t, mem)
+
+	opts := memOptions(t, mem, "{}")
+	opts.DependenciesOnly = true
+	opts.RequireOfflineDecisions = false
+	opts.GitHubRawHosts = map[string]string{"github.com": "raw.example.com"}
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to classify dependencies: %v", err)
+	}
+	if after := fsFiles(t, mem); !reflect.DeepEqual(after, before) {
+		t.Errorf("files changed by dependencies only run:\nhave %v\nwant %v", after, before)
+	}
+	if probed := probes.repos(); !reflect.DeepEqual(probed, []string{"a/foo", "b/bar"}) {
+		t.Errorf("probed repos mismatch: have %v, want [a/foo b/bar]", probed)
+	}
+	blob, err := ioutil.ReadFile(opts.CacheFile)
+	if err != nil {
+		t.Fatalf("failed to read decision cache: %v", err)
+	}
+	var cached map[string]bool
+	if err := json.Unmarshal(blob, &cached); err != nil {
+		t.Fatalf("failed to parse decision cache: %v", err)
+	}
+	if want := map[string]bool{"github.com/a/foo@v1.0.0": true, "github.com/b/bar": false}; !reflect.DeepEqual(cached, want) {
+		t.Errorf("cached decisions mismatch: have %v, want %v", cached, want)
+	}
+	// Convert offline using the warmed up cache
+	opts.DependenciesOnly = false
+	opts.RequireOfflineDecisions = true
+
+	requests := len(probes.requests)
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert offline: %v", err)
+	}
+	checkConverted(t, mem)
+
+	if len(probes.requests) != requests {
+		t.Errorf("offline conversion probed the network: %v", probes.requests[requests:])
+	}
+}
+
+// Tests that dependencies sharing an embed decision (subpackages of the same
+// repository, or superseded versions of the same package) only hit the network
+// once per conversion.
+func TestConvertSharedDecisions(t *testing.T) {
+	pkg := func(hash, name, version, path string) map[string]string {
+		return map[string]string{
+			"vendor/gx/ipfs/" + hash + "/" + name + "/package.json":    `{"name": "` + name + `", "version": "` + version + `", "gx": {"dvcsimport": "` + path + `"}}`,
+			"vendor/gx/ipfs/" + hash + "/" + name + "/" + name + ".go": "package " + name + "\n",
+		}
+	}
+	tests := []struct {
+		name   string
+		pkgs   []map[string]string // Packages sharing a decision
+		dedupe bool                // Whether to deduplicate semver compatible versions
+	}{
+		{
+			name: "subpackages of one repository",
+			pkgs: []map[string]string{
+				pkg("QmSub1", "sub1", "", "github.com/c/repo/sub1"),
+				pkg("QmSub2", "sub2", "", "github.com/c/repo/sub2"),
+			},
+		},
+		{
+			name: "superseded versions",
+			pkgs: []map[string]string{
+				pkg("QmOld", "repo", "1.0.0", "github.com/c/repo"),
+				pkg("QmNew", "repo", "1.1.0", "github.com/c/repo"),
+			},
+			dedupe: true,
+		},
+	}
+	for _, tt := range tests {
+		// Count the requests needed to decide on a single package
+		convert := func(pkgs []map[string]string) int {
+			probes := probeServer(t)
+
+			files := map[string]string{"main.go": "package main\n"}
+			for _, pkg := range pkgs {
+				for path, content := range pkg {
+					files[path] = content
+				}
+			}
+			opts := memOptions(t, memProject(t, files), "{}")
+			opts.RequireOfflineDecisions = false
+			opts.DedupeSemver = tt.dedupe
+			opts.GitHubRawHosts = map[string]string{"github.com": "raw.example.com"}
+
+			if _, err := Convert(opts); err != nil {
+				t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+			}
+			return len(probes.requests)
+		}
+		single := convert(tt.pkgs[len(tt.pkgs)-1:])
+		if single == 0 {
+			t.Fatalf("%s: single package decided without the network", tt.name)
+		}
+		if shared := convert(tt.pkgs); shared != single {
+			t.Errorf("%s: request count mismatch: have %d, want %d", tt.name, shared, single)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// importSchemes are the URL schemes occasionally found in dvcsimport fields,
+// which need stripping to get the bare import path.
+var importSchemes = []string{"https://", "http://", "git+ssh://", "ssh://", "git://"}
+
+// normalizeImportPath converts a dvcsimport value given as a repository URL
+// (e.g. https://www.github.com/org/repo.git) into a bare import path, and
+// validates that the result looks like an import path.
+func normalizeImportPath(path string) (string, error) {
+	normalized := strings.TrimSpace(path)
+
+	var url bool
+	for _, scheme := range importSchemes {
+		if strings.HasPrefix(strings.ToLower(normalized), scheme) {
+			normalized, url = normalized[len(scheme):], true
+			break
+		}
+	}
+	// Drop any user info (e.g. git@) and the www subdomain
+	if idx := strings.Index(normalized, "@"); idx >= 0 && idx < strings.Index(normalized+"/", "/") {
+		normalized = normalized[idx+1:]
+	}
+	// Drop the transport port of URLs (e.g. ssh://host:22/repo), reject it on bare
+	// paths (e.g. host:8080/repo) and convert scp style addresses (host:org/repo)
+	if idx := strings.Index(normalized, ":"); idx >= 0 && idx < strings.Index(normalized+"/", "/") {
+		end := strings.Index(normalized+"/", "/")
+		if port := normalized[idx+1 : end]; port != "" && strings.Trim(port, "0123456789") == "" {
+			if !url {
+				return "", fmt.Errorf("invalid import path %q, host has a port", path)
+			}
+			normalized = normalized[:idx] + normalized[end:]
+		} else {
+			normalized = normalized[:idx] + "/" + normalized[idx+1:]
+		}
+	}
+	normalized = strings.TrimPrefix(normalized, "www.")
+	normalized = strings.TrimSuffix(strings.TrimSuffix(normalized, "/"), ".git")
+
+	if normalized == "" || strings.HasPrefix(normalized, "/") || strings.Contains(normalized, "//") {
+		return "", fmt.Errorf("invalid import path %q", path)
+	}
+	for _, r := range normalized {
+		if unicode.IsSpace(r) || strings.ContainsRune(`:"'\`+"`", r) {
+			return "", fmt.Errorf("invalid import path %q", path)
+		}
+	}
+	return normalized, nil
+}
+
+// unifyPathCase folds canonical import paths that only differ in letter casing
+// into a single one. Such paths would be treated as distinct packages, yet they
+// would overwrite each other on case insensitive filesystems. The casing used by
+// most dependencies wins, ties broken alphabetically.
+func (c *conversion) unifyPathCase(mappings map[string]string) {
+	// Count the uses of each casing, grouped by the case folded path
+	casings := make(map[string]map[string]int)
+	for _, path := range mappings {
+		folded := strings.ToLower(path)
+		if casings[folded] == nil {
+			casings[folded] = make(map[string]int)
+		}
+		casings[folded][path]++
+	}
+	// Pick a winner for every path used with multiple casings
+	canonical := make(map[string]string)
+	for _, uses := range casings {
+		if len(uses) < 2 {
+			continue
+		}
+		paths := make([]string, 0, len(uses))
+		for path := range uses {
+			paths = append(paths, path)
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			if uses[paths[i]] != uses[paths[j]] {
+				return uses[paths[i]] > uses[paths[j]]
+			}
+			return paths[i] < paths[j]
+		})
+		for _, path := range paths[1:] {
+			c.logWarn("Warning, %s differs from %s only in casing, treating them as one", path, paths[0])
+			canonical[path] = paths[0]
+		}
+	}
+	for hash, path := range mappings {
+		if unified, ok := canonical[path]; ok {
+			mappings[hash] = unified
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// These constants mirror the default parameters IPFS uses when adding a file
+// tree, which gx relies on when publishing packages.
+const (
+	unixfsChunkSize = 256 * 1024 // Size of the leaf data chunks of large files
+	unixfsMaxLinks  = 174        // Maximum number of children of a file node
+)
+
+// UnixFS node types used when building the merkle DAG of a file tree.
+const (
+	unixfsRaw       = 0
+	unixfsDirectory = 1
+	unixfsFile      = 2
+	unixfsSymlink   = 4
+)
+
+// dagNode is a serialized dag-pb node along with its cumulative size, which is
+// needed by parent nodes when linking to it.
+type dagNode struct {
+	hash []byte // Multihash of the serialized node
+	size uint64 // Cumulative size of the node and all its children
+}
+
+// dagLink is a named reference from a parent node to a child.
+type dagLink struct {
+	name string
+	node dagNode
+}
+
+// computeCID recomputes the CIDv0 (base58 encoded sha256 multihash) that IPFS
+// would assign to a file tree, mimicking `ipfs add -r` with default settings.
+func (c *conversion) computeCID(dir string) (string, error) {
+	node, err := c.hashDirectory(dir)
+	if err != nil {
+		return "", err
+	}
+	return base58Encode(node.hash), nil
+}
+
+// hashDirectory builds the UnixFS directory node of a folder, skipping hidden
+// files the same way IPFS does by default.
+func (c *conversion) hashDirectory(dir string) (dagNode, error) {
+	infos, err := c.fsys.ReadDir(dir)
+	if err != nil {
+		return dagNode{}, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	var links []dagLink
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		var (
+			path = filepath.Join(dir, info.Name())
+			node dagNode
+		)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := c.fsys.Readlink(path)
+			if err != nil {
+				return dagNode{}, err
+			}
+			node = encodeNode(nil, unixfsData(unixfsSymlink, []byte(target), nil, nil))
+		case info.IsDir():
+			node, err = c.hashDirectory(path)
+		default:
+			node, err = c.hashFile(path)
+		}
+		if err != nil {
+			return dagNode{}, err
+		}
+		links = append(links, dagLink{name: info.Name(), node: node})
+	}
+	return encodeNode(links, unixfsData(unixfsDirectory, nil, nil, nil)), nil
+}
+
+// hashFile builds the UnixFS file node of a file, chunking it into a balanced
+// tree of raw leaves if it doesn't fit into a single block.
+func (c *conversion) hashFile(path string) (dagNode, error) {
+	blob, err := c.fsys.ReadFile(path)
+	if err != nil {
+		return dagNode{}, err
+	}
+	size := uint64(len(blob))
+	if len(blob) <= unixfsChunkSize {
+		return encodeNode(nil, unixfsData(unixfsFile, blob, &size, nil)), nil
+	}
+	var chunks [][]byte
+	for len(blob) > 0 {
+		n := unixfsChunkSize
+		if n > len(blob) {
+			n = len(blob)
+		}
+		chunks = append(chunks, blob[:n])
+		blob = blob[n:]
+	}
+	// Find the minimal depth that can hold all the chunks and fill it up
+	depth, capacity := 1, unixfsMaxLinks
+	for capacity < len(chunks) {
+		depth, capacity = depth+1, capacity*unixfsMaxLinks
+	}
+	node, _, _ := hashFileLevel(chunks, depth)
+	return node, nil
+}
+
+// hashFileLevel builds a file subtree of the given depth, consuming as many of
+// the chunks as fit into it. It returns the subtree, the size of the consumed
+// data and the remaining chunks.
+func hashFileLevel(chunks [][]byte, depth int) (dagNode, uint64, [][]byte) {
+	if depth == 0 {
+		size := uint64(len(chunks[0]))
+		return encodeNode(nil, unixfsData(unixfsRaw, chunks[0], &size, nil)), size, chunks[1:]
+	}
+	var (
+		links []dagLink
+		sizes []uint64
+		total uint64
+	)
+	for len(links) < unixfsMaxLinks && len(chunks) > 0 {
+		var (
+			child dagNode
+			size  uint64
+		)
+		child, size, chunks = hashFileLevel(chunks, depth-1)
+
+		links = append(links, dagLink{node: child})
+		sizes = append(sizes, size)
+		total += size
+	}
+	return encodeNode(links, unixfsData(unixfsFile, nil, &total, sizes)), total, chunks
+}
+
+// unixfsData serializes a UnixFS data protobuf message.
+func unixfsData(kind uint64, data []byte, filesize *uint64, blocksizes []uint64) []byte {
+	var msg []byte
+	msg = appendVarintField(msg, 1, kind)
+	if len(data) > 0 {
+		msg = appendBytesField(msg, 2, data)
+	}
+	if filesize != nil {
+		msg = appendVarintField(msg, 3, *filesize)
+	}
+	for _, size := range blocksizes {
+		msg = appendVarintField(msg, 4, size)
+	}
+	return msg
+}
+
+// encodeNode serializes a dag-pb node (links first, then data, as in the legacy
+// IPFS encoding) and hashes it.
+func encodeNode(links []dagLink, data []byte) dagNode {
+	var (
+		msg  []byte
+		size uint64
+	)
+	for _, link := range links {
+		var pblink []byte
+		pblink = appendBytesField(pblink, 1, link.node.hash)
+		pblink = appendBytesField(pblink, 2, []byte(link.name))
+		pblink = appendVarintField(pblink, 3, link.node.size)
+
+		msg = appendBytesField(msg, 2, pblink)
+		size += link.node.size
+	}
+	msg = appendBytesField(msg, 1, data)
+
+	hash := sha256.Sum256(msg)
+	return dagNode{
+		hash: append([]byte{0x12, sha256.Size}, hash[:]...),
+		size: size + uint64(len(msg)),
+	}
+}
+
+// appendVarintField appends a varint protobuf field to a message.
+func appendVarintField(msg []byte, field int, value uint64) []byte {
+	msg = appendVarint(msg, uint64(field)<<3)
+	return appendVarint(msg, value)
+}
+
+// appendBytesField appends a length delimited protobuf field to a message.
+func appendBytesField(msg []byte, field int, value []byte) []byte {
+	msg = appendVarint(msg, uint64(field)<<3|2)
+	msg = appendVarint(msg, uint64(len(value)))
+	return append(msg, value...)
+}
+
+// appendVarint appends a protobuf base 128 varint to a buffer.
+func appendVarint(buf []byte, value uint64) []byte {
+	for value >= 0x80 {
+		buf = append(buf, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(buf, byte(value))
+}
+
+// base58Alphabet is the Bitcoin base58 alphabet used by IPFS multihashes.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58Encode encodes a binary blob with the Bitcoin base58 alphabet.
+func base58Encode(blob []byte) string {
+	var (
+		num  = new(big.Int).SetBytes(blob)
+		base = big.NewInt(58)
+		mod  = new(big.Int)
+		out  []byte
+	)
+	for num.Sign() > 0 {
+		num.DivMod(num, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range blob {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Tests that file and folder hashes match the ones IPFS assigns to them when
+// adding with the default settings.
+func TestComputeCID(t *testing.T) {
+	tests := []struct {
+		name string
+		path string // Path to hash within the test tree
+		dir  bool   // Whether the path is a folder or a file
+		want string
+	}{
+		{"empty folder", "empty", true, "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"},
+		{"hidden files only", "hidden", true, "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"},
+		{"empty file", "files/empty", false, "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"},
+		{"small file", "files/hello", false, "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"},
+	}
+	mem := memProject(t, map[string]string{
+		"hidden/.git/HEAD": "ref: refs/heads/master\n",
+		"files/empty":      "",
+		"files/hello":      "hello world\n",
+	})
+	if err := mem.MkdirAll("empty", 0755); err != nil {
+		t.Fatalf("failed to create empty folder: %v", err)
+	}
+	c := newConversion(Options{FS: mem, Quiet: true})
+
+	for _, tt := range tests {
+		var (
+			have string
+			err  error
+		)
+		if tt.dir {
+			have, err = c.computeCID(tt.path)
+		} else {
+			var node dagNode
+			node, err = c.hashFile(tt.path)
+			have = base58Encode(node.hash)
+		}
+		if err != nil {
+			t.Errorf("%s: failed to hash: %v", tt.name, err)
+			continue
+		}
+		if have != tt.want {
+			t.Errorf("%s: hash mismatch: have %s, want %s", tt.name, have, tt.want)
+		}
+	}
+}
+
+// Tests that verifying the content hashes warns about a package not matching the
+// hash it's vendored under, but not about an untouched one.
+func TestConvertVerifyCID(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name     string
+		tampered bool
+	}{
+		{"matching tree", false},
+		{"tampered tree", true},
+	}
+	for _, tt := range tests {
+		// Hash the gx package the same way it was published
+		pkg := map[string]string{
+			"foo/package.json": `{"name": "foo", "version": "1.0.0", "gx": {"dvcsimport": "github.com/a/foo"}}`,
+			"foo/foo.go":       "package foo\n\nfunc Foo() {}\n",
+		}
+		c := newConversion(Options{FS: memProject(t, pkg), Quiet: true})
+		hash, err := c.computeCID(".")
+		if err != nil {
+			t.Fatalf("%s: failed to hash package: %v", tt.name, err)
+		}
+		if tt.tampered {
+			pkg["foo/foo.go"] = "package foo\n\nfunc Foo() { panic(\"pwned\") }\n"
+		}
+		files := map[string]string{
+			"main.go": "package main\n\nimport \"gx/ipfs/" + hash + "/foo\"\n\nfunc main() { foo.Foo() }\n",
+		}
+		for path, content := range pkg {
+			files["vendor/gx/ipfs/"+hash+"/"+path] = content
+		}
+		opts := memOptions(t, memProject(t, files), gxDecisions)
+		opts.VerifyCID = true
+		opts.Quiet = false
+
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert: %v", tt.name, err)
+		}
+		if warned := strings.Contains(logs.String(), "content hash mismatch"); warned != tt.tampered {
+			t.Errorf("%s: mismatch warning %v, want %v:\n%s", tt.name, warned, tt.tampered, logs.String())
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checkPackageClauses walks a folder tree and returns a description for every
+// directory containing Go files with conflicting package clauses, which would
+// fail to build. External test packages and files excluded via the `ignore`
+// build tag are not considered conflicts.
+func (c *conversion) checkPackageClauses(root string) ([]string, error) {
+	names := make(map[string]map[string]bool)
+
+	fset := token.NewFileSet()
+	err := c.fsys.Walk(root, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !strings.HasSuffix(fi.Name(), ".go") {
+			return err
+		}
+		blob, err := c.fsys.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		file, err := parser.ParseFile(fset, fp, blob, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil {
+			return nil // Unparsable files are someone else's problem
+		}
+		if ignoredFile(file) {
+			return nil
+		}
+		name := file.Name.Name
+		if strings.HasSuffix(fi.Name(), "_test.go") {
+			name = strings.TrimSuffix(name, "_test")
+		}
+		dir := filepath.Dir(fp)
+		if names[dir] == nil {
+			names[dir] = make(map[string]bool)
+		}
+		names[dir][name] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []string
+	for dir, pkgs := range names {
+		if len(pkgs) < 2 {
+			continue
+		}
+		var list []string
+		for name := range pkgs {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		conflicts = append(conflicts, fmt.Sprintf("%s: conflicting packages %s", dir, strings.Join(list, ", ")))
+	}
+	sort.Strings(conflicts)
+	return conflicts, nil
+}
+
+// ignoredFile returns whether a parsed Go file (with comments) is excluded from
+// builds via the `ignore` build tag.
+func ignoredFile(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			var expr string
+			switch {
+			case strings.HasPrefix(comment.Text, "// +build "):
+				expr = strings.TrimPrefix(comment.Text, "// +build ")
+			case strings.HasPrefix(comment.Text, "//go:build "):
+				expr = strings.TrimPrefix(comment.Text, "//go:build ")
+			default:
+				continue
+			}
+			for _, tag := range strings.Fields(expr) {
+				if tag == "ignore" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// packageName returns the name of the Go package in a folder, or an empty string
+// if the folder contains no buildable non-test Go files.
+func (c *conversion) packageName(dir string) (string, error) {
+	infos, err := c.fsys.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	fset := token.NewFileSet()
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".go") || strings.HasSuffix(info.Name(), "_test.go") {
+			continue
+		}
+		fp := filepath.Join(dir, info.Name())
+
+		blob, err := c.fsys.ReadFile(fp)
+		if err != nil {
+			return "", err
+		}
+		file, err := parser.ParseFile(fset, fp, blob, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil || ignoredFile(file) {
+			continue
+		}
+		return file.Name.Name, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests that folders mixing different package clauses are reported, but not the
+// ones with external test packages or files excluded from the build.
+func TestCheckPackageClauses(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name:  "single package",
+			files: map[string]string{"pkg/a.go": "package a\n", "pkg/b.go": "package a\n"},
+		},
+		{
+			name:  "external test package",
+			files: map[string]string{"pkg/a.go": "package a\n", "pkg/a_test.go": "package a_test\n"},
+		},
+		{
+			name:  "conflicting packages",
+			files: map[string]string{"pkg/a.go": "package a\n", "pkg/b.go": "package b\n"},
+			want:  []string{"pkg: conflicting packages a, b"},
+		},
+		{
+			name:  "nested folders",
+			files: map[string]string{"pkg/a.go": "package a\n", "pkg/sub/b.go": "package b\n", "pkg/sub/c.go": "package c\n"},
+			want:  []string{filepath.Join("pkg", "sub") + ": conflicting packages b, c"},
+		},
+		{
+			name:  "ignored legacy build tag",
+			files: map[string]string{"pkg/a.go": "package a\n", "pkg/gen.go": "// +build ignore\n\npackage main\n"},
+		},
+		{
+			name:  "ignored build constraint",
+			files: map[string]string{"pkg/a.go": "package a\n", "pkg/gen.go": "//go:build ignore\n\npackage main\n"},
+		},
+	}
+	for _, tt := range tests {
+		c := newConversion(Options{FS: memProject(t, tt.files), Quiet: true})
+
+		conflicts, err := c.checkPackageClauses("pkg")
+		if err != nil {
+			t.Errorf("%s: failed to check package clauses: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(conflicts, tt.want) {
+			t.Errorf("%s: conflicts mismatch: have %v, want %v", tt.name, conflicts, tt.want)
+		}
+	}
+}
+
+// Tests that converting a dependency with conflicting package clauses warns
+// about the folder that will not build.
+func TestConvertPackageClauses(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+
+	files := map[string]string{"vendor/gx/ipfs/QmBar/bar/other.go": "package other\n"}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	opts := memOptions(t, memProject(t, files), gxDecisions)
+	opts.Quiet = false
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	if !strings.Contains(logs.String(), filepath.Join("vendor", "github.com", "b", "bar")+": conflicting packages bar, other") {
+		t.Errorf("conflicting package clauses not reported:\n%s", logs.String())
+	}
+}
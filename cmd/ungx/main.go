@@ -0,0 +1,173 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command ungx converts a gx based Go package into a plain Go one, embedding or
+// vendoring its dependencies under their canonical import paths.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/karalabe/ungx"
+)
+
+// opts collects the conversion options set via the command line flags.
+var opts = ungx.DefaultOptions()
+
+// stdin switches ungx into batch mode, reading `OLD NEW` import path pairs from
+// the standard input and rewriting them, without touching any gx dependencies.
+var stdin = flag.Bool("stdin", false, "Rewrite import path pairs read from stdin, skipping gx")
+
+// reportDiff switches ungx into comparing two previously saved reports (the
+// second one given as a positional argument) and printing their differences.
+var reportDiff = flag.String("report-diff", "", "Compare a report with another (A.json B.json)")
+
+func init() {
+	flag.StringVar(&opts.Fork, "fork", opts.Fork, "Optional root import path to rewrite to")
+	flag.StringVar(&opts.Embed, "embed", opts.Embed, "Comma-separated packages to force embedding")
+	flag.BoolVar(&opts.OnlyEmbed, "only-embed", opts.OnlyEmbed, "Only convert dependencies that need embedding")
+	flag.BoolVar(&opts.OnlyVendor, "only-vendor", opts.OnlyVendor, "Only convert dependencies that can be vendored")
+	flag.BoolVar(&opts.ReplaceWithRequire, "replace-with-require", opts.ReplaceWithRequire, "Require gx based dependencies as modules instead of embedding, if possible")
+	flag.StringVar(&opts.Patch, "patch", opts.Patch, "Write the conversion as a patch file instead of applying it")
+	flag.BoolVar(&opts.VerifyCID, "verify-cid", opts.VerifyCID, "Verify that gx packages match their content hashes")
+	flag.StringVar(&opts.RewriteIf, "rewrite-if", opts.RewriteIf, "Only rewrite files whose content matches this regexp")
+	flag.BoolVar(&opts.DryRun, "dry-run", opts.DryRun, "Resolve and print the conversion plan without modifying anything")
+	flag.BoolVar(&opts.GitCommits, "git-commit", opts.GitCommits, "Commit the conversion phases into the git repository (needs a clean working tree)")
+	flag.StringVar(&opts.ReportFile, "report", opts.ReportFile, "Write a JSON report of the conversion into this file")
+	flag.BoolVar(&opts.RewriteProtos, "rewrite-proto", opts.RewriteProtos, "Rewrite go_package options in .proto files too")
+	flag.BoolVar(&opts.VerifyImports, "verify-imports-resolve", opts.VerifyImports, "Verify that all imports resolve after the conversion")
+	flag.BoolVar(&opts.Verify, "verify", opts.Verify, "Build the package after the conversion to verify it compiles")
+	flag.StringVar(&opts.BuildTags, "tags", opts.BuildTags, "Build tags needed to list the project package")
+	flag.StringVar(&opts.GOOS, "goos", opts.GOOS, "GOOS needed to list the project package")
+	flag.StringVar(&opts.GOARCH, "goarch", opts.GOARCH, "GOARCH needed to list the project package")
+	flag.StringVar(&opts.Licenses, "licenses", opts.Licenses, "Write the licenses of the converted dependencies into this file")
+	flag.StringVar(&opts.RewriteScripts, "rewrite-scripts", opts.RewriteScripts, "Comma-separated globs of scripts to rewrite import paths in")
+	flag.StringVar(&opts.UndoScript, "undo-script", opts.UndoScript, "Write a shell script reverting the package moves into this file")
+	flag.StringVar(&opts.DependencyReport, "dependency-report", opts.DependencyReport, "Write the dependencies grouped by repository into this file")
+	flag.StringVar(&opts.PerPackageHook, "per-package-hook", opts.PerPackageHook, "Shell command to run after each package move ($1=path, $2=dest)")
+	flag.BoolVar(&opts.GitMoves, "git-mv", opts.GitMoves, "Move packages via git mv to preserve history")
+	flag.StringVar(&opts.CacheFile, "cache", opts.CacheFile, "File to cache embed/vendor decisions in across runs")
+	flag.BoolVar(&opts.Provenance, "provenance-file", opts.Provenance, "Generate a provenance file into every embedded package")
+	flag.BoolVar(&opts.Strict, "strict", opts.Strict, "Abort if the moved packages and import rewrites are inconsistent")
+	flag.StringVar(&opts.Scope, "scope", opts.Scope, "Only convert gx dependencies imported by packages matching this pattern (e.g. ./cmd/...)")
+	flag.StringVar(&opts.Only, "only", opts.Only, "Only convert gx dependencies matching these comma separated import path globs")
+	flag.StringVar(&opts.Skip, "skip", opts.Skip, "Never convert gx dependencies matching these comma separated import path globs (takes precedence over --only)")
+	flag.BoolVar(&opts.RelocateReclassified, "relocate-on-reclassify", opts.RelocateReclassified, "Move packages whose embed/vendor classification changed since a previous run")
+	flag.StringVar(&opts.MetricsFile, "metrics-file", opts.MetricsFile, "Write conversion metrics into a Prometheus textfile")
+	flag.BoolVar(&opts.DependenciesOnly, "dependencies-only", opts.DependenciesOnly, "Install and classify the gx dependencies into the cache without converting")
+	flag.StringVar(&opts.EventsFile, "events", opts.EventsFile, "Stream conversion events as JSON lines into this file (- for stdout)")
+	flag.IntVar(&opts.Workers, "workers", opts.Workers, "Number of dependencies to classify concurrently")
+	flag.BoolVar(&opts.SuggestStdlib, "suggest-stdlib", opts.SuggestStdlib, "Report dependencies that could be replaced by the standard library")
+	flag.StringVar(&opts.Mode, "mode", opts.Mode, "Import embedded dependencies via rewritten paths (gopath) or go.mod replaces (modules)")
+	flag.StringVar(&opts.DumpMapping, "dump-mapping", opts.DumpMapping, "Write the gx hash to path mapping into this CSV file")
+	flag.StringVar(&opts.LibDir, "libdir", opts.LibDir, "Folder to embed gx based dependencies into, relative to the project root")
+	flag.StringVar(&opts.OnUnreadable, "on-unreadable", opts.OnUnreadable, "Policy for unreadable gx packages (skip or fail)")
+	flag.BoolVar(&opts.SummaryJSON, "summary-json", opts.SummaryJSON, "Print a compact JSON summary of the conversion to stdout")
+	flag.BoolVar(&opts.RewritePathConstants, "rewrite-path-constants", opts.RewritePathConstants, "Also rewrite string constants whose value is a rewritten import path")
+	flag.BoolVar(&opts.RequireOfflineDecisions, "require-offline-decisions", opts.RequireOfflineDecisions, "Fail if any embed/vendor decision would need a network probe")
+	flag.BoolVar(&opts.DedupeSemver, "dedupe-semver", opts.DedupeSemver, "Convert only the newest of multiple semver compatible versions of a dependency")
+	flag.BoolVar(&opts.KeepImportComments, "keep-import-comments", opts.KeepImportComments, "Rewrite import comments to the new import paths instead of removing them")
+	flag.BoolVar(&opts.NoFormat, "no-format", opts.NoFormat, "Do not gofmt the Go files modified by the rewrites")
+	flag.StringVar(&opts.ChangedFiles, "changed-files", opts.ChangedFiles, "Optional file to write the list of rewritten and moved files into")
+	flag.BoolVar(&opts.Verbose, "v", opts.Verbose, "Log every file rewritten, not just the per package progress")
+	flag.BoolVar(&opts.Quiet, "q", opts.Quiet, "Log nothing but errors")
+	flag.StringVar(&opts.OnlyRewrite, "only-rewrite", opts.OnlyRewrite, "Only rewrite imports using the rules of a previous conversion's manifest or report (or a JSON mapping)")
+	flag.IntVar(&opts.GetRetries, "get-retries", opts.GetRetries, "Number of times to retry failed go get downloads")
+	flag.DurationVar(&opts.GetBackoff, "get-backoff", opts.GetBackoff, "Initial backoff between go get retries")
+	flag.IntVar(&opts.MaxHTTPConns, "max-http-conns", opts.MaxHTTPConns, "Maximum number of concurrent outbound HTTP connections")
+	flag.DurationVar(&opts.HTTPTimeout, "http-timeout", opts.HTTPTimeout, "Timeout of a single outbound HTTP request")
+	flag.IntVar(&opts.HTTPRetries, "http-retries", opts.HTTPRetries, "Number of times to retry transient HTTP failures")
+	flag.DurationVar(&opts.InstallTimeout, "install-timeout", opts.InstallTimeout, "Maximum time allowed for gx install (0 = unlimited)")
+	flag.StringVar(&opts.OutputDir, "output", opts.OutputDir, "Convert a copy of the package in this directory instead of in place")
+	flag.StringVar(&opts.OutputDir, "o", opts.OutputDir, "Shorthand for --output")
+	flag.Var((*stringList)(&opts.ExcludeFiles), "exclude-file", "File to never rewrite, relative to the project root (repeatable)")
+
+	opts.GitHubRawHosts = make(map[string]string)
+	flag.Var(hostMapping(opts.GitHubRawHosts), "github-raw-host", "GitHub Enterprise host and its raw content endpoint as host=endpoint (repeatable)")
+}
+
+// stringList is a flag value collecting all occurrences of a repeatable flag.
+type stringList []string
+
+func (list *stringList) String() string     { return strings.Join(*list, ",") }
+func (list *stringList) Set(v string) error { *list = append(*list, v); return nil }
+
+// hostMapping is a flag value collecting host=endpoint pairs.
+type hostMapping map[string]string
+
+func (m hostMapping) String() string {
+	var pairs []string
+	for host, endpoint := range m {
+		pairs = append(pairs, host+"="+endpoint)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m hostMapping) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid host mapping %q, expected host=endpoint", value)
+	}
+	m[parts[0]] = parts[1]
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	// If requested, keep converting as dependencies get added
+	if *watch {
+		if err := watchProject(); err != nil {
+			log.Fatalf("Failed to watch project: %v", err)
+		}
+		return
+	}
+	// If two reports need to be compared, do that and skip everything else
+	if *reportDiff != "" {
+		if flag.NArg() != 1 {
+			log.Fatalf("Usage: ungx --report-diff A.json B.json")
+		}
+		a, err := ungx.LoadReport(*reportDiff)
+		if err != nil {
+			log.Fatalf("Failed to load report %s: %v", *reportDiff, err)
+		}
+		b, err := ungx.LoadReport(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Failed to load report %s: %v", flag.Arg(0), err)
+		}
+		diffs := ungx.DiffReports(a, b)
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+		if len(diffs) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	// If batch rewrites were requested, run them and skip everything else
+	if *stdin {
+		if failed := ungx.RewriteBatch(os.Stdin); failed > 0 {
+			log.Fatalf("Failed to process %d instructions", failed)
+		}
+		return
+	}
+	// Abort the conversion gracefully on Ctrl-C, letting it clean up after itself
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if _, err := ungx.ConvertContext(ctx, opts); err != nil {
+		if ctx.Err() != nil {
+			log.Printf("Failed to convert package: %v", err)
+			os.Exit(130)
+		}
+		log.Fatalf("Failed to convert package: %v", err)
+	}
+}
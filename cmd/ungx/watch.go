@@ -0,0 +1,139 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// watch keeps ungx running, converting newly added gx dependencies whenever
+	// the project's package.json or gx vendor folder changes.
+	watch = flag.Bool("watch", false, "Watch the project and convert newly added gx dependencies")
+
+	// watchDebounce is the time the watched files need to stay unchanged before
+	// a conversion is triggered, coalescing bursts of changes into one run.
+	watchDebounce = flag.Duration("watch-debounce", time.Second, "Quiet period after a change before reconverting")
+)
+
+// watchInterval is the frequency with which the watched paths are polled.
+const watchInterval = 250 * time.Millisecond
+
+// watchProject runs a conversion and then polls the project for changes to its
+// gx dependencies, rerunning the conversion after each burst of changes. Every
+// run is a separate ungx process so that a failed one doesn't end the watch.
+// Dependencies converted by an earlier run are already at their destination,
+// so each rerun only processes the newly added ones.
+func watchProject() error {
+	args := watchlessArgs(os.Args[1:])
+	for {
+		if !opts.Quiet {
+			log.Printf("Converting gx dependencies")
+		}
+		if err := convertOnce(args); err != nil {
+			log.Printf("Conversion failed: %v", err)
+		}
+		last, err := watchState(".")
+		if err != nil {
+			return err
+		}
+		if !opts.Quiet {
+			log.Printf("Watching package.json and vendor/gx for changes")
+		}
+		poll := func() (string, error) { return watchState(".") }
+		if err := waitForChange(poll, last, watchInterval, *watchDebounce); err != nil {
+			return err
+		}
+	}
+}
+
+// waitForChange polls the watched state until it differs from the last one, and
+// then until it stays unchanged for the debounce period, so a burst of changes
+// triggers a single conversion.
+func waitForChange(poll func() (string, error), last string, interval, debounce time.Duration) error {
+	changed := time.Time{}
+	for {
+		time.Sleep(interval)
+
+		state, err := poll()
+		if err != nil {
+			return err
+		}
+		if state != last {
+			last, changed = state, time.Now()
+			continue
+		}
+		if !changed.IsZero() && time.Since(changed) >= debounce {
+			return nil
+		}
+	}
+}
+
+// convertOnce runs a single conversion as a child ungx process.
+func convertOnce(args []string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(self, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// watchlessArgs strips the watch related flags from a command line, so it can
+// be used to run a single conversion.
+func watchlessArgs(args []string) []string {
+	var filtered []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" || !strings.HasPrefix(args[i], "-") {
+			return append(filtered, args[i:]...)
+		}
+		name := strings.TrimLeft(args[i], "-")
+		if idx := strings.Index(name, "="); idx >= 0 {
+			name = name[:idx]
+		} else if name == "watch-debounce" {
+			i++ // Value is in the next argument
+			continue
+		}
+		if name == "watch" || name == "watch-debounce" {
+			continue
+		}
+		filtered = append(filtered, args[i])
+	}
+	return filtered
+}
+
+// watchState returns a fingerprint of the paths watched for new dependencies
+// within a project: the top level package.json and the gx hashes present in the
+// vendor folder.
+func watchState(dir string) (string, error) {
+	var state string
+
+	info, err := os.Stat(filepath.Join(dir, "package.json"))
+	switch {
+	case err == nil:
+		state = fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+	case !os.IsNotExist(err):
+		return "", err
+	}
+	hashes, err := ioutil.ReadDir(filepath.Join(dir, "vendor", "gx", "ipfs"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	for _, hash := range hashes {
+		state += " " + hash.Name()
+	}
+	return state, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that the watched state changes when package.json is modified or a new
+// gx hash is installed, but not when nothing happened.
+func TestWatchState(t *testing.T) {
+	dir := t.TempDir()
+
+	state := func() string {
+		t.Helper()
+
+		state, err := watchState(dir)
+		if err != nil {
+			t.Fatalf("failed to fingerprint project: %v", err)
+		}
+		return state
+	}
+	empty := state()
+	if again := state(); again != empty {
+		t.Errorf("idle state changed: have %q, want %q", again, empty)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("failed to create package.json: %v", err)
+	}
+	created := state()
+	if created == empty {
+		t.Errorf("package.json creation not detected")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"gxDependencies": []}`), 0644); err != nil {
+		t.Fatalf("failed to update package.json: %v", err)
+	}
+	updated := state()
+	if updated == created {
+		t.Errorf("package.json update not detected")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "vendor", "gx", "ipfs", "QmFoo"), 0755); err != nil {
+		t.Fatalf("failed to install gx hash: %v", err)
+	}
+	if installed := state(); installed == updated {
+		t.Errorf("gx hash installation not detected")
+	}
+}
+
+// Tests that waiting for a change returns only after the watched state changed
+// and then settled for the debounce period, and aborts on a polling failure.
+func TestWaitForChange(t *testing.T) {
+	const (
+		interval = time.Millisecond
+		debounce = 20 * time.Millisecond
+	)
+	tests := []struct {
+		name   string
+		states []string // States returned by consecutive polls, the last repeating
+		fail   bool     // Whether polling fails after the states run out
+	}{
+		{"single change", []string{"a", "a", "b"}, false},
+		{"burst of changes", []string{"b", "c", "d", "e"}, false},
+		{"failed poll", []string{"a", "a"}, true},
+	}
+	for _, tt := range tests {
+		var (
+			polls   int
+			settled time.Time
+		)
+		poll := func() (string, error) {
+			polls++
+			if polls < len(tt.states) {
+				return tt.states[polls-1], nil
+			}
+			if tt.fail {
+				return "", errors.New("poll failed")
+			}
+			if polls == len(tt.states) {
+				settled = time.Now()
+			}
+			return tt.states[len(tt.states)-1], nil
+		}
+		err := waitForChange(poll, "a", interval, debounce)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: polling failure not reported", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to wait for change: %v", tt.name, err)
+			continue
+		}
+		if polls <= len(tt.states) {
+			t.Errorf("%s: returned before the change settled: %d polls", tt.name, polls)
+		}
+		if waited := time.Since(settled); waited < debounce {
+			t.Errorf("%s: debounce period cut short: waited %v, want %v", tt.name, waited, debounce)
+		}
+	}
+}
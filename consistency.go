@@ -0,0 +1,34 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// checkRewrites verifies that every gx package that was moved out of the gx
+// vendor folder has a matching import rewrite rule and that every gx rewrite
+// rule belongs to a moved package. Any mismatch is a bug in the mapping logic,
+// leaving stale imports or rewriting to packages that do not exist.
+func checkRewrites(moved []string, rewrite map[string]string) []string {
+	var mismatches []string
+
+	known := make(map[string]bool)
+	for _, path := range moved {
+		known[path] = true
+		if _, ok := rewrite[path]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("moved package %s has no rewrite rule", path))
+		}
+	}
+	for path := range rewrite {
+		if strings.HasPrefix(path, "gx/ipfs/") && !known[path] {
+			mismatches = append(mismatches, fmt.Sprintf("rewrite rule %s has no moved package", path))
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Tests that the consistency check detects moved packages without a rewrite rule
+// and gx rewrite rules without a moved package, ignoring the non-gx rules.
+func TestCheckRewrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		moved   []string
+		rewrite map[string]string
+		want    []string
+	}{
+		{
+			name:  "consistent",
+			moved: []string{"gx/ipfs/QmFoo/foo", "gx/ipfs/QmBar/bar"},
+			rewrite: map[string]string{
+				"gx/ipfs/QmFoo/foo": "example.com/proj/gxlibs/github.com/a/foo",
+				"gx/ipfs/QmBar/bar": "github.com/b/bar",
+				"github.com/a/foo":  "example.com/proj/gxlibs/github.com/a/foo",
+			},
+		},
+		{
+			name:    "missing rewrite rule",
+			moved:   []string{"gx/ipfs/QmFoo/foo", "gx/ipfs/QmBar/bar"},
+			rewrite: map[string]string{"gx/ipfs/QmFoo/foo": "github.com/a/foo"},
+			want:    []string{"moved package gx/ipfs/QmBar/bar has no rewrite rule"},
+		},
+		{
+			name:    "missing move",
+			moved:   []string{"gx/ipfs/QmFoo/foo"},
+			rewrite: map[string]string{"gx/ipfs/QmFoo/foo": "github.com/a/foo", "gx/ipfs/QmBar/bar": "github.com/b/bar"},
+			want:    []string{"rewrite rule gx/ipfs/QmBar/bar has no moved package"},
+		},
+		{
+			name:    "both directions",
+			moved:   []string{"gx/ipfs/QmFoo/foo"},
+			rewrite: map[string]string{"gx/ipfs/QmBar/bar": "github.com/b/bar"},
+			want: []string{
+				"moved package gx/ipfs/QmFoo/foo has no rewrite rule",
+				"rewrite rule gx/ipfs/QmBar/bar has no moved package",
+			},
+		},
+	}
+	for _, tt := range tests {
+		if have := checkRewrites(tt.moved, tt.rewrite); !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%s: mismatches: have %v, want %v", tt.name, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,1444 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// conversion is the state of a single conversion, threaded through all of its
+// steps, so independent conversions never interfere with each other.
+type conversion struct {
+	config Options // Configuration of the conversion, with the defaults filled in
+	fsys   FS      // File system the conversion operates on
+
+	interrupt context.Context // Cancelled if the conversion needs to be aborted midway
+	modified  int32           // Set once the project is being modified (atomic)
+
+	httpClient    *http.Client  // Client for all the network probes
+	httpSlots     chan struct{} // Semaphore enforcing the connection cap
+	networkProbes int64         // Embed decisions that needed network access (atomic)
+
+	githubRawHosts map[string]string // GitHub hosts mapped to their raw content endpoints
+	repoHosts      map[string]int    // Code hosts mapped to the path segments of a repo root
+
+	vanities     map[string]string // Repositories that vanity import paths resolved to
+	vanitiesLock sync.Mutex        // Protects the vanity cache from concurrent probes
+
+	embedDecisions *embedCache                   // Decision cache used by shouldEmbed
+	onPackageMoved func(path, dest string) error // Optional post-move callback
+
+	moves []move  // Relocations recorded in patch mode
+	undo  undoLog // Operations performed on the project, for the undo script
+}
+
+// newConversion sets up the state of a new conversion with the given options,
+// defaulting the unset ones that have no meaningful zero value.
+func newConversion(opts Options) *conversion {
+	defaults := DefaultOptions()
+	if opts.Mode == "" {
+		opts.Mode = defaults.Mode
+	}
+	if opts.LibDir == "" {
+		opts.LibDir = defaults.LibDir
+	}
+	if opts.OnUnreadable == "" {
+		opts.OnUnreadable = defaults.OnUnreadable
+	}
+	c := &conversion{
+		config:         opts,
+		fsys:           opts.FS,
+		interrupt:      context.Background(),
+		githubRawHosts: knownGitHubHosts(),
+		repoHosts:      knownRepoHosts(),
+		vanities:       make(map[string]string),
+		embedDecisions: newEmbedCache(""),
+		onPackageMoved: opts.OnPackageMoved,
+	}
+	if c.fsys == nil {
+		c.fsys = osFS{}
+	}
+	c.httpClient, c.httpSlots = newHTTPClient(opts.MaxHTTPConns, opts.HTTPTimeout)
+	for host, endpoint := range opts.GitHubRawHosts {
+		c.addGitHubHost(host, endpoint)
+	}
+	return c
+}
+
+// Convert converts the gx based package in the current working directory (or
+// the root of the custom file system) into a plain Go one, returning the report
+// of the actions taken.
+//
+// Convert may be called repeatedly and from multiple goroutines, each conversion
+// keeping its own state. A conversion never changes the working directory nor
+// installs any signal handlers, it's up to the caller to cancel it via
+// ConvertContext.
+func Convert(opts Options) (*Report, error) {
+	return ConvertContext(context.Background(), opts)
+}
+
+// ConvertContext is like Convert, but aborts the conversion if the context is
+// cancelled, killing any gx install in progress and returning an error. Package
+// moves done before the abort are not reverted (use the UndoScript for that).
+func ConvertContext(ctx context.Context, opts Options) (*Report, error) {
+	// Signal the end of the event stream however the conversion ends
+	if opts.Events != nil {
+		defer close(opts.Events)
+	}
+	start := time.Now()
+
+	c := newConversion(opts)
+	c.interrupt = ctx
+
+	report, err := c.convert()
+	if err != nil && ctx.Err() != nil {
+		c.warnPartial()
+	}
+	// Scripts rely on the summary, so print it for failed conversions too
+	if opts.SummaryJSON {
+		summary := report
+		if summary == nil {
+			summary = new(Report)
+		}
+		failures := summary.failures
+		if err != nil && failures == 0 {
+			failures = 1
+		}
+		if perr := summary.printSummary(os.Stdout, time.Since(start), failures); perr != nil && err == nil {
+			err = fmt.Errorf("failed to print summary: %v", perr)
+		}
+	}
+	return report, err
+}
+
+// convert runs a conversion, see Convert for the details.
+func (c *conversion) convert() (*Report, error) {
+	start := time.Now()
+
+	if c.config.Mode != "gopath" && c.config.Mode != "modules" {
+		return nil, fmt.Errorf("unknown conversion mode %q, must be gopath or modules", c.config.Mode)
+	}
+	if c.config.LibDir = filepath.Clean(c.config.LibDir); !validLibDir(c.config.LibDir) {
+		return nil, fmt.Errorf("embed folder must be a subfolder of the project outside of vendor: %s", c.config.LibDir)
+	}
+	if c.config.OnUnreadable != "skip" && c.config.OnUnreadable != "fail" {
+		return nil, fmt.Errorf("unknown unreadable package policy %q, must be skip or fail", c.config.OnUnreadable)
+	}
+	if c.config.OnlyEmbed && c.config.OnlyVendor {
+		return nil, fmt.Errorf("only one of --only-embed and --only-vendor may be set")
+	}
+	if !c.onDisk() {
+		if c.config.ImportPath == "" {
+			return nil, fmt.Errorf("converting on a custom file system needs the import path set explicitly")
+		}
+		if c.config.OutputDir != "" || c.config.GitCommits || c.config.GitMoves || c.config.PerPackageHook != "" || c.config.Scope != "" || c.config.Verify || c.config.VerifyImports {
+			return nil, fmt.Errorf("--output, --git-commit, --git-mv, --per-package-hook, --scope and --verify need the operating system's file system")
+		}
+	}
+	if c.config.SummaryJSON && c.config.EventsFile == "-" {
+		return nil, fmt.Errorf("--events - cannot be combined with --summary-json, both write to stdout")
+	}
+	if c.config.OutputDir != "" {
+		if c.config.GitCommits || c.config.GitMoves || c.config.Patch != "" || c.config.DryRun {
+			return nil, fmt.Errorf("--output cannot be combined with --git-commit, --git-mv, --patch or --dry-run")
+		}
+	}
+	embeds := make(map[string]bool)
+	for _, embed := range strings.Split(c.config.Embed, ",") {
+		embeds[embed] = true
+	}
+	// Ensure we can actually modify the project before doing any partial work
+	if !c.config.DryRun && c.config.OutputDir == "" {
+		if err := c.checkWritable("."); err != nil {
+			return nil, fmt.Errorf("project directory is not writable, ungx needs write permission to convert it in place: %v", err)
+		}
+	}
+	if c.config.GitCommits {
+		if c.config.Patch != "" || c.config.DryRun {
+			return nil, fmt.Errorf("--git-commit cannot be combined with --patch or --dry-run")
+		}
+		if !c.gitRepo() {
+			c.logWarn("Warning, not inside a git repository, skipping commits")
+			c.config.GitCommits = false
+		} else if dirty, err := c.gitDirty(); err != nil {
+			return nil, fmt.Errorf("failed to check git status: %v", err)
+		} else if dirty {
+			return nil, fmt.Errorf("--git-commit needs a clean working tree, commit or stash the pending changes first")
+		}
+	}
+	excluded := make(map[string]bool)
+	for _, file := range c.config.ExcludeFiles {
+		excluded[filepath.Clean(file)] = true
+	}
+	if c.config.GitMoves && !c.gitRepo() {
+		c.logWarn("Warning, not inside a git repository, moving packages without git mv")
+		c.config.GitMoves = false
+	}
+	if c.config.DependenciesOnly && c.config.CacheFile == "" {
+		return nil, fmt.Errorf("dependencies only mode needs a --cache to store the decisions in")
+	}
+	if c.config.CacheFile != "" {
+		cache, err := loadEmbedCache(c.config.CacheFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load decision cache: %v", err)
+		}
+		c.embedDecisions = cache
+	}
+	if c.config.PerPackageHook != "" {
+		callback, command := c.onPackageMoved, c.commandHook(c.config.PerPackageHook)
+		c.onPackageMoved = func(path, dest string) error {
+			if callback != nil {
+				if err := callback(path, dest); err != nil {
+					return err
+				}
+			}
+			return command(path, dest)
+		}
+	}
+	var filter *regexp.Regexp
+	if c.config.RewriteIf != "" {
+		var err error
+		if filter, err = regexp.Compile(c.config.RewriteIf); err != nil {
+			return nil, fmt.Errorf("failed to parse rewrite filter: %v", err)
+		}
+	}
+	// Create a temporary Go workspace to download canonical packages into
+	workspace, err := ioutil.TempDir("", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary workspace: %v", err)
+	}
+	defer os.RemoveAll(workspace)
+
+	// Resolve the current package's import path, unless explicitly specified
+	root := []byte(c.config.ImportPath)
+	if len(root) == 0 {
+		if root, err = c.resolveRoot(); err != nil {
+			return nil, fmt.Errorf("failed to resolve package import path: %v", err)
+		}
+	}
+	// If requested, convert a copy of the package, keeping the import path
+	if c.config.OutputDir != "" {
+		if err := c.copyPackage(c.config.OutputDir); err != nil {
+			return nil, fmt.Errorf("failed to copy package to output directory: %v", err)
+		}
+	}
+	// Guard the project against concurrent conversions stepping on each other
+	if !c.readonly() {
+		unlock, err := c.acquireLock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock project: %v", err)
+		}
+		defer unlock()
+	}
+	// If only the rewrite was requested, reapply the rules of a previous conversion
+	if c.config.OnlyRewrite != "" {
+		rules, err := loadRewrites(c.config.OnlyRewrite)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rewrite rules: %v", err)
+		}
+		depped, err := c.depProjects()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dep lock file: %v", err)
+		}
+		c.logInfo("Rewriting import statements with %d rules from %s", len(rules), c.config.OnlyRewrite)
+
+		var diff bytes.Buffer
+		summary := &Report{Root: string(root)}
+		rules = c.forkRules(rules, string(root))
+		writes, err := c.rewriteTree(rules, string(root), depped, excluded, filter, summary, &diff)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rewrite import paths: %v", err)
+		}
+		if err := c.applyRewrites(writes, &diff); err != nil {
+			return nil, err
+		}
+		if err := c.reportChangedFiles(); err != nil {
+			return nil, err
+		}
+		if c.config.DryRun {
+			c.printPlan(summary)
+		}
+		summary.Rewrites = rules
+		if c.config.Verify && !c.readonly() {
+			c.logInfo("Verifying that the converted package builds")
+			if err := c.verifyBuild(); err != nil {
+				return nil, fmt.Errorf("failed to build converted package: %v", err)
+			}
+		}
+		c.logInfo("Rewrite finished in %v, %d files changed", time.Since(start), len(summary.Rewritten))
+		return summary, nil
+	}
+	// If a previous run already converted everything, don't reinstall the gx copies,
+	// unless packages need relocating since their classification changed
+	if prev, err := c.loadManifest(manifestFile); err == nil && prev.converted(c.fsys) {
+		if !c.config.RelocateReclassified || !c.reclassified(prev, workspace, embeds) {
+			c.logInfo("Package already converted (see %s), nothing to do", manifestFile)
+			return &Report{Root: string(root), Rewrites: prev.Rewrites}, nil
+		}
+		c.logInfo("Classification changed since the previous conversion, relocating packages")
+	}
+	// Retrieve all the gx dependencies into the local vendor folder
+	gxpkgs := filepath.Join("vendor", "gx", "ipfs")
+
+	var changes []ReportChange
+	if c.config.DryRun {
+		// Dry runs must not touch the tree, use whatever gx installed previously
+		if _, err := c.fsys.Stat(gxpkgs); err != nil {
+			return nil, fmt.Errorf("dry run needs previously installed gx dependencies, run `gx install --local` first")
+		}
+	} else if !c.onDisk() {
+		// Custom file systems are out of gx's reach, use whatever was put into them
+		c.logInfo("Using the preinstalled gx dependencies of the custom file system")
+	} else if changes, err = c.installDeps(); err != nil {
+		return &Report{Root: string(root), Changed: changes}, fmt.Errorf("failed to install gx dependencies: %v", err)
+	}
+	// Collect any dep managed projects to avoid messing with their vendored code
+	depped, err := c.depProjects()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dep lock file: %v", err)
+	}
+	// Find all the unique import paths (duplicates remain unmodified)
+
+	hashes, err := c.fsys.ReadDir(gxpkgs)
+	if err != nil {
+		// Packages without gx dependencies get no vendor folder, nothing to move
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to list vendored packages: %v", err)
+		}
+		c.logInfo("No gx dependencies found")
+	}
+	// If nothing was left to convert after all, don't clobber the manifest either
+	if len(hashes) == 0 {
+		if prev, err := c.loadManifest(manifestFile); err == nil {
+			c.logInfo("Package already converted (see %s), nothing to do", manifestFile)
+			return &Report{Root: string(root), Rewrites: prev.Rewrites, Changed: changes}, nil
+		}
+	}
+	// Stream the report entries from the first one on, failed loads included
+	summary := &Report{Root: string(root), events: c.config.Events}
+	if c.config.EventsFile != "" {
+		stream, done, err := c.streamEvents(c.config.EventsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open event stream: %v", err)
+		}
+		summary.stream = stream
+		defer done()
+	}
+	defer summary.finish()
+
+	for _, change := range changes {
+		summary.changed(change)
+	}
+
+	versions := make(map[string]int)
+	mappings := make(map[string]string)
+	binaries := make(map[string]bool)
+	metadata := make(map[string]bool)
+	dvcsimports := make(map[string]string)
+	specDirs := make(map[string]string)
+	releases := make(map[string]string)
+
+	names := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		names = append(names, hash.Name())
+	}
+	specs, failed := c.loadSpecs(gxpkgs, names, runtime.NumCPU())
+	for hash, spec := range specs {
+		mappings[hash] = spec.path()
+		dvcsimports[hash] = spec.Gx.Path
+		specDirs[hash] = spec.dir
+		releases[hash] = spec.Version
+		if spec.executable() {
+			binaries[hash] = true
+		}
+		if spec.metadata {
+			metadata[hash] = true
+		}
+	}
+
+	// Skip the packages that failed to load, reporting them in directory order.
+	// Unless requested otherwise, unreadable packages fail the run at the end.
+	var failures []string
+	unreadable := func(failure string) {
+		if c.config.OnUnreadable != "skip" {
+			failures = append(failures, failure)
+		}
+	}
+	for _, hash := range hashes {
+		if err := failed[hash.Name()]; err != nil {
+			c.logInfo("Skipping gx/ipfs/%s, failed to load: %v", hash.Name(), err)
+			unreadable(fmt.Sprintf("failed to load gx/ipfs/%s: %v", hash.Name(), err))
+			summary.add(hash.Name(), "", "skip", "", fmt.Sprintf("failed to load: %v", err))
+		}
+	}
+	// Fold paths differing only in casing and count the versions of each package
+	c.unifyPathCase(mappings)
+	for hash, path := range mappings {
+		if !metadata[hash] {
+			versions[path]++
+		}
+	}
+	// If requested, collapse semver compatible versions into the newest release
+	superseded := make(map[string]string)
+	if c.config.DedupeSemver {
+		candidates := make(map[string]string)
+		for hash, path := range mappings {
+			if !metadata[hash] && !binaries[hash] {
+				candidates[hash] = path
+			}
+		}
+		superseded = dedupeVersions(candidates, releases)
+		for hash := range superseded {
+			versions[mappings[hash]]--
+		}
+	}
+	// If requested, ensure the vendored packages weren't tampered with
+	if c.config.VerifyCID {
+		c.logInfo("Verifying gx package content hashes")
+		for _, hash := range hashes {
+			// Only CIDv0 hashes are plain multihashes we can recompute
+			if !strings.HasPrefix(hash.Name(), "Qm") {
+				continue
+			}
+			cid, err := c.computeCID(filepath.Join(gxpkgs, hash.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash package contents: %v", err)
+			}
+			if cid != hash.Name() {
+				c.logWarn("Warning, gx/ipfs/%s (%s) content hash mismatch: %s", hash.Name(), mappings[hash.Name()], cid)
+			}
+		}
+	}
+	// If the conversion was scoped, find the dependencies reachable from it
+	var scoped map[string]bool
+	if c.config.Scope != "" {
+		if scoped, err = c.scopedHashes(c.config.Scope); err != nil {
+			return nil, fmt.Errorf("failed to list dependencies of %s: %v", c.config.Scope, err)
+		}
+		c.logInfo("Converting %d of %d gx dependencies imported by %s", len(scoped), len(mappings), c.config.Scope)
+	}
+	// Move the package from hash to canonical path. Destinations recorded by a
+	// previous (phase restricted) run hold converted copies of the same hashes
+	previous, _ := c.loadManifest(manifestFile)
+
+	// Packages relocated from their previous classification's folder, whose fresh
+	// gx copies are dropped the same way as the ones recorded by the manifest
+	reclassified := make(map[string]bool)
+
+	var (
+		rewrite = make(map[string]string)
+		moved   []string
+	)
+	requires := make(map[string]string)
+	replaces := make(map[string]string)
+
+	// Classify all the dependencies concurrently up front, moves are done serially
+	var probes []string
+	refs := make(map[string]string)
+	for hash, path := range mappings {
+		if _, ok := superseded[hash]; ok || binaries[hash] || metadata[hash] || versions[path] > 1 || embeds[path] || (scoped != nil && !scoped[hash]) || !c.selectedPath(path) {
+			continue
+		}
+		// First-party collisions are refused anyway, don't probe them
+		if c.ownPackage(string(root), path) {
+			continue
+		}
+		probes = append(probes, path)
+		refs[path] = releaseRef(releases[hash])
+	}
+	probes = uniqueSorted(probes)
+
+	// If hermetic conversion was requested, refuse to touch the network
+	if c.config.RequireOfflineDecisions {
+		if undecided := c.undecidedPaths(probes, refs); len(undecided) > 0 {
+			return nil, fmt.Errorf("failed to classify offline, %d dependencies need a network probe (cache or --embed them):\n\t%s", len(undecided), strings.Join(undecided, "\n\t"))
+		}
+	}
+	c.logInfo("Classifying %d gx dependencies", len(probes))
+	started := time.Now()
+	decisions := c.classifyPaths(workspace, probes, refs, c.config.Workers)
+	if err := c.interrupted(); err != nil {
+		return nil, err
+	}
+	c.logInfo("Classified %d gx dependencies in %v", len(probes), time.Since(started))
+
+	// If only the dependencies were requested, stop after classifying them
+	if c.config.DependenciesOnly {
+		var embedded, vendored int
+		for _, path := range probes {
+			if decisions[path] {
+				embedded++
+			} else {
+				vendored++
+			}
+		}
+		c.logInfo("Classified gx dependencies: %d to embed, %d to vendor", embedded, vendored)
+		if len(failures) > 0 {
+			summary.failures = len(failures)
+			return summary, fmt.Errorf("failed to load %d gx dependencies", len(failures))
+		}
+		return summary, nil
+	}
+	c.logInfo("Converting gx dependencies to canonical paths")
+	for hash, path := range mappings {
+		if err := c.interrupted(); err != nil {
+			return nil, err
+		}
+		// Dependencies not imported from the requested scope are left as is
+		if scoped != nil && !scoped[hash] {
+			c.logInfo("Skipping gx/ipfs/%s (%s), not imported by %s", hash, path, c.config.Scope)
+			summary.add(hash, path, "skip", "", "outside of the requested scope")
+			continue
+		}
+		// Dependencies filtered out via --only or --skip are left as is
+		if !c.selectedPath(path) {
+			c.logInfo("Skipping gx/ipfs/%s (%s), filtered out via --only or --skip", hash, path)
+			summary.add(hash, path, "skip", "", "filtered out")
+			continue
+		}
+		// Metadata only packages have nothing to import, don't create dangling rules
+		if metadata[hash] {
+			c.logInfo("Skipping gx/ipfs/%s (%s), metadata only package without Go code", hash, path)
+			summary.add(hash, path, "skip", "", "no Go code")
+			continue
+		}
+		// Executable packages aren't importable, there's no point in moving them
+		if binaries[hash] {
+			c.logInfo("Skipping gx/ipfs/%s (%s), executable package, not an importable library", hash, path)
+			summary.add(hash, path, "skip", "", "executable package")
+			continue
+		}
+		// Superseded versions are redirected once the newest version is converted
+		if _, ok := superseded[hash]; ok {
+			continue
+		}
+		clash := versions[path] > 1
+		if !clash && c.ownPackage(string(root), path) {
+			c.logWarn("Refusing to convert gx/ipfs/%s, %s collides with a first-party package", hash, path)
+			summary.add(hash, path, "skip", "", "collides with first-party package")
+			continue
+		}
+		// Classify the dependency and skip it if it's outside the requested phase
+		embedded := clash || embeds[path] || decisions[path]
+
+		target := filepath.Join(c.vendorDir(), path)
+		switch {
+		case clash:
+			target = filepath.Join(c.config.LibDir, "ipfs", clashDir(hash, releases[hash]))
+		case embedded:
+			target = filepath.Join(c.config.LibDir, path)
+		}
+		// If a previous run classified the package differently, move that copy over
+		if other := filepath.Join(c.config.LibDir, path); !clash && c.vendorDir() != c.config.LibDir {
+			if embedded {
+				other = filepath.Join("vendor", path)
+			}
+			if _, err := c.fsys.Stat(other); err == nil {
+				_, exists := c.fsys.Stat(target)
+				switch {
+				case !c.config.RelocateReclassified:
+					c.logWarn("Warning, %s also present at %s from a previous run", path, other)
+				case !previous.moved(hash, other):
+					c.logWarn("Warning, %s present at %s, but not recorded as converted from gx/ipfs/%s, keeping it", path, other, hash)
+				case exists == nil:
+					c.logWarn("Warning, %s present at both %s and %s, keeping both", path, other, target)
+				default:
+					c.logInfo("Relocating reclassified %s to %s", other, target)
+					if err := c.mkdir(filepath.Dir(target)); err != nil {
+						return nil, fmt.Errorf("failed to create canonical path: %v", err)
+					}
+					if err := c.relocate(other, target, false); err != nil {
+						return nil, fmt.Errorf("failed to relocate reclassified package: %v", err)
+					}
+					reclassified[hash] = true
+					if embedded {
+						if c.config.Mode != "modules" {
+							rewrite[path] = string(root) + "/" + c.libPath() + "/" + path
+						}
+					} else if c.config.Mode != "modules" {
+						rewrite[string(root)+"/"+c.libPath()+"/"+path] = path
+					}
+				}
+			}
+		}
+		if (c.config.OnlyEmbed && !embedded) || (c.config.OnlyVendor && embedded) {
+			// If a previous run already converted it, drop the reinstalled copy
+			if _, err := c.fsys.Stat(target); err != nil {
+				c.logInfo("Skipping gx/ipfs/%s (%s) in this phase", hash, path)
+				summary.add(hash, path, "skip", "", "outside of the requested phase")
+				continue
+			}
+		}
+		// Clashing dependencies cannot be rewritten, so they need to be embedded
+		if clash {
+			if err := c.mkdir(filepath.Join(c.config.LibDir, "ipfs")); err != nil {
+				return nil, fmt.Errorf("failed to create canonical embed path: %v", err)
+			}
+			c.logInfo("Embedding gx/ipfs/%s (%s %s) to %s", hash, path, releases[hash], target)
+			if err := c.relocate(filepath.Join(gxpkgs, hash), target, previous.moved(hash, target)); err != nil {
+				return nil, fmt.Errorf("failed to move embedded package: %v", err)
+			}
+			rewrite["gx/ipfs/"+hash] = string(root) + "/" + filepath.ToSlash(target)
+			moved = append(moved, "gx/ipfs/"+hash)
+			summary.add(hash, path, "embed", target, "multiple versions")
+			if c.config.Provenance && !c.readonly() {
+				dirs, err := c.fsys.ReadDir(target)
+				if err != nil {
+					return nil, fmt.Errorf("failed to list package contents: %v", err)
+				}
+				for _, dir := range dirs {
+					if dir.IsDir() {
+						if err := c.writeProvenance(filepath.Join(target, dir.Name()), hash+"/"+dir.Name(), path); err != nil {
+							return nil, fmt.Errorf("failed to write provenance file: %v", err)
+						}
+					}
+				}
+			}
+			if err := c.packageMoved(path, target); err != nil {
+				return nil, fmt.Errorf("post-move hook failed for %s: %v", path, err)
+			}
+
+			continue
+		}
+		// If requested, try to depend on gx-based dependencies as proper modules
+		if embedded && c.config.ReplaceWithRequire && !embeds[path] {
+			version, err := c.moduleVersion(path, releaseRef(releases[hash]))
+			if err != nil {
+				c.logDebug("Embedding %s, release %s not resolvable as module: %v", path, releases[hash], err)
+			} else {
+				dirs, err := c.fsys.ReadDir(filepath.Join(gxpkgs, hash))
+				if err != nil {
+					c.logInfo("Skipping gx/ipfs/%s (%s), failed to list contents: %v", hash, path, err)
+					unreadable(fmt.Sprintf("failed to list gx/ipfs/%s: %v", hash, err))
+					summary.add(hash, path, "skip", "", "unreadable package")
+					continue
+				}
+				c.logInfo("Requiring gx/ipfs/%s (%s) as module version %s", hash, path, version)
+				for _, dir := range dirs {
+					rewrite["gx/ipfs/"+hash+"/"+dir.Name()] = nestedPath(path, dir.Name(), specDirs[hash], len(dirs))
+					moved = append(moved, "gx/ipfs/"+hash+"/"+dir.Name())
+				}
+				requires[path] = version
+				summary.add(hash, path, "require", "", "resolvable as module "+version)
+
+				if !c.readonly() {
+					if err := c.fsys.RemoveAll(filepath.Join(gxpkgs, hash)); err != nil {
+						return nil, fmt.Errorf("failed to remove gx leftover: %v", err)
+					}
+				} else if c.config.DryRun {
+					c.logInfo("Would remove %s", filepath.Join(gxpkgs, hash))
+				}
+				continue
+			}
+		}
+		// Any gx-based dependency should be embedded directly to allow library reuse
+		if embedded {
+			dirs, err := c.fsys.ReadDir(filepath.Join(gxpkgs, hash))
+			if err != nil {
+				c.logInfo("Skipping gx/ipfs/%s (%s), failed to list contents: %v", hash, path, err)
+				unreadable(fmt.Sprintf("failed to list gx/ipfs/%s: %v", hash, err))
+				summary.add(hash, path, "skip", "", "unreadable package")
+				continue
+			}
+			for _, dir := range specFirst(dirs, specDirs[hash]) {
+				subpath := nestedPath(path, dir.Name(), specDirs[hash], len(dirs))
+				if err := c.mkdir(filepath.Join(c.config.LibDir, filepath.Dir(subpath))); err != nil {
+					return nil, fmt.Errorf("failed to create canonical embed path: %v", err)
+				}
+				c.logInfo("Embedding gx/ipfs/%s/%s to %s", hash, dir.Name(), filepath.Join(c.config.LibDir, subpath))
+				if err := c.relocate(filepath.Join(gxpkgs, hash, dir.Name()), filepath.Join(c.config.LibDir, subpath), reclassified[hash] || previous.moved(hash, filepath.Join(c.config.LibDir, subpath))); err != nil {
+					return nil, fmt.Errorf("failed to move embedded package: %v", err)
+				}
+				moved = append(moved, "gx/ipfs/"+hash+"/"+dir.Name())
+
+				// In modules mode imports keep the canonical path, go.mod redirects them
+				if c.config.Mode == "modules" {
+					rewrite["gx/ipfs/"+hash+"/"+dir.Name()] = subpath
+				} else {
+					rewrite["gx/ipfs/"+hash+"/"+dir.Name()] = string(root) + "/" + c.libPath() + "/" + subpath
+				}
+			}
+			// Imports of the canonical path (e.g. self imports of upstream packages midway
+			// through a gx migration) must converge to the same embedded copy as the hashes
+			if c.config.Mode == "modules" {
+				replaces[path] = filepath.Join(c.config.LibDir, path)
+			} else {
+				rewrite[path] = string(root) + "/" + c.libPath() + "/" + path
+			}
+			reason := "gx based upstream"
+			if embeds[path] {
+				reason = "forced via --embed"
+			}
+			summary.add(hash, path, "embed", target, reason)
+			// Record the provenance in every moved folder, the canonical path itself may
+			// not hold any Go code if the hash contains multiple folders
+			if c.config.Provenance && !c.readonly() {
+				for _, dir := range dirs {
+					if !dir.IsDir() {
+						continue
+					}
+					subpath := nestedPath(path, dir.Name(), specDirs[hash], len(dirs))
+					if err := c.writeProvenance(filepath.Join(c.config.LibDir, subpath), hash+"/"+dir.Name(), subpath); err != nil {
+						return nil, fmt.Errorf("failed to write provenance file: %v", err)
+					}
+				}
+			}
+			if err := c.packageMoved(path, target); err != nil {
+				return nil, fmt.Errorf("post-move hook failed for %s: %v", path, err)
+			}
+		} else {
+			// Non-clashing plain Go dependencies can be vendored in, unless dep already did
+			dirs, err := c.fsys.ReadDir(filepath.Join(gxpkgs, hash))
+			if err != nil {
+				c.logInfo("Skipping gx/ipfs/%s (%s), failed to list contents: %v", hash, path, err)
+				unreadable(fmt.Sprintf("failed to list gx/ipfs/%s: %v", hash, err))
+				summary.add(hash, path, "skip", "", "unreadable package")
+				continue
+			}
+			if project := depManaged(depped, filepath.Join("vendor", path)); project != "" {
+				// Dep's copy stays, but the gx imports still need to point to it
+				c.logInfo("Package %s already vendored by dep via %s, keeping dep's version", path, project)
+				for _, dir := range dirs {
+					rewrite["gx/ipfs/"+hash+"/"+dir.Name()] = nestedPath(path, dir.Name(), specDirs[hash], len(dirs))
+					moved = append(moved, "gx/ipfs/"+hash+"/"+dir.Name())
+				}
+				summary.add(hash, path, "vendor", target, "vendored by dep via "+project)
+
+				if !c.readonly() {
+					if err := c.fsys.RemoveAll(filepath.Join(gxpkgs, hash)); err != nil {
+						return nil, fmt.Errorf("failed to remove gx leftover: %v", err)
+					}
+				} else if c.config.DryRun {
+					c.logInfo("Would remove %s", filepath.Join(gxpkgs, hash))
+				}
+				continue
+			}
+			for _, dir := range specFirst(dirs, specDirs[hash]) {
+				subpath := nestedPath(path, dir.Name(), specDirs[hash], len(dirs))
+				if err := c.mkdir(filepath.Join(c.vendorDir(), filepath.Dir(subpath))); err != nil {
+					return nil, fmt.Errorf("failed to create canonical vendor path: %v", err)
+				}
+				c.logInfo("Vendoring gx/ipfs/%s/%s to %s", hash, dir.Name(), filepath.Join(c.vendorDir(), subpath))
+				if err := c.relocate(filepath.Join(gxpkgs, hash, dir.Name()), filepath.Join(c.vendorDir(), subpath), reclassified[hash] || previous.moved(hash, filepath.Join(c.vendorDir(), subpath))); err != nil {
+					return nil, fmt.Errorf("failed to move vendored package: %v", err)
+				}
+				rewrite["gx/ipfs/"+hash+"/"+dir.Name()] = subpath
+				moved = append(moved, "gx/ipfs/"+hash+"/"+dir.Name())
+			}
+			if c.config.Mode == "modules" {
+				replaces[path] = target
+			}
+			summary.add(hash, path, "vendor", target, "plain Go upstream")
+			if err := c.packageMoved(path, target); err != nil {
+				return nil, fmt.Errorf("post-move hook failed for %s: %v", path, err)
+			}
+		}
+		// Delete the empty hash dependency path
+		if err := c.rmdir(filepath.Join(gxpkgs, hash)); err != nil {
+			return nil, fmt.Errorf("failed to remove gx leftover: %v", err)
+		}
+	}
+	// Redirect the superseded versions of deduplicated dependencies to the newest
+	for _, hash := range sortedPaths(superseded) {
+		path, newest := mappings[hash], superseded[hash]
+
+		dirs, err := c.fsys.ReadDir(filepath.Join(gxpkgs, hash))
+		if err != nil {
+			c.logInfo("Skipping gx/ipfs/%s (%s), failed to list contents: %v", hash, path, err)
+			unreadable(fmt.Sprintf("failed to list gx/ipfs/%s: %v", hash, err))
+			summary.add(hash, path, "skip", "", "unreadable package")
+			continue
+		}
+		converted := true
+		for _, dir := range dirs {
+			if _, ok := rewrite["gx/ipfs/"+newest+"/"+dir.Name()]; !ok {
+				converted = false
+			}
+		}
+		if !converted {
+			c.logInfo("Skipping gx/ipfs/%s (%s), superseding gx/ipfs/%s was not converted", hash, path, newest)
+			summary.add(hash, path, "skip", "", "superseding version not converted")
+			continue
+		}
+		c.logInfo("Deduplicating gx/ipfs/%s (%s %s) into gx/ipfs/%s (%s)", hash, path, releases[hash], newest, releases[newest])
+		for _, dir := range dirs {
+			rewrite["gx/ipfs/"+hash+"/"+dir.Name()] = rewrite["gx/ipfs/"+newest+"/"+dir.Name()]
+			moved = append(moved, "gx/ipfs/"+hash+"/"+dir.Name())
+		}
+		summary.add(hash, path, "dedupe", "", fmt.Sprintf("superseded by %s (gx/ipfs/%s)", releases[newest], newest))
+
+		if !c.readonly() {
+			if err := c.fsys.RemoveAll(filepath.Join(gxpkgs, hash)); err != nil {
+				return nil, fmt.Errorf("failed to remove gx leftover: %v", err)
+			}
+		} else if c.config.DryRun {
+			c.logInfo("Would remove %s", filepath.Join(gxpkgs, hash))
+		}
+	}
+	// Sanity check that every moved package got rewritten and vice versa
+	if mismatches := checkRewrites(moved, rewrite); len(mismatches) > 0 {
+		for _, mismatch := range mismatches {
+			c.logWarn("Inconsistent conversion: %s", mismatch)
+		}
+		if c.config.Strict {
+			return nil, fmt.Errorf("failed consistency check: %d mismatches", len(mismatches))
+		}
+	}
+	// Add any dependencies resolved as modules to the go.mod file
+	if len(requires) > 0 {
+		if !c.readonly() {
+			c.logInfo("Adding %d module requirements to go.mod", len(requires))
+			if err := c.addModuleRequires(string(root), requires); err != nil {
+				return nil, fmt.Errorf("failed to update go.mod: %v", err)
+			}
+		} else if c.config.DryRun {
+			c.logInfo("Would add %d module requirements to go.mod", len(requires))
+		}
+	}
+	// Point the canonical paths of embedded dependencies to their local copies
+	if len(replaces) > 0 {
+		if !c.readonly() {
+			c.logInfo("Adding %d module replacements to go.mod", len(replaces))
+			if err := c.addModuleReplaces(string(root), replaces, rewrite); err != nil {
+				return nil, fmt.Errorf("failed to update go.mod: %v", err)
+			}
+		} else if c.config.DryRun {
+			c.logInfo("Would add %d module replacements to go.mod", len(replaces))
+		}
+	}
+	// The go tool switches to vendor mode if a vendor folder exists, so drop the gx
+	// leftovers once everything was moved out
+	if c.config.Mode == "modules" && !c.readonly() {
+		for _, dir := range []string{gxpkgs, filepath.Dir(gxpkgs), "vendor"} {
+			if leftovers, err := c.fsys.ReadDir(dir); err != nil || len(leftovers) > 0 {
+				break
+			}
+			if err := c.fsys.Remove(dir); err != nil {
+				return nil, fmt.Errorf("failed to remove empty %s: %v", dir, err)
+			}
+		}
+		if _, err := c.fsys.Stat("vendor"); err == nil {
+			c.logWarn("Warning, vendor folder left in place, go build needs -mod=mod to use the go.mod replaces")
+		}
+	}
+	// Ensure none of the moved packages contain conflicting package clauses
+	for _, pkg := range summary.Packages {
+		dir := c.packageDir(pkg)
+		if dir == "" {
+			continue
+		}
+		conflicts, err := c.checkPackageClauses(dir)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("failed to check package clauses of %s: %v", pkg.Path, err))
+			continue
+		}
+		for _, conflict := range conflicts {
+			c.logWarn("Warning, %s (%s) will not build: %s", pkg.Path, pkg.Hash, conflict)
+		}
+	}
+	// If requested, suggest stdlib replacements of obsolete dependencies
+	if c.config.SuggestStdlib {
+		c.suggestStdlib(summary.Packages)
+	}
+	// If requested, report the licenses of all the converted dependencies
+	if c.config.Licenses != "" {
+		dirs := make(map[string]string)
+		for _, pkg := range summary.Packages {
+			if dir := c.packageDir(pkg); dir != "" {
+				dirs[pkg.Path] = dir
+			}
+		}
+		c.logInfo("Writing dependency licenses to %s", c.config.Licenses)
+		if err := c.writeLicenses(c.config.Licenses, dirs); err != nil {
+			return nil, fmt.Errorf("failed to report dependency licenses: %v", err)
+		}
+	}
+	// If requested, commit the package moves separately from the rewrites
+	if c.config.GitCommits {
+		if err := c.gitCommit("Embed gx dependencies into "+c.libPath(), c.config.LibDir); err != nil {
+			return nil, fmt.Errorf("failed to commit embedded packages: %v", err)
+		}
+		if err := c.gitCommit("Vendor gx dependencies with canonical paths", "vendor"); err != nil {
+			return nil, fmt.Errorf("failed to commit vendored packages: %v", err)
+		}
+	}
+	// Rewrite packages to their canonical paths
+	c.logInfo("Rewriting import statements to canonical paths")
+
+	var diff bytes.Buffer
+	c.writeMoveHints(&diff)
+
+	rewrite = c.forkRules(rewrite, string(root))
+	writes, err := c.rewriteTree(rewrite, string(root), depped, excluded, filter, summary, &diff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrite import paths: %v", err)
+	}
+	if err := c.applyRewrites(writes, &diff); err != nil {
+		return nil, err
+	}
+	if err := c.reportChangedFiles(); err != nil {
+		return nil, err
+	}
+	summary.Rewrites = rewrite
+	if c.config.DryRun {
+		c.printPlan(summary)
+	}
+	if c.config.VerifyImports && !c.readonly() {
+		c.logInfo("Verifying that all imports resolve")
+		failures, err := c.unresolvedImports()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list project packages: %v", err)
+		}
+		for _, failure := range failures {
+			logError("Unresolved import: %s", failure)
+		}
+		if len(failures) > 0 {
+			return nil, fmt.Errorf("found %d unresolved imports", len(failures))
+		}
+	}
+	if c.config.Verify && !c.readonly() {
+		c.logInfo("Verifying that the converted package builds")
+		if err := c.verifyBuild(); err != nil {
+			return nil, fmt.Errorf("failed to build converted package: %v", err)
+		}
+	}
+	if c.config.DependencyReport != "" {
+		if err := c.writeDependencyReport(c.config.DependencyReport, summary.Packages); err != nil {
+			return nil, fmt.Errorf("failed to write dependency report: %v", err)
+		}
+	}
+	if c.config.DumpMapping != "" {
+		if err := writeMappingCSV(c.config.DumpMapping, summary.Packages, versions); err != nil {
+			return nil, fmt.Errorf("failed to write mapping dump: %v", err)
+		}
+	}
+	if c.config.ReportFile != "" {
+		if err := summary.save(c.config.ReportFile); err != nil {
+			return nil, fmt.Errorf("failed to write conversion report: %v", err)
+		}
+	}
+	if c.config.MetricsFile != "" {
+		if err := c.writeMetrics(c.config.MetricsFile, summary, time.Since(start)); err != nil {
+			return nil, fmt.Errorf("failed to write metrics file: %v", err)
+		}
+	}
+	// Record the conversion in a manifest, but only if it fully succeeded
+	if len(failures) == 0 && !c.readonly() {
+		if err := c.writeManifest(summary, dvcsimports, releases, versions, rewrite); err != nil {
+			return nil, fmt.Errorf("failed to write conversion manifest: %v", err)
+		}
+	}
+	if c.config.GitCommits {
+		// Only commit what the rewrite touched, not reports or caches dropped into the project
+		touched := []string{"go.mod", manifestFile}
+		for _, write := range writes {
+			touched = append(touched, write.path)
+		}
+		if err := c.gitCommit("Rewrite gx imports to canonical paths", touched...); err != nil {
+			return nil, fmt.Errorf("failed to commit import rewrites: %v", err)
+		}
+	}
+	// Fail the run if any of the packages could not be processed
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			logError("Error: %s", failure)
+		}
+		logError("Conversion finished in %v with %d errors", time.Since(start), len(failures))
+		summary.failures = len(failures)
+		return summary, fmt.Errorf("%d errors encountered", len(failures))
+	}
+	c.logInfo("Conversion finished in %v", time.Since(start))
+	return summary, nil
+}
+
+// applyRewrites writes the planned rewrites of the project files, or in patch
+// mode, the recorded diff into the patch file.
+//
+// Files are only touched after all of them were successfully rewritten. This
+// makes the rewrite phase all or nothing, but the package moves done before are
+// not reverted on failure (use the --undo-script to revert those).
+func (c *conversion) applyRewrites(writes []fileWrite, diff *bytes.Buffer) error {
+	if err := c.interrupted(); err != nil {
+		return err
+	}
+	if err := validateWrites(writes); err != nil {
+		return fmt.Errorf("failed to validate rewritten files: %v", err)
+	}
+	if len(writes) > 0 {
+		c.markModified()
+	}
+	if err := c.commitWrites(writes, func(fp string) error {
+		c.undo.files = append(c.undo.files, fp)
+		return c.updateUndoScript()
+	}); err != nil {
+		return fmt.Errorf("failed to write rewritten files: %v", err)
+	}
+	if c.config.Patch != "" {
+		c.logInfo("Writing conversion patch to %s", c.config.Patch)
+		if err := ioutil.WriteFile(c.config.Patch, diff.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write conversion patch: %v", err)
+		}
+	}
+	return nil
+}
+
+// reportChangedFiles writes the list of files changed by the conversion, if one
+// was requested.
+func (c *conversion) reportChangedFiles() error {
+	if c.config.ChangedFiles == "" {
+		return nil
+	}
+	c.logInfo("Writing changed file list to %s", c.config.ChangedFiles)
+	if err := c.writeChangedFiles(c.config.ChangedFiles); err != nil {
+		return fmt.Errorf("failed to write changed file list: %v", err)
+	}
+	return nil
+}
+
+// resolveRoot resolves the import path of the package in the current directory,
+// honoring any build constraints needed to list it.
+func (c *conversion) resolveRoot() ([]byte, error) {
+	args := []string{"list"}
+	if c.config.BuildTags != "" {
+		args = append(args, "-tags", c.config.BuildTags)
+	}
+	env := c.goListEnv()
+	if strings.Contains(os.Getenv("GOFLAGS"), "-mod=vendor") {
+		c.logWarn("Ignoring -mod=vendor from GOFLAGS, the gx vendor tree is not module consistent")
+	}
+	list := exec.CommandContext(c.interrupt, "go", args...)
+	list.Env = env
+	list.Dir = c.projectDir()
+
+	root, err := list.CombinedOutput()
+	if err != nil && bytes.Contains(root, []byte("vendor")) {
+		// Go may still default to vendor mode, retry explicitly ignoring it
+		retry := exec.CommandContext(c.interrupt, "go", append([]string{"list", "-mod=mod"}, args[1:]...)...)
+		retry.Env = env
+		retry.Dir = c.projectDir()
+		if out, rerr := retry.CombinedOutput(); rerr == nil {
+			c.logInfo("Resolved import path with -mod=mod, the vendor tree is inconsistent until converted")
+			root, err = out, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(root))
+	}
+	// An empty import path would produce invalid /gxlibs/... imports, bail out
+	if root = bytes.TrimSpace(root); len(root) == 0 {
+		return nil, errors.New("go list returned an empty import path, is the project inside a GOPATH or module?")
+	}
+	return root, nil
+}
+
+// goListEnv returns the environment to run go list in, honoring the requested
+// target platform.
+func (c *conversion) goListEnv() []string {
+	env := os.Environ()
+	if c.config.GOOS != "" {
+		env = append(env, "GOOS="+c.config.GOOS)
+	}
+	if c.config.GOARCH != "" {
+		env = append(env, "GOARCH="+c.config.GOARCH)
+	}
+	// An ambient -mod=vendor breaks on the pre-conversion vendor tree, drop it
+	if flags := os.Getenv("GOFLAGS"); strings.Contains(flags, "-mod=vendor") {
+		env = append(env, "GOFLAGS="+strings.Join(strings.Fields(strings.Replace(flags, "-mod=vendor", "", -1)), " "))
+	}
+	return env
+}
+
+// checkWritable verifies that the given directory is writable by creating and
+// deleting a temporary file in it.
+func (c *conversion) checkWritable(dir string) error {
+	file := filepath.Join(dir, fmt.Sprintf(".ungx-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	if err := c.fsys.CreateFile(file, nil, 0600); err != nil {
+		return err
+	}
+	return c.fsys.Remove(file)
+}
+
+// gxSpec is the subset of a gx package definition that ungx cares about.
+type gxSpec struct {
+	Name    string          `json:"name"`    // Name of the package, usually its folder name
+	Version string          `json:"version"` // Release version of the package, if set
+	Bin     json.RawMessage `json:"bin"`     // Executable(s) built by the package, if any
+	Gx      struct {
+		Path   string `json:"dvcsimport"` // Canonical import path of the package
+		Module string `json:"module"`     // Go module path of the package, if known
+	} `json:"gx"`
+
+	metadata bool   // Whether the package contains no Go code, only its definition
+	dir      string // Folder within the gx hash holding the definition
+}
+
+// path returns the canonical import path of the package. The module path is
+// preferred if set, being more accurate than dvcsimport for v2+ modules.
+func (spec *gxSpec) path() string {
+	if spec.Gx.Module != "" {
+		return spec.Gx.Module
+	}
+	return spec.Gx.Path
+}
+
+// executable returns whether the package is a binary rather than an importable
+// library package.
+func (spec *gxSpec) executable() bool {
+	bin := strings.TrimSpace(string(spec.Bin))
+	return bin != "" && bin != "null" && bin != `""` && bin != "{}" && bin != "[]"
+}
+
+// loadSpec retrieves the package spec from a gx dependency folder. If multiple
+// package definitions are found, the one in a folder named after its package
+// is preferred, falling back to the first one alphabetically.
+func (c *conversion) loadSpec(dir string) (*gxSpec, error) {
+	found, err := c.findSpecs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list package contents: %v", err)
+	}
+	if len(found) == 0 {
+		return nil, errors.New("failed to read package definition: no package.json found")
+	}
+	var spec *gxSpec
+	for _, sub := range found {
+		blob, err := c.fsys.ReadFile(filepath.Join(sub, "package.json"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read package definition: %v", err)
+		}
+		candidate := new(gxSpec)
+		if err := json.Unmarshal(blob, candidate); err != nil {
+			return nil, fmt.Errorf("failed to parse package definition: %v", err)
+		}
+		candidate.dir = strings.Split(filepath.ToSlash(strings.TrimPrefix(sub, dir+string(filepath.Separator))), "/")[0]
+
+		if candidate.Name == filepath.Base(sub) {
+			spec = candidate
+			break
+		}
+		if spec == nil {
+			spec = candidate
+		}
+	}
+	if spec.Gx.Path, err = normalizeImportPath(spec.Gx.Path); err != nil && spec.Gx.Module == "" {
+		return nil, fmt.Errorf("failed to parse dvcsimport: %v", err)
+	}
+	return spec, nil
+}
+
+// loadSpecs retrieves the package specs of a set of gx hashes concurrently, using
+// a bounded pool of workers. Specs failing to load are returned separately, so
+// the caller can report them in a deterministic order.
+func (c *conversion) loadSpecs(dir string, hashes []string, workers int) (map[string]*gxSpec, map[string]error) {
+	var (
+		specs  = make(map[string]*gxSpec)
+		failed = make(map[string]error)
+		lock   sync.Mutex
+		tasks  = make(chan string)
+		pend   sync.WaitGroup
+	)
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+
+			for hash := range tasks {
+				spec, err := c.loadSpec(filepath.Join(dir, hash))
+				if err == nil && !c.hasGoFiles(filepath.Join(dir, hash)) {
+					spec.metadata = true
+				}
+				lock.Lock()
+				if err != nil {
+					failed[hash] = err
+				} else {
+					specs[hash] = spec
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, hash := range hashes {
+		tasks <- hash
+	}
+	close(tasks)
+	pend.Wait()
+
+	return specs, failed
+}
+
+// maxSpecDepth is the number of folder levels below a gx hash that are searched
+// for package definitions.
+const maxSpecDepth = 3
+
+// findSpecs locates the folders containing a package definition within a gx
+// dependency folder. The definition usually sits in the sole folder of the hash,
+// but some packages ship multiple folders or nest it deeper, so the folders are
+// searched level by level, returning all the definitions on the shallowest one.
+func (c *conversion) findSpecs(dir string) ([]string, error) {
+	level := []string{dir}
+	for depth := 0; depth < maxSpecDepth && len(level) > 0; depth++ {
+		var next, found []string
+		for _, parent := range level {
+			infos, err := c.fsys.ReadDir(parent)
+			if err != nil {
+				return nil, err
+			}
+			for _, info := range infos {
+				if !info.IsDir() {
+					continue
+				}
+				sub := filepath.Join(parent, info.Name())
+				if _, err := c.fsys.Stat(filepath.Join(sub, "package.json")); err == nil {
+					found = append(found, sub)
+				}
+				next = append(next, sub)
+			}
+		}
+		if len(found) > 0 {
+			return found, nil
+		}
+		level = next
+	}
+	return nil, nil
+}
+
+// hasGoFiles returns whether a gx dependency folder contains any Go source
+// files, as opposed to only a package definition.
+func (c *conversion) hasGoFiles(dir string) bool {
+	found := errors.New("found")
+	err := c.fsys.Walk(dir, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".go") {
+			return found
+		}
+		return nil
+	})
+	// Unreadable folders are not metadata only, leave it to the move to fail
+	return err != nil
+}
+
+// ownPackage returns whether a dependency's canonical import path collides with
+// an existing first-party package of the project being converted (e.g. a local
+// fork), in which case moving it in would shadow or overwrite the user's code.
+func (c *conversion) ownPackage(root string, path string) bool {
+	if path != root && !strings.HasPrefix(path, root+"/") {
+		return false
+	}
+	dir := "."
+	if path != root {
+		dir = filepath.FromSlash(path[len(root)+1:])
+	}
+	_, err := c.fsys.Stat(dir)
+	return err == nil
+}
+
+// vendorDir returns the folder plain Go dependencies are vendored into. Modules
+// mode cannot use the vendor folder without a matching modules.txt, so they are
+// placed next to the embedded dependencies and replaced via go.mod instead.
+func (c *conversion) vendorDir() string {
+	if c.config.Mode == "modules" {
+		return c.config.LibDir
+	}
+	return "vendor"
+}
+
+// validLibDir returns whether a cleaned embed folder is a proper subfolder of the
+// project, outside of the vendor folder (whose contents the go tool treats
+// specially). Only whole path components are checked, so names merely starting
+// with dots (e.g. ..lib) are fine.
+func validLibDir(dir string) bool {
+	if filepath.IsAbs(dir) || filepath.VolumeName(dir) != "" || dir == "." {
+		return false
+	}
+	first := strings.Split(filepath.ToSlash(dir), "/")[0]
+	return first != ".." && first != "vendor"
+}
+
+// libPath returns the embed folder as a slash separated import path suffix.
+func (c *conversion) libPath() string {
+	return filepath.ToSlash(c.config.LibDir)
+}
+
+// versionUnsafe matches the characters of a package version that are not safe
+// to use within a folder name and import path.
+var versionUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// clashDir returns the folder name to embed one version of a clashing gx package
+// into, prefixing the hash with the package's release version (if known), so it
+// is apparent which version landed where.
+func clashDir(hash string, version string) string {
+	if version = versionUnsafe.ReplaceAllString(version, "_"); version == "" {
+		return hash
+	}
+	return "v" + strings.TrimPrefix(version, "v") + "-" + hash
+}
+
+// nestedPath returns the canonical import path of a folder within a gx hash. A
+// lone folder or the one holding the package definition is the package itself,
+// but any other folder next to it is a nested subpackage of the canonical path.
+func nestedPath(path string, dir string, spec string, dirs int) string {
+	if dirs == 1 || dir == spec {
+		return path
+	}
+	return path + "/" + dir
+}
+
+// specFirst reorders the folders of a gx hash so the one holding the package
+// definition comes first, as it must be moved into place before the folders
+// nested within it.
+func specFirst(dirs []os.FileInfo, spec string) []os.FileInfo {
+	ordered := make([]os.FileInfo, 0, len(dirs))
+	for _, dir := range dirs {
+		if dir.Name() == spec {
+			ordered = append(ordered, dir)
+		}
+	}
+	for _, dir := range dirs {
+		if dir.Name() != spec {
+			ordered = append(ordered, dir)
+		}
+	}
+	return ordered
+}
+
+// packageDir returns the on-disk location of a converted dependency, or an empty
+// string if it was not moved. In read only mode nothing was moved, so the
+// original location is returned instead.
+func (c *conversion) packageDir(pkg ReportPackage) string {
+	if pkg.Target == "" {
+		return ""
+	}
+	dir := pkg.Target
+	for _, move := range c.moves {
+		if move.dst == pkg.Target {
+			dir = move.src
+		}
+	}
+	if _, err := c.fsys.Stat(dir); err != nil {
+		return ""
+	}
+	return dir
+}
+
+// readonly returns whether the conversion may not modify the project, only
+// record the actions it would take.
+func (c *conversion) readonly() bool {
+	return c.config.Patch != "" || c.config.DryRun
+}
+
+// mkdir creates a canonical destination folder, unless running read only.
+func (c *conversion) mkdir(path string) error {
+	if c.readonly() {
+		if _, err := c.fsys.Stat(path); err != nil && c.config.DryRun {
+			c.logDebug("Would create %s", path)
+		}
+		return nil
+	}
+	return c.fsys.MkdirAll(path, 0700)
+}
+
+// rmdir deletes an emptied gx hash folder, unless running read only.
+func (c *conversion) rmdir(path string) error {
+	if c.readonly() {
+		if c.config.DryRun {
+			c.logDebug("Would remove %s", path)
+		}
+		return nil
+	}
+	// Packages are moved with their entire subtree (including any non-Go folders,
+	// e.g. bundled C sources), so anything left over means something went wrong.
+	leftovers, err := c.fsys.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	if len(leftovers) > 0 {
+		var names []string
+		for _, leftover := range leftovers {
+			names = append(names, leftover.Name())
+		}
+		return fmt.Errorf("%s not fully moved, left behind: %s", path, strings.Join(names, ", "))
+	}
+	return c.fsys.Remove(path)
+}
+
+// relocate moves a dependency from its gx location to its canonical one, along
+// with its entire folder subtree (Go or otherwise). If the destination already
+// exists and a previous (phase restricted) run recorded converting the same gx
+// hash into it, the freshly reinstalled gx copy is dropped instead. Any other
+// existing destination is refused. In read only mode the move is only recorded.
+func (c *conversion) relocate(src, dst string, converted bool) error {
+	if _, err := c.fsys.Stat(dst); err == nil {
+		if !converted {
+			return fmt.Errorf("%s already exists and is not recorded as converted from %s, refusing to overwrite or drop either", dst, src)
+		}
+		if c.readonly() {
+			c.logInfo("Would drop %s, already converted into %s", src, dst)
+			return nil
+		}
+		c.logInfo("Dropping %s, already converted into %s", src, dst)
+		c.markModified()
+		if err := c.fsys.RemoveAll(src); err != nil {
+			return err
+		}
+		c.undo.dropped = append(c.undo.dropped, src)
+		return c.updateUndoScript()
+	}
+	if c.readonly() {
+		if c.config.DryRun {
+			c.logInfo("Would move %s to %s", src, dst)
+		}
+		c.moves = append(c.moves, move{src: src, dst: dst})
+		return nil
+	}
+	c.markModified()
+
+	// Make sure the package remains self contained after the move
+	if c.onDisk() {
+		if err := c.materializeSymlinks(src); err != nil {
+			return err
+		}
+	}
+	moved := false
+	if c.config.GitMoves && c.onDisk() {
+		if err := c.gitMove(src, dst); err != nil {
+			c.logWarn("Failed to git mv %s, falling back to rename: %v", src, err)
+		} else {
+			moved = true
+		}
+	}
+	if !moved {
+		if err := c.fsys.Rename(src, dst); err != nil {
+			return err
+		}
+	}
+	c.undo.moves = append(c.undo.moves, move{src: src, dst: dst})
+	return c.updateUndoScript()
+}
+
+// updateUndoScript regenerates the undo script, if one was requested.
+func (c *conversion) updateUndoScript() error {
+	if c.config.UndoScript == "" {
+		return nil
+	}
+	return c.writeUndoScript(c.config.UndoScript)
+}
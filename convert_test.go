@@ -0,0 +1,1087 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memProject creates an in-memory file system holding the given files.
+func memProject(t *testing.T, files map[string]string) *MemFS {
+	t.Helper()
+
+	mem := NewMemFS()
+	for path, content := range files {
+		if err := mem.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create %s folder: %v", path, err)
+		}
+		if err := mem.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create %s: %v", path, err)
+		}
+	}
+	return mem
+}
+
+// diskProject creates a temporary folder holding the given files.
+func diskProject(t testing.TB, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for path, content := range files {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create %s folder: %v", path, err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create %s: %v", path, err)
+		}
+	}
+	return dir
+}
+
+// memOptions returns the options to convert an in-memory project offline, with
+// the given embed/vendor decisions preseeded into the cache.
+func memOptions(t *testing.T, mem *MemFS, decisions string) Options {
+	t.Helper()
+
+	cache := filepath.Join(t.TempDir(), "cache.json")
+	if err := ioutil.WriteFile(cache, []byte(decisions), 0644); err != nil {
+		t.Fatalf("failed to seed decision cache: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.FS = mem
+	opts.ImportPath = "example.com/proj"
+	opts.CacheFile = cache
+	opts.RequireOfflineDecisions = true
+	opts.Quiet = true
+	return opts
+}
+
+// gxProject are the files of a project depending on two gx packages, one gx and
+// one plain Go based upstream.
+var gxProject = map[string]string{
+	"main.go": "package main\n\nimport (\n\t\"gx/ipfs/QmBar/bar\"\n\t\"gx/ipfs/QmFoo/foo\"\n)\n\nfunc main() { foo.Foo(); bar.Bar() }\n",
+
+	"vendor/gx/ipfs/QmFoo/foo/package.json": `{"name": "foo", "version": "1.0.0", "gx": {"dvcsimport": "github.com/a/foo"}}`,
+	"vendor/gx/ipfs/QmFoo/foo/foo.go":       "package foo\n\nfunc Foo() {}\n",
+	"vendor/gx/ipfs/QmBar/bar/package.json": `{"name": "bar", "gx": {"dvcsimport": "github.com/b/bar"}}`,
+	"vendor/gx/ipfs/QmBar/bar/bar.go":       "package bar\n\nfunc Bar() {}\n",
+}
+
+// gxDecisions classifies the dependencies of gxProject without the network.
+const gxDecisions = `{"github.com/a/foo@v1.0.0": true, "github.com/b/bar": false}`
+
+// checkConverted verifies that gxProject was fully converted.
+func checkConverted(t *testing.T, mem *MemFS) {
+	t.Helper()
+
+	for _, path := range []string{"gxlibs/github.com/a/foo/foo.go", "vendor/github.com/b/bar/bar.go", manifestFile} {
+		if _, err := mem.Stat(path); err != nil {
+			t.Errorf("missing %s after conversion: %v", path, err)
+		}
+	}
+	for _, path := range []string{"vendor/gx/ipfs/QmFoo", "vendor/gx/ipfs/QmBar", lockFile} {
+		if _, err := mem.Stat(path); err == nil {
+			t.Errorf("%s left behind after conversion", path)
+		}
+	}
+	blob, err := mem.ReadFile("main.go")
+	if err != nil {
+		t.Fatalf("failed to read rewritten main.go: %v", err)
+	}
+	for _, imp := range []string{`"example.com/proj/gxlibs/github.com/a/foo"`, `"github.com/b/bar"`} {
+		if !strings.Contains(string(blob), imp) {
+			t.Errorf("main.go import %s missing:\n%s", imp, blob)
+		}
+	}
+}
+
+// Tests that a conversion can run entirely on an in-memory file system, moving
+// and rewriting the packages without touching the disk.
+func TestConvertInMemory(t *testing.T) {
+	mem := memProject(t, gxProject)
+	if _, err := Convert(memOptions(t, mem, gxDecisions)); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	checkConverted(t, mem)
+}
+
+// Tests that conversions started concurrently don't interfere with each other.
+func TestConvertConcurrently(t *testing.T) {
+	var (
+		mems = make([]*MemFS, 4)
+		errs = make([]error, len(mems))
+		pend sync.WaitGroup
+	)
+	for i := range mems {
+		mems[i] = memProject(t, gxProject)
+		opts := memOptions(t, mems[i], gxDecisions)
+
+		pend.Add(1)
+		go func(i int) {
+			defer pend.Done()
+			_, errs[i] = Convert(opts)
+		}(i)
+	}
+	pend.Wait()
+
+	for i, mem := range mems {
+		if errs[i] != nil {
+			t.Fatalf("conversion %d failed: %v", i, errs[i])
+		}
+		checkConverted(t, mem)
+	}
+}
+
+// Tests that the folders of a gx hash are mapped to the canonical path if they
+// hold the package definition or are alone, and to subpackages otherwise.
+func TestNestedPath(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+		spec string
+		dirs int
+		want string
+	}{
+		{"single folder", "foo", "foo", 1, "github.com/a/foo"},
+		{"single folder, nested spec", "foo", "", 1, "github.com/a/foo"},
+		{"spec folder among many", "foo", "foo", 2, "github.com/a/foo"},
+		{"other folder among many", "aaa", "foo", 2, "github.com/a/foo/aaa"},
+		{"no spec folder among many", "foo", "", 2, "github.com/a/foo/foo"},
+	}
+	for _, tt := range tests {
+		if have := nestedPath("github.com/a/foo", tt.dir, tt.spec, tt.dirs); have != tt.want {
+			t.Errorf("%s: path mismatch: have %s, want %s", tt.name, have, tt.want)
+		}
+	}
+}
+
+// Tests that a gx hash with multiple folders is converted with the folder of the
+// package definition as the package itself, even if it's not the first one.
+func TestConvertMultipleFolders(t *testing.T) {
+	files := map[string]string{
+		"main.go": "package main\n\nimport (\n\t\"gx/ipfs/QmBar/bar\"\n\t\"gx/ipfs/QmFoo/aaa\"\n\t\"gx/ipfs/QmFoo/foo\"\n)\n\nfunc main() { aaa.Aaa(); foo.Foo(); bar.Bar() }\n",
+
+		"vendor/gx/ipfs/QmFoo/aaa/aaa.go": "package aaa\n\nfunc Aaa() {}\n",
+		"vendor/gx/ipfs/QmBar/bar/bar.go": gxProject["vendor/gx/ipfs/QmBar/bar/bar.go"],
+		"vendor/gx/ipfs/QmBar/zzz/zzz.go": "package zzz\n",
+	}
+	for _, path := range []string{"vendor/gx/ipfs/QmFoo/foo/package.json", "vendor/gx/ipfs/QmFoo/foo/foo.go", "vendor/gx/ipfs/QmBar/bar/package.json"} {
+		files[path] = gxProject[path]
+	}
+	mem := memProject(t, files)
+	if _, err := Convert(memOptions(t, mem, gxDecisions)); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	for _, path := range []string{"gxlibs/github.com/a/foo/foo.go", "gxlibs/github.com/a/foo/aaa/aaa.go", "vendor/github.com/b/bar/bar.go", "vendor/github.com/b/bar/zzz/zzz.go"} {
+		if _, err := mem.Stat(path); err != nil {
+			t.Errorf("missing %s after conversion: %v", path, err)
+		}
+	}
+	blob, err := mem.ReadFile("main.go")
+	if err != nil {
+		t.Fatalf("failed to read rewritten main.go: %v", err)
+	}
+	for _, imp := range []string{`"example.com/proj/gxlibs/github.com/a/foo"`, `"example.com/proj/gxlibs/github.com/a/foo/aaa"`, `"github.com/b/bar"`} {
+		if !strings.Contains(string(blob), imp) {
+			t.Errorf("main.go import %s missing:\n%s", imp, blob)
+		}
+	}
+}
+
+// Tests that a conversion split into an embed and a vendor phase, in either order,
+// only moves and rewrites its own class of packages, and that the later phase
+// converges to the same result as a single run, dropping the reinstalled copies
+// of the packages converted by the first phase.
+func TestConvertPhases(t *testing.T) {
+	tests := []struct {
+		name      string
+		embed     bool   // Whether the first phase is the embedding one
+		moved     string // File moved by the first phase
+		kept      string // File left in place by the first phase
+		rewritten string // Import rewritten by the first phase
+		untouched string // Import left for the second phase
+		reinstall string // Package folder reinstalled by gx between the phases
+	}{
+		{
+			name:      "embed first",
+			embed:     true,
+			moved:     "gxlibs/github.com/a/foo/foo.go",
+			kept:      "vendor/gx/ipfs/QmBar/bar/bar.go",
+			rewritten: `"example.com/proj/gxlibs/github.com/a/foo"`,
+			untouched: `"gx/ipfs/QmBar/bar"`,
+			reinstall: "vendor/gx/ipfs/QmFoo/",
+		},
+		{
+			name:      "vendor first",
+			moved:     "vendor/github.com/b/bar/bar.go",
+			kept:      "vendor/gx/ipfs/QmFoo/foo/foo.go",
+			rewritten: `"github.com/b/bar"`,
+			untouched: `"gx/ipfs/QmFoo/foo"`,
+			reinstall: "vendor/gx/ipfs/QmBar/",
+		},
+	}
+	for _, tt := range tests {
+		mem := memProject(t, gxProject)
+
+		opts := memOptions(t, mem, gxDecisions)
+		opts.OnlyEmbed, opts.OnlyVendor = tt.embed, !tt.embed
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to run first phase: %v", tt.name, err)
+		}
+		for _, path := range []string{tt.moved, tt.kept} {
+			if _, err := mem.Stat(path); err != nil {
+				t.Errorf("%s: missing %s after first phase: %v", tt.name, path, err)
+			}
+		}
+		blob, err := mem.ReadFile("main.go")
+		if err != nil {
+			t.Fatalf("%s: failed to read rewritten main.go: %v", tt.name, err)
+		}
+		for _, imp := range []string{tt.rewritten, tt.untouched} {
+			if !strings.Contains(string(blob), imp) {
+				t.Errorf("%s: main.go import %s missing after first phase:\n%s", tt.name, imp, blob)
+			}
+		}
+		// Simulate gx reinstalling the converted package before the next phase
+		for path, content := range gxProject {
+			if !strings.HasPrefix(path, tt.reinstall) {
+				continue
+			}
+			if err := mem.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("%s: failed to create %s folder: %v", tt.name, path, err)
+			}
+			if err := mem.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("%s: failed to reinstall %s: %v", tt.name, path, err)
+			}
+		}
+		opts = memOptions(t, mem, gxDecisions)
+		opts.OnlyEmbed, opts.OnlyVendor = !tt.embed, tt.embed
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to run second phase: %v", tt.name, err)
+		}
+		checkConverted(t, mem)
+	}
+}
+
+// Tests that a gx package is neither dropped nor moved over an existing folder
+// at its destination, unless a previous run recorded converting it there.
+func TestConvertExistingDestination(t *testing.T) {
+	files := map[string]string{"gxlibs/github.com/a/foo/fork.go": "package foo\n"}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+
+	if _, err := Convert(memOptions(t, mem, gxDecisions)); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("conversion error mismatch: have %v, want existing destination refusal", err)
+	}
+	for _, path := range []string{"gxlibs/github.com/a/foo/fork.go", "vendor/gx/ipfs/QmFoo/foo/foo.go"} {
+		if _, err := mem.Stat(path); err != nil {
+			t.Errorf("%s lost after refused conversion: %v", path, err)
+		}
+	}
+}
+
+// Tests that a dependency whose canonical path collides with a first-party package
+// of the project is refused, leaving the user's code and the gx copy untouched.
+func TestConvertFirstPartyCollision(t *testing.T) {
+	files := map[string]string{
+		"util/util.go": "package util\n\n// Util is the project's own version\nfunc Util() {}\n",
+
+		"vendor/gx/ipfs/QmUtil/util/package.json": `{"name": "util", "gx": {"dvcsimport": "example.com/proj/util"}}`,
+		"vendor/gx/ipfs/QmUtil/util/util.go":      "package util\n\nfunc Util() {}\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+
+	report, err := Convert(memOptions(t, mem, gxDecisions))
+	if err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	if blob, _ := mem.ReadFile("util/util.go"); !strings.Contains(string(blob), "project's own version") {
+		t.Errorf("first-party package overwritten:\n%s", blob)
+	}
+	if _, err := mem.Stat("vendor/gx/ipfs/QmUtil/util/util.go"); err != nil {
+		t.Errorf("colliding gx copy lost: %v", err)
+	}
+	for _, path := range []string{"gxlibs/example.com/proj/util", "vendor/example.com/proj/util"} {
+		if _, err := mem.Stat(path); err == nil {
+			t.Errorf("colliding dependency moved into %s", path)
+		}
+	}
+	for _, pkg := range report.Packages {
+		if pkg.Hash == "QmUtil" && (pkg.Action != "skip" || !strings.Contains(pkg.Reason, "first-party")) {
+			t.Errorf("colliding dependency reported as %s (%s)", pkg.Action, pkg.Reason)
+		}
+	}
+	checkConverted(t, mem)
+}
+
+// Tests that executable gx packages are detected from their bin entry and left
+// unconverted, while empty bin entries don't count as executables.
+func TestConvertExecutable(t *testing.T) {
+	tests := []struct {
+		name       string
+		bin        string // Raw bin entry of the package definition
+		executable bool
+	}{
+		{"single binary", `"bin/tool"`, true},
+		{"named binaries", `{"tool": "bin/tool"}`, true},
+		{"binary list", `["bin/tool"]`, true},
+		{"empty string", `""`, false},
+		{"empty object", `{}`, false},
+		{"null", `null`, false},
+	}
+	for _, tt := range tests {
+		files := map[string]string{
+			"vendor/gx/ipfs/QmTool/tool/package.json": `{"name": "tool", "bin": ` + tt.bin + `, "gx": {"dvcsimport": "github.com/c/tool"}}`,
+			"vendor/gx/ipfs/QmTool/tool/main.go":      "package main\n\nfunc main() {}\n",
+		}
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		mem := memProject(t, files)
+
+		report, err := Convert(memOptions(t, mem, `{"github.com/a/foo@v1.0.0": true, "github.com/b/bar": false, "github.com/c/tool": false}`))
+		if err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		for _, pkg := range report.Packages {
+			if pkg.Hash != "QmTool" {
+				continue
+			}
+			if skipped := pkg.Action == "skip" && pkg.Reason == "executable package"; skipped != tt.executable {
+				t.Errorf("%s: package reported as %s (%s), executable %v", tt.name, pkg.Action, pkg.Reason, tt.executable)
+			}
+		}
+		if _, err := mem.Stat("vendor/gx/ipfs/QmTool/tool/main.go"); (err == nil) != tt.executable {
+			t.Errorf("%s: gx copy presence mismatch: have %v, want %v", tt.name, err == nil, tt.executable)
+		}
+	}
+}
+
+// Tests that packages are moved along with their non-Go folders (e.g. bundled C
+// sources needed by cgo), leaving nothing behind in the gx folder.
+func TestConvertNonGoFolders(t *testing.T) {
+	files := map[string]string{
+		"vendor/gx/ipfs/QmFoo/foo/csrc/lib.c":            "#include \"lib.h\"\n\nint lib(void) { return 42; }\n",
+		"vendor/gx/ipfs/QmFoo/foo/csrc/include/lib.h":    "int lib(void);\n",
+		"vendor/gx/ipfs/QmBar/bar/testdata/fixture.json": "{}\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+
+	if _, err := Convert(memOptions(t, mem, gxDecisions)); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	checkConverted(t, mem)
+
+	moved := map[string]string{
+		"gxlibs/github.com/a/foo/csrc/lib.c":            files["vendor/gx/ipfs/QmFoo/foo/csrc/lib.c"],
+		"gxlibs/github.com/a/foo/csrc/include/lib.h":    files["vendor/gx/ipfs/QmFoo/foo/csrc/include/lib.h"],
+		"vendor/github.com/b/bar/testdata/fixture.json": files["vendor/gx/ipfs/QmBar/bar/testdata/fixture.json"],
+	}
+	for path, content := range moved {
+		if blob, err := mem.ReadFile(path); err != nil || string(blob) != content {
+			t.Errorf("%s not moved intact: %v", path, err)
+		}
+	}
+}
+
+// Tests that the Go module path of a package definition is preferred over its
+// dvcsimport for the canonical path, which is used when no module is set.
+func TestConvertModulePath(t *testing.T) {
+	tests := []struct {
+		name string
+		gx   string // The gx section of the package definition
+		want string // Expected embedded folder of the package
+	}{
+		{"module path wins", `{"dvcsimport": "github.com/a/foo", "module": "github.com/a/foo/v2"}`, "gxlibs/github.com/a/foo/v2"},
+		{"dvcsimport fallback", `{"dvcsimport": "github.com/a/foo"}`, "gxlibs/github.com/a/foo"},
+		{"invalid dvcsimport", `{"dvcsimport": "not a path", "module": "github.com/a/foo/v2"}`, "gxlibs/github.com/a/foo/v2"},
+	}
+	for _, tt := range tests {
+		files := make(map[string]string)
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		files["vendor/gx/ipfs/QmFoo/foo/package.json"] = `{"name": "foo", "version": "1.0.0", "gx": ` + tt.gx + `}`
+		mem := memProject(t, files)
+
+		if _, err := Convert(memOptions(t, mem, `{"github.com/a/foo@v1.0.0": true, "github.com/a/foo/v2@v1.0.0": true, "github.com/b/bar": false}`)); err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		if _, err := mem.Stat(tt.want + "/foo.go"); err != nil {
+			t.Errorf("%s: package not embedded into %s: %v", tt.name, tt.want, err)
+		}
+		if blob, _ := mem.ReadFile("main.go"); !strings.Contains(string(blob), `"example.com/proj/`+tt.want+`"`) {
+			t.Errorf("%s: main.go not pointing to %s:\n%s", tt.name, tt.want, blob)
+		}
+	}
+}
+
+// Tests that gx packages which cannot be loaded are left in place with either
+// policy, but only fail the conversion when requested.
+func TestConvertOnUnreadable(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		fail   bool // Whether the conversion should return an error
+	}{
+		{"default policy", "", true},
+		{"fail policy", "fail", true},
+		{"skip policy", "skip", false},
+	}
+	for _, tt := range tests {
+		files := map[string]string{
+			"vendor/gx/ipfs/QmBroken/broken/package.json": "{",
+			"vendor/gx/ipfs/QmBroken/broken/broken.go":    "package broken\n",
+		}
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		mem := memProject(t, files)
+		opts := memOptions(t, mem, gxDecisions)
+		opts.OnUnreadable = tt.policy
+
+		report, err := Convert(opts)
+		if (err != nil) != tt.fail {
+			t.Errorf("%s: conversion error mismatch: have %v, want failure %v", tt.name, err, tt.fail)
+		}
+		if report == nil {
+			t.Fatalf("%s: no report returned", tt.name)
+		}
+		// The readable packages are converted regardless of the policy
+		blob, err := mem.ReadFile("main.go")
+		if err != nil {
+			t.Fatalf("%s: failed to read main.go: %v", tt.name, err)
+		}
+		if !strings.Contains(string(blob), `"example.com/proj/gxlibs/github.com/a/foo"`) {
+			t.Errorf("%s: readable packages not converted:\n%s", tt.name, blob)
+		}
+		if _, err := mem.Stat("vendor/gx/ipfs/QmBroken/broken/broken.go"); err != nil {
+			t.Errorf("%s: unreadable package not left in place: %v", tt.name, err)
+		}
+		// A failed conversion must not be recorded as complete
+		if _, err := mem.Stat(manifestFile); (err == nil) == tt.fail {
+			t.Errorf("%s: manifest presence mismatch: have %v, want %v", tt.name, err == nil, !tt.fail)
+		}
+		var skipped bool
+		for _, pkg := range report.Packages {
+			if pkg.Hash == "QmBroken" {
+				skipped = pkg.Action == "skip" && strings.HasPrefix(pkg.Reason, "failed to load")
+			}
+		}
+		if !skipped {
+			t.Errorf("%s: unreadable package not reported as skipped", tt.name)
+		}
+	}
+	// Unknown policies are rejected before touching anything
+	mem := memProject(t, gxProject)
+	opts := memOptions(t, mem, gxDecisions)
+	opts.OnUnreadable = "ignore"
+	if _, err := Convert(opts); err == nil {
+		t.Errorf("unknown policy accepted")
+	}
+	if _, err := mem.Stat("vendor/gx/ipfs/QmFoo/foo/foo.go"); err != nil {
+		t.Errorf("package moved despite unknown policy: %v", err)
+	}
+}
+
+// Tests that an embedded package importing itself both via its gx hash and via
+// its canonical path ends up with all imports pointing to the embedded copy.
+func TestConvertEmbeddedSelfImports(t *testing.T) {
+	files := map[string]string{
+		"vendor/gx/ipfs/QmFoo/foo/hashed.go":    "package foo\n\nimport \"gx/ipfs/QmFoo/foo/sub\"\n\nvar _ = sub.Sub\n",
+		"vendor/gx/ipfs/QmFoo/foo/canonical.go": "package foo\n\nimport \"github.com/a/foo/sub\"\n\nvar _ = sub.Sub\n",
+		"vendor/gx/ipfs/QmFoo/foo/sub/sub.go":   "package sub\n\nfunc Sub() {}\n",
+		"direct.go":                             "package main\n\nimport \"github.com/a/foo\"\n\nvar _ = foo.Foo\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+
+	if _, err := Convert(memOptions(t, mem, gxDecisions)); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	checkConverted(t, mem)
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"gxlibs/github.com/a/foo/hashed.go", `"example.com/proj/gxlibs/github.com/a/foo/sub"`},
+		{"gxlibs/github.com/a/foo/canonical.go", `"example.com/proj/gxlibs/github.com/a/foo/sub"`},
+		{"direct.go", `"example.com/proj/gxlibs/github.com/a/foo"`},
+	}
+	for _, tt := range tests {
+		blob, err := mem.ReadFile(tt.file)
+		if err != nil {
+			t.Errorf("%s: failed to read: %v", tt.file, err)
+			continue
+		}
+		if !strings.Contains(string(blob), tt.want) {
+			t.Errorf("%s: import %s missing:\n%s", tt.file, tt.want, blob)
+		}
+	}
+}
+
+// Tests that packages holding only a package definition are skipped without a
+// network probe, neither moved nor given a dangling rewrite rule.
+func TestConvertMetadataOnly(t *testing.T) {
+	files := map[string]string{
+		"vendor/gx/ipfs/QmMeta/meta/package.json": `{"name": "meta", "gx": {"dvcsimport": "github.com/c/meta"}}`,
+		"vendor/gx/ipfs/QmMeta/meta/README.md":    "Nothing to import here\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+
+	report, err := Convert(memOptions(t, mem, gxDecisions))
+	if err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	checkConverted(t, mem)
+
+	for _, path := range []string{"gxlibs/github.com/c/meta", "vendor/github.com/c/meta"} {
+		if _, err := mem.Stat(path); err == nil {
+			t.Errorf("metadata only package moved into %s", path)
+		}
+	}
+	for rule := range report.Rewrites {
+		if strings.Contains(rule, "QmMeta") || strings.Contains(rule, "github.com/c/meta") {
+			t.Errorf("dangling rewrite rule %s -> %s", rule, report.Rewrites[rule])
+		}
+	}
+	for _, pkg := range report.Packages {
+		if pkg.Hash == "QmMeta" && (pkg.Action != "skip" || pkg.Reason != "no Go code") {
+			t.Errorf("metadata only package reported as %s (%s)", pkg.Action, pkg.Reason)
+		}
+	}
+}
+
+// Tests that a plain Go dependency already vendored by dep keeps dep's copy with
+// the gx imports pointing to it, while the rest of the gx packages get converted
+// alongside the dep managed ones.
+func TestConvertDepManaged(t *testing.T) {
+	files := map[string]string{
+		"Gopkg.lock":                     "[[projects]]\n  name = \"github.com/b/bar\"\n\n[[projects]]\n  name = \"github.com/c/baz\"\n",
+		"vendor/github.com/b/bar/bar.go": "package bar\n\n// Bar is dep's version\nfunc Bar() {}\n",
+		"vendor/github.com/c/baz/baz.go": "package baz\n\nfunc Baz() {}\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+
+	report, err := Convert(memOptions(t, mem, gxDecisions))
+	if err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	checkConverted(t, mem)
+
+	if blob, _ := mem.ReadFile("vendor/github.com/b/bar/bar.go"); !strings.Contains(string(blob), "dep's version") {
+		t.Errorf("dep vendored package replaced by gx copy:\n%s", blob)
+	}
+	if _, err := mem.Stat("vendor/github.com/c/baz/baz.go"); err != nil {
+		t.Errorf("unrelated dep vendored package lost: %v", err)
+	}
+	for _, pkg := range report.Packages {
+		if pkg.Hash == "QmBar" && (pkg.Action != "vendor" || !strings.Contains(pkg.Reason, "dep")) {
+			t.Errorf("dep vendored package reported as %s (%s)", pkg.Action, pkg.Reason)
+		}
+	}
+}
+
+// probeRecorder is a test server answering the network probes of conversions,
+// recording every request it received.
+type probeRecorder struct {
+	lock     sync.Mutex
+	requests []string // Paths of the received requests, in order
+}
+
+// probeServer starts a TLS server answering the embed probes of gxProject (only
+// github.com/a/foo publishing a package.json via raw.example.com), and routes
+// every host of the conversion's HTTP client to it until the test ends.
+func probeServer(t *testing.T) *probeRecorder {
+	t.Helper()
+
+	probes := new(probeRecorder)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.lock.Lock()
+		probes.requests = append(probes.requests, r.URL.Path)
+		probes.lock.Unlock()
+
+		if r.Host == "raw.example.com" && r.URL.Path == "/a/foo/v1.0.0/package.json" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return new(net.Dialer).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	original := http.DefaultTransport
+	http.DefaultTransport = transport
+
+	t.Cleanup(func() {
+		http.DefaultTransport = original
+		srv.Close()
+	})
+	t.Setenv("GITHUB_TOKEN", "")
+	return probes
+}
+
+// repos returns the sorted set of repositories probed so far.
+func (p *probeRecorder) repos() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var repos []string
+	for _, path := range p.requests {
+		repos = append(repos, strings.Join(strings.Split(path, "/")[1:3], "/"))
+	}
+	return uniqueSorted(repos)
+}
+
+// Tests that the embedded packages are moved into the configured folder and the
+// imports are rewritten to point into it.
+func TestConvertLibDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		libdir string
+		want   string // Folder the embedded packages are expected in
+	}{
+		{"default folder", "", "gxlibs"},
+		{"nested folder", "internal/vendored", "internal/vendored"},
+		{"unclean folder", "third_party//gx/", "third_party/gx"},
+	}
+	for _, tt := range tests {
+		mem := memProject(t, gxProject)
+
+		opts := memOptions(t, mem, gxDecisions)
+		opts.LibDir = tt.libdir
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert: %v", tt.name, err)
+		}
+		if _, err := mem.Stat(tt.want + "/github.com/a/foo/foo.go"); err != nil {
+			t.Errorf("%s: embedded package not moved: %v", tt.name, err)
+		}
+		if tt.want != "gxlibs" {
+			if _, err := mem.Stat("gxlibs"); err == nil {
+				t.Errorf("%s: default folder created", tt.name)
+			}
+		}
+		blob, err := mem.ReadFile("main.go")
+		if err != nil {
+			t.Fatalf("%s: failed to read rewritten main.go: %v", tt.name, err)
+		}
+		if imp := `"example.com/proj/` + tt.want + `/github.com/a/foo"`; !strings.Contains(string(blob), imp) {
+			t.Errorf("%s: main.go import %s missing:\n%s", tt.name, imp, blob)
+		}
+	}
+}
+
+// Tests that embed folders are validated by their path components.
+func TestValidLibDir(t *testing.T) {
+	tests := []struct {
+		dir   string
+		valid bool
+	}{
+		{"gxlibs", true},
+		{"third_party/gx", true},
+		{"..lib", true},
+		{"vendors", true},
+		{"lib/vendor", true},
+		{".", false},
+		{"..", false},
+		{"../lib", false},
+		{"vendor", false},
+		{"vendor/foo", false},
+		{"lib/../vendor/foo", false},
+		{"/abs/lib", false},
+	}
+	for _, tt := range tests {
+		if valid := validLibDir(filepath.Clean(filepath.FromSlash(tt.dir))); valid != tt.valid {
+			t.Errorf("%s: validity mismatch: have %v, want %v", tt.dir, valid, tt.valid)
+		}
+	}
+}
+
+// fsFiles returns the contents of all the files of a file system.
+func fsFiles(t *testing.T, fs FS) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := fs.Walk(".", func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		blob, err := fs.ReadFile(path)
+		files[path] = string(blob)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	return files
+}
+
+// Tests that a dry run resolves the embed/vendor decisions, hitting the network
+// for the undecided ones, and reports the full plan without changing any file.
+func TestConvertDryRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		decisions string   // Decisions cached before the run
+		probed    []string // Repositories expected to be probed over the network
+	}{
+		{"cold cache", "{}", []string{"a/foo", "b/bar"}},
+		{"partially cached", `{"github.com/a/foo@v1.0.0": true}`, []string{"b/bar"}},
+		{"fully cached", gxDecisions, nil},
+	}
+	for _, tt := range tests {
+		probes := probeServer(t)
+
+		mem := memProject(t, gxProject)
+		before := fsFiles(t, mem)
+
+		opts := memOptions(t, mem, tt.decisions)
+		opts.DryRun = true
+		opts.RequireOfflineDecisions = false
+		opts.GitHubRawHosts = map[string]string{"github.com": "raw.example.com"}
+
+		report, err := Convert(opts)
+		if err != nil {
+			t.Fatalf("%s: failed to dry run: %v", tt.name, err)
+		}
+		if probed := probes.repos(); !reflect.DeepEqual(probed, tt.probed) {
+			t.Errorf("%s: probed repos mismatch: have %v, want %v", tt.name, probed, tt.probed)
+		}
+		actions := make(map[string]string)
+		for _, pkg := range report.Packages {
+			actions[pkg.Path] = pkg.Action
+			if pkg.Reason == "" {
+				t.Errorf("%s: no reason reported for %s", tt.name, pkg.Path)
+			}
+		}
+		if want := map[string]string{"github.com/a/foo": "embed", "github.com/b/bar": "vendor"}; !reflect.DeepEqual(actions, want) {
+			t.Errorf("%s: planned actions mismatch: have %v, want %v", tt.name, actions, want)
+		}
+		if after := fsFiles(t, mem); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: files changed by dry run:\nhave %v\nwant %v", tt.name, after, before)
+		}
+	}
+}
+
+// Tests that the import path of a project with a not yet module consistent gx
+// vendor tree resolves regardless of an ambient -mod=vendor, while keeping any
+// other flags set in GOFLAGS.
+func TestResolveRoot(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	tests := []struct {
+		name    string
+		goflags string
+		tagged  bool // Whether the project only builds with the gx build tag
+	}{
+		{"clean environment", "", false},
+		{"ambient vendor mode", "-mod=vendor", false},
+		{"ambient vendor mode with other flags", "-mod=vendor -tags=gx", true},
+		{"other flags before vendor mode", "-tags=gx -mod=vendor", true},
+	}
+	for _, tt := range tests {
+		files := map[string]string{
+			"go.mod":              "module example.com/proj\n\ngo 1.16\n",
+			"vendor/modules.txt":  "# github.com/a/foo v1.0.0\n## explicit\ngithub.com/a/foo\n",
+			"vendor/gx/ipfs/x.go": "package x\n",
+			"main.go":             "package main\n\nfunc main() {}\n",
+		}
+		if tt.tagged {
+			files["main.go"] = "//go:build gx\n\npackage main\n\nfunc main() {}\n"
+		}
+		t.Setenv("GO111MODULE", "on")
+		t.Setenv("GOFLAGS", tt.goflags)
+		t.Setenv("GOPROXY", "off")
+		t.Setenv("GOWORK", "off")
+		t.Setenv("GOTOOLCHAIN", "local")
+
+		c := newConversion(Options{FS: osFS{dir: diskProject(t, files)}, Quiet: true})
+
+		root, err := c.resolveRoot()
+		if err != nil {
+			t.Errorf("%s: failed to resolve import path: %v", tt.name, err)
+			continue
+		}
+		if string(root) != "example.com/proj" {
+			t.Errorf("%s: import path mismatch: have %s, want example.com/proj", tt.name, root)
+		}
+	}
+}
+
+// readOnlyFS is an in-memory file system rejecting every modification, the same
+// way a read-only project directory would.
+type readOnlyFS struct {
+	*MemFS
+}
+
+func (fs readOnlyFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return &os.PathError{Op: "write", Path: path, Err: os.ErrPermission}
+}
+func (fs readOnlyFS) CreateFile(path string, data []byte, perm os.FileMode) error {
+	return &os.PathError{Op: "create", Path: path, Err: os.ErrPermission}
+}
+func (fs readOnlyFS) MkdirAll(path string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrPermission}
+}
+func (fs readOnlyFS) Rename(src, dst string) error {
+	return &os.LinkError{Op: "rename", Old: src, New: dst, Err: os.ErrPermission}
+}
+
+// Tests that converting a read-only project fails up front with a clear error,
+// before moving anything, whereas a dry run doesn't need write permission.
+func TestConvertReadOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		readOnly bool
+		dryRun   bool
+		fails    bool
+	}{
+		{"writable project", false, false, false},
+		{"read-only project", true, false, true},
+		{"read-only dry run", true, true, false},
+	}
+	for _, tt := range tests {
+		mem := memProject(t, gxProject)
+
+		opts := memOptions(t, mem, gxDecisions)
+		opts.DryRun = tt.dryRun
+		if tt.readOnly {
+			opts.FS = readOnlyFS{mem}
+		}
+		_, err := Convert(opts)
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: conversion error mismatch: have %v, want failure %v", tt.name, err, tt.fails)
+		}
+		if err != nil && !strings.Contains(err.Error(), "not writable") {
+			t.Errorf("%s: unclear conversion error: %v", tt.name, err)
+		}
+		if !tt.readOnly {
+			continue
+		}
+		for path, content := range gxProject {
+			if blob, err := mem.ReadFile(path); err != nil || string(blob) != content {
+				t.Errorf("%s: %s changed: %v", tt.name, path, err)
+			}
+		}
+	}
+}
+
+// BenchmarkLoadSpecs measures loading the package definitions of a large gx
+// dependency tree from disk, serially and with a pool of workers.
+func BenchmarkLoadSpecs(b *testing.B) {
+	files := make(map[string]string)
+	hashes := make([]string, 0, 256)
+	for i := 0; i < 256; i++ {
+		hash := fmt.Sprintf("Qm%04d", i)
+		hashes = append(hashes, hash)
+
+		files[hash+"/pkg/package.json"] = fmt.Sprintf(`{"name": "pkg", "version": "1.0.%d", "gx": {"dvcsimport": "github.com/org/pkg%d"}}`, i, i)
+		files[hash+"/pkg/pkg.go"] = "package pkg\n"
+	}
+	c := newConversion(Options{FS: osFS{dir: diskProject(b, files)}, Quiet: true})
+
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if specs, failed := c.loadSpecs(".", hashes, workers); len(specs) != len(hashes) || len(failed) != 0 {
+					b.Fatalf("load mismatch: %d specs, %d failures", len(specs), len(failed))
+				}
+			}
+		})
+	}
+}
+
+// Tests that the changed file list holds exactly the files rewritten in place
+// and the ones moved along with their packages, sorted by path.
+func TestConvertChangedFiles(t *testing.T) {
+	files := map[string]string{
+		"untouched.go": "package main\n\nimport \"fmt\"\n\nvar _ = fmt.Println\n",
+		"sub/sub.go":   "package sub\n\nimport \"gx/ipfs/QmBar/bar\"\n\nvar _ = bar.Bar\n",
+		"vendor/gx/ipfs/QmFoo/foo/inner/inner.go": "package inner\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+	opts := memOptions(t, mem, gxDecisions)
+	opts.ChangedFiles = filepath.Join(t.TempDir(), "changed.txt")
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	blob, err := ioutil.ReadFile(opts.ChangedFiles)
+	if err != nil {
+		t.Fatalf("failed to read changed file list: %v", err)
+	}
+	want := strings.Join([]string{
+		"gxlibs/github.com/a/foo/foo.go",
+		"gxlibs/github.com/a/foo/inner/inner.go",
+		"gxlibs/github.com/a/foo/package.json",
+		"main.go",
+		"sub/sub.go",
+		"vendor/github.com/b/bar/bar.go",
+		"vendor/github.com/b/bar/package.json",
+	}, "\n") + "\n"
+	if string(blob) != want {
+		t.Errorf("changed file list mismatch:\nhave:\n%s\nwant:\n%s", blob, want)
+	}
+}
+
+// Tests that a project without any gx dependencies converts successfully with
+// nothing to move, only rewriting its own imports when forking.
+func TestConvertNoDependencies(t *testing.T) {
+	files := map[string]string{
+		"main.go":    "package main\n\nimport \"example.com/proj/sub\"\n\nfunc main() { sub.Sub() }\n",
+		"sub/sub.go": "package sub\n\nfunc Sub() {}\n",
+	}
+	tests := []struct {
+		name string
+		fork string
+		want string // Expected content of main.go after conversion
+	}{
+		{"in place", "", files["main.go"]},
+		{"forked", "example.org/fork", "package main\n\nimport \"example.org/fork/sub\"\n\nfunc main() { sub.Sub() }\n"},
+	}
+	for _, tt := range tests {
+		mem := memProject(t, files)
+		opts := memOptions(t, mem, "{}")
+		opts.Fork = tt.fork
+
+		report, err := Convert(opts)
+		if err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		if len(report.Packages) != 0 {
+			t.Errorf("%s: packages converted: %+v", tt.name, report.Packages)
+		}
+		want := map[string]string{"main.go": tt.want, "sub/sub.go": files["sub/sub.go"]}
+
+		have := fsFiles(t, mem)
+		delete(have, manifestFile)
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: converted tree mismatch:\nhave %q\nwant %q", tt.name, have, want)
+		}
+	}
+}
+
+// Tests that forking rewrites the imports between embedded dependencies to the
+// fork too, and records rewrite rules consistent with the forked tree.
+func TestConvertForkEmbedded(t *testing.T) {
+	files := map[string]string{
+		"vendor/gx/ipfs/QmFoo/foo/foo.go":       "package foo\n\nimport \"gx/ipfs/QmBaz/baz\"\n\nfunc Foo() { baz.Baz() }\n",
+		"vendor/gx/ipfs/QmBaz/baz/package.json": `{"name": "baz", "version": "1.0.0", "gx": {"dvcsimport": "github.com/c/baz"}}`,
+		"vendor/gx/ipfs/QmBaz/baz/baz.go":       "package baz\n\nfunc Baz() {}\n",
+	}
+	for path, content := range gxProject {
+		if files[path] == "" {
+			files[path] = content
+		}
+	}
+	mem := memProject(t, files)
+	opts := memOptions(t, mem, `{"github.com/a/foo@v1.0.0": true, "github.com/c/baz@v1.0.0": true, "github.com/b/bar": false}`)
+	opts.Fork = "example.org/fork"
+
+	report, err := Convert(opts)
+	if err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	want := map[string]string{
+		"main.go":                        `"example.org/fork/gxlibs/github.com/a/foo"`,
+		"gxlibs/github.com/a/foo/foo.go": `"example.org/fork/gxlibs/github.com/c/baz"`,
+	}
+	for path, imp := range want {
+		blob, err := mem.ReadFile(path)
+		if err != nil {
+			t.Errorf("%s: failed to read: %v", path, err)
+			continue
+		}
+		if !strings.Contains(string(blob), imp) {
+			t.Errorf("%s: import %s missing:\n%s", path, imp, blob)
+		}
+	}
+	for path, gopath := range report.Rewrites {
+		if strings.HasPrefix(gopath, "example.com/proj") {
+			t.Errorf("rewrite rule %s -> %s not rebased onto the fork", path, gopath)
+		}
+	}
+	if have := report.Rewrites["gx/ipfs/QmBaz/baz"]; have != "example.org/fork/gxlibs/github.com/c/baz" {
+		t.Errorf("embedded rule mismatch: have %s, want example.org/fork/gxlibs/github.com/c/baz", have)
+	}
+}
+
+// Tests that conversions running concurrently keep their own state, each one
+// converting its own project with its own options.
+func TestConvertConcurrent(t *testing.T) {
+	libs := []string{"gxlibs", "third_party", "internal/gx", "deps"}
+
+	mems := make([]*MemFS, 8)
+	errs := make([]error, len(mems))
+
+	var pend sync.WaitGroup
+	for i := range mems {
+		mems[i] = memProject(t, gxProject)
+
+		opts := memOptions(t, mems[i], gxDecisions)
+		opts.LibDir = libs[i%len(libs)]
+
+		pend.Add(1)
+		go func(i int) {
+			defer pend.Done()
+			_, errs[i] = Convert(opts)
+		}(i)
+	}
+	pend.Wait()
+
+	for i, mem := range mems {
+		if errs[i] != nil {
+			t.Errorf("conversion %d: failed: %v", i, errs[i])
+			continue
+		}
+		lib := libs[i%len(libs)]
+		if _, err := mem.Stat(lib + "/github.com/a/foo/foo.go"); err != nil {
+			t.Errorf("conversion %d: package not embedded into %s: %v", i, lib, err)
+		}
+		blob, err := mem.ReadFile("main.go")
+		if err != nil {
+			t.Fatalf("conversion %d: failed to read main.go: %v", i, err)
+		}
+		if want := `"example.com/proj/` + lib + `/github.com/a/foo"`; !strings.Contains(string(blob), want) {
+			t.Errorf("conversion %d: import %s missing:\n%s", i, want, blob)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// materializeSymlinks replaces all the symlinks within a folder that point out
+// of it with copies of their targets, so that the folder remains self contained
+// when moved (e.g. a LICENSE linking to the license of the parent repository).
+func (c *conversion) materializeSymlinks(root string) error {
+	abs, err := filepath.Abs(c.diskPath(root))
+	if err != nil {
+		return err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return err
+	}
+	return c.fsys.Walk(root, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return err
+		}
+		target, err := filepath.EvalSymlinks(c.diskPath(fp))
+		if err != nil {
+			c.logWarn("Warning, dangling symlink %s left in place: %v", fp, err)
+			return nil
+		}
+		if target == abs || strings.HasPrefix(target, abs+string(filepath.Separator)) {
+			return nil
+		}
+		c.logDebug("Replacing external symlink %s with a copy of %s", fp, target)
+		if err := c.fsys.Remove(fp); err != nil {
+			return err
+		}
+		return c.copyTree(target, fp)
+	})
+}
+
+// copyTree recursively copies a file or folder to a new location, preserving
+// the file permissions. Symlinks are recreated, not followed.
+func (c *conversion) copyTree(src, dst string) error {
+	return c.fsys.Walk(src, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, fp)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			return c.fsys.MkdirAll(path, fi.Mode().Perm()|0700)
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := c.fsys.Readlink(fp)
+			if err != nil {
+				return err
+			}
+			return c.fsys.Symlink(target, path)
+		default:
+			blob, err := c.fsys.ReadFile(fp)
+			if err != nil {
+				return err
+			}
+			return c.fsys.WriteFile(path, blob, fi.Mode().Perm())
+		}
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Tests that symlinks within moved packages pointing out of them are replaced
+// with copies of their targets, while the ones pointing inside are kept as is.
+func TestConvertExternalSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+	fakeCommand(t, "gx", "exit 0\n")
+
+	files := map[string]string{
+		"LICENSE":       "MIT License\n",
+		"docs/guide.md": "# Guide\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	dir := diskProject(t, files)
+
+	links := map[string]string{
+		"vendor/gx/ipfs/QmBar/bar/LICENSE": "../../../../../LICENSE",
+		"vendor/gx/ipfs/QmBar/bar/docs":    "../../../../../docs",
+		"vendor/gx/ipfs/QmBar/bar/link.go": "bar.go",
+		"vendor/gx/ipfs/QmFoo/foo/LICENSE": "../../../../../LICENSE",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Fatalf("failed to create symlink %s: %v", link, err)
+		}
+	}
+	opts := memOptions(t, nil, gxDecisions)
+	opts.FS = osFS{dir: dir}
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	tests := []struct {
+		path    string
+		symlink bool
+		content string // Expected content of the file (not checked for folders)
+	}{
+		{"vendor/github.com/b/bar/LICENSE", false, "MIT License\n"},
+		{"vendor/github.com/b/bar/docs/guide.md", false, "# Guide\n"},
+		{"vendor/github.com/b/bar/link.go", true, ""},
+		{"gxlibs/github.com/a/foo/LICENSE", false, "MIT License\n"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, filepath.FromSlash(tt.path))
+
+		fi, err := os.Lstat(path)
+		if err != nil {
+			t.Errorf("%s: missing after conversion: %v", tt.path, err)
+			continue
+		}
+		if symlink := fi.Mode()&os.ModeSymlink != 0; symlink != tt.symlink {
+			t.Errorf("%s: symlink mismatch: have %v, want %v", tt.path, symlink, tt.symlink)
+		}
+		if tt.content != "" {
+			if blob, _ := ioutil.ReadFile(path); string(blob) != tt.content {
+				t.Errorf("%s: content mismatch: have %q, want %q", tt.path, blob, tt.content)
+			}
+		}
+	}
+	// The targets of the materialized links must be left intact
+	if blob, _ := ioutil.ReadFile(filepath.Join(dir, "LICENSE")); string(blob) != "MIT License\n" {
+		t.Errorf("symlink target modified: %q", blob)
+	}
+}
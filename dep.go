@@ -0,0 +1,44 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// depProject matches the project names in a dep lock file.
+var depProject = regexp.MustCompile(`(?m)^\s*name\s*=\s*"([^"]+)"`)
+
+// depProjects parses the Gopkg.lock file of a dep managed project (if any) and
+// returns the import paths of all the projects vendored in by dep.
+func (c *conversion) depProjects() ([]string, error) {
+	blob, err := c.fsys.ReadFile("Gopkg.lock")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var projects []string
+	for _, match := range depProject.FindAllSubmatch(blob, -1) {
+		projects = append(projects, string(match[1]))
+	}
+	return projects, nil
+}
+
+// depManaged returns the dep project that a file or folder is part of, or an
+// empty string if the path is not inside a dep vendored project.
+func depManaged(projects []string, fp string) string {
+	for _, project := range projects {
+		dir := filepath.Join("vendor", filepath.FromSlash(project))
+		if fp == dir || strings.HasPrefix(fp, dir+string(filepath.Separator)) {
+			return project
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// knownRepoHosts returns the layouts of the code hosts known out of the box.
+func knownRepoHosts() map[string]int {
+	return map[string]int{
+		"github.com":    3,
+		"gitlab.com":    3,
+		"bitbucket.org": 3,
+		"golang.org":    3,
+		"gopkg.in":      2,
+	}
+}
+
+// repoRoot guesses the repository root of an import path based on the known
+// layout of its host. Unknown hosts are assumed to be vanity domains hosting
+// repositories directly under the root.
+func (c *conversion) repoRoot(path string) string {
+	parts := strings.Split(path, "/")
+
+	segments, ok := c.repoHosts[parts[0]]
+	if !ok {
+		segments = 2
+	}
+	// gopkg.in supports both gopkg.in/pkg.v1 and gopkg.in/user/pkg.v1
+	if parts[0] == "gopkg.in" && len(parts) > 2 && !strings.Contains(parts[1], ".v") {
+		segments = 3
+	}
+	if len(parts) < segments {
+		return path
+	}
+	return strings.Join(parts[:segments], "/")
+}
+
+// repoGroup is a single repository in the dependency report, along with all the
+// packages pulled in from it.
+type repoGroup struct {
+	Repo     string          `json:"repo"`
+	Packages []ReportPackage `json:"packages"`
+}
+
+// writeDependencyReport groups all the gx dependencies of a conversion by their
+// repository roots and writes the grouped view into a JSON file.
+func (c *conversion) writeDependencyReport(file string, pkgs []ReportPackage) error {
+	groups := make(map[string]*repoGroup)
+	for _, pkg := range pkgs {
+		repo := c.repoRoot(pkg.Path)
+		if groups[repo] == nil {
+			groups[repo] = &repoGroup{Repo: repo}
+		}
+		groups[repo].Packages = append(groups[repo].Packages, pkg)
+	}
+	var report []*repoGroup
+	for _, group := range groups {
+		sort.Slice(group.Packages, func(i, j int) bool {
+			if group.Packages[i].Path != group.Packages[j].Path {
+				return group.Packages[i].Path < group.Packages[j].Path
+			}
+			return group.Packages[i].Hash < group.Packages[j].Hash
+		})
+		report = append(report, group)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Repo < report[j].Repo })
+
+	blob, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(blob, '\n'), 0644)
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests that repository roots are derived from the layouts of the known hosts,
+// falling back to vanity domains hosting repositories under the root.
+func TestRepoRoot(t *testing.T) {
+	c := newConversion(DefaultOptions())
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"github.com/a/repo", "github.com/a/repo"},
+		{"github.com/a/repo/sub/pkg", "github.com/a/repo"},
+		{"golang.org/x/net/context", "golang.org/x/net"},
+		{"gopkg.in/yaml.v2", "gopkg.in/yaml.v2"},
+		{"gopkg.in/check.v1/sub", "gopkg.in/check.v1"},
+		{"gopkg.in/user/pkg.v1/sub", "gopkg.in/user/pkg.v1"},
+		{"example.org/repo/sub", "example.org/repo"},
+		{"github.com/a", "github.com/a"},
+	}
+	for _, tt := range tests {
+		if have := c.repoRoot(tt.path); have != tt.want {
+			t.Errorf("%s: repo root mismatch: have %s, want %s", tt.path, have, tt.want)
+		}
+	}
+}
+
+// Tests that the dependency report groups the packages by repository, sorting
+// both the repositories and the packages within them.
+func TestWriteDependencyReport(t *testing.T) {
+	c := newConversion(DefaultOptions())
+
+	pkgs := []ReportPackage{
+		{Hash: "QmSub2", Path: "github.com/a/repo/sub2", Action: "vendor"},
+		{Hash: "QmYaml", Path: "gopkg.in/yaml.v2", Action: "vendor"},
+		{Hash: "QmRoot", Path: "github.com/a/repo", Action: "embed"},
+		{Hash: "QmSub1", Path: "github.com/a/repo/sub1", Action: "vendor"},
+		{Hash: "QmOther", Path: "github.com/b/other", Action: "skip"},
+		{Hash: "QmRoot0", Path: "github.com/a/repo", Action: "dedupe"},
+	}
+	file := filepath.Join(t.TempDir(), "deps.json")
+	if err := c.writeDependencyReport(file, pkgs); err != nil {
+		t.Fatalf("failed to write dependency report: %v", err)
+	}
+	blob, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read dependency report: %v", err)
+	}
+	var report []repoGroup
+	if err := json.Unmarshal(blob, &report); err != nil {
+		t.Fatalf("failed to parse dependency report: %v\n%s", err, blob)
+	}
+	have := make([][]string, len(report))
+	for i, group := range report {
+		have[i] = append(have[i], group.Repo)
+		for _, pkg := range group.Packages {
+			have[i] = append(have[i], pkg.Hash)
+		}
+	}
+	want := [][]string{
+		{"github.com/a/repo", "QmRoot", "QmRoot0", "QmSub1", "QmSub2"},
+		{"github.com/b/other", "QmOther"},
+		{"gopkg.in/yaml.v2", "QmYaml"},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("grouping mismatch: have %v, want %v", have, want)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// streamEvents creates a channel whose events are written as JSON lines into
+// the given file (or stdout if "-"). The returned function waits until all the
+// events were written after the channel was closed.
+func (c *conversion) streamEvents(file string) (chan<- ReportEntry, func(), error) {
+	var out io.WriteCloser = os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = f
+	}
+	var (
+		events = make(chan ReportEntry)
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+
+		enc := json.NewEncoder(out)
+		for event := range events {
+			if err := enc.Encode(event); err != nil {
+				c.logWarn("Failed to write event: %v", err)
+			}
+		}
+		if out != os.Stdout {
+			out.Close()
+		}
+	}()
+	return events, func() { <-done }, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that every report entry is streamed into the events channel, including
+// the skips of packages that failed to load, and that the channel is closed at
+// the end of the conversion.
+func TestConvertEvents(t *testing.T) {
+	files := map[string]string{"vendor/gx/ipfs/QmBroken/broken/package.json": "{"}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+	opts := memOptions(t, mem, gxDecisions)
+	opts.OnUnreadable = "skip"
+
+	events := make(chan ReportEntry)
+	opts.Events = events
+
+	entries := make(chan []ReportEntry)
+	go func() {
+		var collected []ReportEntry
+		for entry := range events {
+			collected = append(collected, entry)
+		}
+		entries <- collected
+	}()
+	report, err := Convert(opts)
+	if err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	var streamed []ReportEntry
+	select {
+	case streamed = <-entries:
+	case <-time.After(time.Second):
+		t.Fatalf("events channel not closed after conversion")
+	}
+
+	var packages, rewritten int
+	for _, entry := range streamed {
+		switch {
+		case entry.Package != nil:
+			packages++
+			if entry.Package.Hash == "QmBroken" && (entry.Package.Action != "skip" || !strings.HasPrefix(entry.Package.Reason, "failed to load")) {
+				t.Errorf("broken package entry mismatch: %+v", entry.Package)
+			}
+		case entry.File != "":
+			rewritten++
+		}
+	}
+	if packages != len(report.Packages) || packages != 3 {
+		t.Errorf("streamed package count mismatch: have %d, report %d, want 3", packages, len(report.Packages))
+	}
+	if rewritten != len(report.Rewritten) || rewritten != 1 {
+		t.Errorf("streamed file count mismatch: have %d, report %d, want 1", rewritten, len(report.Rewritten))
+	}
+}
+
+// Tests that the events channel is closed even if the conversion fails midway.
+func TestConvertEventsFailure(t *testing.T) {
+	files := map[string]string{"gxlibs/github.com/a/foo/fork.go": "package foo\n"}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	opts := memOptions(t, memProject(t, files), gxDecisions)
+
+	events := make(chan ReportEntry)
+	opts.Events = events
+
+	done := make(chan struct{})
+	go func() {
+		for range events {
+		}
+		close(done)
+	}()
+	if _, err := Convert(opts); err == nil {
+		t.Fatalf("conversion over existing destination succeeded")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("events channel not closed after failed conversion")
+	}
+}
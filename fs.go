@@ -0,0 +1,121 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FS is the file system the conversion loads, moves and rewrites packages on.
+// It defaults to the operating system's, but can be swapped out (e.g. for an
+// in-memory one) to exercise a conversion without touching the disk. ReadDir and
+// Walk report symlinks as such, without following them.
+type FS interface {
+	Stat(path string) (os.FileInfo, error)
+	ReadDir(path string) ([]os.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+
+	// CreateFile writes a new file, failing with an os.IsExist error if one
+	// already exists at the given path (used for locking).
+	CreateFile(path string, data []byte, perm os.FileMode) error
+
+	Readlink(path string) (string, error)
+	Symlink(target, path string) error
+	MkdirAll(path string, perm os.FileMode) error
+	Rename(src, dst string) error
+	Remove(path string) error
+	RemoveAll(path string) error
+	Walk(root string, fn filepath.WalkFunc) error
+}
+
+// osFS is the FS backed by the operating system, rooted at a project folder (or
+// the current directory, if empty). Files are written atomically, so readers
+// never observe a partially rewritten file.
+type osFS struct {
+	dir string
+}
+
+// path resolves a path relative to the root of the file system.
+func (fs osFS) path(path string) string {
+	if fs.dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(fs.dir, path)
+}
+
+func (fs osFS) Stat(path string) (os.FileInfo, error)      { return os.Stat(fs.path(path)) }
+func (fs osFS) ReadDir(path string) ([]os.FileInfo, error) { return ioutil.ReadDir(fs.path(path)) }
+func (fs osFS) ReadFile(path string) ([]byte, error)       { return ioutil.ReadFile(fs.path(path)) }
+func (fs osFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(fs.path(path), data, perm)
+}
+func (fs osFS) CreateFile(path string, data []byte, perm os.FileMode) error {
+	path = fs.path(path)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+func (fs osFS) Readlink(path string) (string, error) { return os.Readlink(fs.path(path)) }
+func (fs osFS) Symlink(target, path string) error    { return os.Symlink(target, fs.path(path)) }
+func (fs osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(fs.path(path), perm)
+}
+func (fs osFS) Rename(src, dst string) error { return os.Rename(fs.path(src), fs.path(dst)) }
+func (fs osFS) Remove(path string) error     { return os.Remove(fs.path(path)) }
+func (fs osFS) RemoveAll(path string) error  { return os.RemoveAll(fs.path(path)) }
+
+// Walk walks a folder tree like filepath.Walk, reporting the paths relative to
+// the root of the file system, the same way they were requested.
+func (fs osFS) Walk(root string, fn filepath.WalkFunc) error {
+	base := fs.path(root)
+	if base == root {
+		return filepath.Walk(root, fn)
+	}
+	return filepath.Walk(base, func(fp string, fi os.FileInfo, err error) error {
+		rel, rerr := filepath.Rel(base, fp)
+		if rerr != nil {
+			return rerr
+		}
+		return fn(filepath.Join(root, rel), fi, err)
+	})
+}
+
+// onDisk returns whether the conversion operates on the operating system's file
+// system, needed by the features that shell out or deal with symlinks.
+func (c *conversion) onDisk() bool {
+	_, ok := c.fsys.(osFS)
+	return ok
+}
+
+// diskPath returns the operating system path of a file within the project, as
+// needed when shelling out or resolving symlinks.
+func (c *conversion) diskPath(path string) string {
+	if fs, ok := c.fsys.(osFS); ok {
+		return fs.path(path)
+	}
+	return path
+}
+
+// projectDir returns the folder the commands operating on the project need to
+// run in, or an empty string for the current directory.
+func (c *conversion) projectDir() string {
+	if fs, ok := c.fsys.(osFS); ok {
+		return fs.dir
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+// gitRepo returns whether the project is inside a git work tree.
+func (c *conversion) gitRepo() bool {
+	out, err := c.gitCommand("rev-parse", "--is-inside-work-tree").Output()
+	return err == nil && string(out) == "true\n"
+}
+
+// gitDirty returns whether the project has any uncommitted changes (including
+// untracked files), which a commit of the conversion would mix up with its own.
+func (c *conversion) gitDirty() (bool, error) {
+	out, err := c.gitCommand("status", "--porcelain", "--", ".", ":(exclude)"+lockFile).Output()
+	if err != nil {
+		return false, err
+	}
+	return len(bytes.TrimSpace(out)) > 0, nil
+}
+
+// gitCommit stages all the changes within the given paths and commits them
+// with the specified message. Paths neither present nor tracked are ignored and
+// if there's nothing to commit, it's a noop.
+func (c *conversion) gitCommit(message string, paths ...string) error {
+	var pathspecs bytes.Buffer
+	for _, path := range paths {
+		if _, err := c.fsys.Stat(path); err == nil || c.gitTracked(path) {
+			pathspecs.WriteString(filepath.ToSlash(path) + "\x00")
+		}
+	}
+	if pathspecs.Len() == 0 {
+		c.logInfo("Nothing to commit for: %s", message)
+		return nil
+	}
+	// Never commit the lock file of the conversion in progress
+	pathspecs.WriteString(":(exclude)" + lockFile + "\x00")
+
+	// Pass the paths via stdin, rewrites may touch more files than fit a command line
+	add := c.gitCommand("add", "-A", "--pathspec-from-file=-", "--pathspec-file-nul")
+	add.Stdin = &pathspecs
+	if out, err := add.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	if err := c.gitCommand("diff", "--cached", "--quiet").Run(); err == nil {
+		c.logInfo("Nothing to commit for: %s", message)
+		return nil
+	}
+	c.logInfo("Committing: %s", message)
+	if out, err := c.gitCommand("commit", "-q", "-m", message).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+// gitTracked returns whether git tracks any files within a path, which may have
+// been deleted from the work tree by the conversion.
+func (c *conversion) gitTracked(path string) bool {
+	out, err := c.gitCommand("ls-files", "--", path).Output()
+	return err == nil && len(out) > 0
+}
+
+// gitCommand creates a git command operating on the project.
+func (c *conversion) gitCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = c.projectDir()
+	return cmd
+}
+
+// gitMove moves a file or folder via `git mv`, so that the history follows it.
+// Untracked sources are rejected by git without touching anything.
+func (c *conversion) gitMove(src, dst string) error {
+	if out, err := c.gitCommand("mv", src, dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitRun runs a git command in the given folder, failing the test on error.
+func gitRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// Tests that committing the conversion is refused on a dirty work tree, and that
+// the commits only contain the paths touched by the conversion.
+func TestConvertGitCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	fakeCommand(t, "gx", "exit 0\n")
+
+	t.Setenv("GIT_AUTHOR_NAME", "ungx")
+	t.Setenv("GIT_AUTHOR_EMAIL", "ungx@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "ungx")
+	t.Setenv("GIT_COMMITTER_EMAIL", "ungx@example.com")
+
+	tests := []struct {
+		name  string
+		dirty string // File modified before the conversion, if any
+	}{
+		{"clean tree", ""},
+		{"modified file", "main.go"},
+		{"untracked file", "notes.txt"},
+	}
+	for _, tt := range tests {
+		dir := diskProject(t, gxProject)
+		gitRun(t, dir, "init", "-q")
+		gitRun(t, dir, "add", "-A")
+		gitRun(t, dir, "commit", "-q", "-m", "Initial commit")
+
+		if tt.dirty != "" {
+			if err := ioutil.WriteFile(filepath.Join(dir, tt.dirty), []byte("package main\n"), 0644); err != nil {
+				t.Fatalf("%s: failed to dirty work tree: %v", tt.name, err)
+			}
+		}
+		opts := memOptions(t, nil, gxDecisions)
+		opts.FS = osFS{dir: dir}
+		opts.GitCommits = true
+		opts.ReportFile = filepath.Join(dir, "report.json")
+
+		_, err := Convert(opts)
+		if tt.dirty != "" {
+			if err == nil || !strings.Contains(err.Error(), "clean working tree") {
+				t.Errorf("%s: conversion error mismatch: have %v, want dirty tree refusal", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		if log := gitRun(t, dir, "log", "--format=%s"); strings.Count(log, "\n") != 4 {
+			t.Errorf("%s: commit count mismatch, have:\n%s", tt.name, log)
+		}
+		// The report was written into the project, but is not part of the conversion
+		if status := gitRun(t, dir, "status", "--porcelain"); status != "?? report.json\n" {
+			t.Errorf("%s: uncommitted changes mismatch: have %q, want the report only", tt.name, status)
+		}
+	}
+}
+
+// Tests that packages are moved via git mv inside a git repository, staging the
+// moves as renames, and that the conversion falls back to plain renames outside.
+func TestConvertGitMoves(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	fakeCommand(t, "gx", "exit 0\n")
+
+	t.Setenv("GIT_AUTHOR_NAME", "ungx")
+	t.Setenv("GIT_AUTHOR_EMAIL", "ungx@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "ungx")
+	t.Setenv("GIT_COMMITTER_EMAIL", "ungx@example.com")
+
+	tests := []struct {
+		name string
+		repo bool // Whether the project is a git repository
+	}{
+		{"git repository", true},
+		{"plain folder", false},
+	}
+	for _, tt := range tests {
+		dir := diskProject(t, gxProject)
+		if tt.repo {
+			gitRun(t, dir, "init", "-q")
+			gitRun(t, dir, "add", "-A")
+			gitRun(t, dir, "commit", "-q", "-m", "Initial commit")
+		}
+		opts := memOptions(t, nil, gxDecisions)
+		opts.FS = osFS{dir: dir}
+		opts.GitMoves = true
+
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		for _, path := range []string{"gxlibs/github.com/a/foo/foo.go", "vendor/github.com/b/bar/bar.go"} {
+			if _, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(path))); err != nil {
+				t.Errorf("%s: %s missing after conversion: %v", tt.name, path, err)
+			}
+		}
+		if !tt.repo {
+			continue
+		}
+		status := gitRun(t, dir, "status", "--porcelain")
+		for _, rename := range []string{
+			"R  vendor/gx/ipfs/QmFoo/foo/foo.go -> gxlibs/github.com/a/foo/foo.go",
+			"R  vendor/gx/ipfs/QmBar/bar/bar.go -> vendor/github.com/b/bar/bar.go",
+		} {
+			if !strings.Contains(status, rename+"\n") {
+				t.Errorf("%s: move not staged as rename %q:\n%s", tt.name, rename, status)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// knownGitHubHosts returns the GitHub hosts known out of the box.
+func knownGitHubHosts() map[string]string {
+	return map[string]string{"github.com": "raw.githubusercontent.com"}
+}
+
+// addGitHubHost registers a GitHub Enterprise host along with the endpoint that
+// serves the raw contents of its repositories.
+func (c *conversion) addGitHubHost(host string, endpoint string) {
+	c.githubRawHosts[host] = strings.TrimSuffix(endpoint, "/")
+
+	// Enterprise hosts use the same repository layout as the public one
+	c.repoHosts[host] = c.repoHosts["github.com"]
+}
+
+// githubHosted returns whether an import path points to a known GitHub host.
+func (c *conversion) githubHosted(path string) bool {
+	_, ok := c.githubRawHosts[strings.Split(path, "/")[0]]
+	return ok
+}
+
+// githubRawURL returns the URL serving the raw contents of a file within the
+// repository of a GitHub hosted import path, at the given branch.
+func (c *conversion) githubRawURL(path string, branch string, file string) string {
+	parts := strings.SplitN(path, "/", 2)
+	return fmt.Sprintf("https://%s/%s/%s/%s", c.githubRawHosts[parts[0]], parts[1], branch, file)
+}
+
+// githubAPI returns the API endpoint of a GitHub host. Enterprise installations
+// serve the API under a path of the host itself.
+func githubAPI(host string) string {
+	if host == "github.com" {
+		return "https://api.github.com"
+	}
+	return "https://" + host + "/api/v3"
+}
+
+// githubDefaultBranch queries the GitHub API for the default branch of the repo
+// hosting a package. As unauthenticated API requests are heavily rate limited,
+// the lookup is only done if a GITHUB_TOKEN is available. An empty string is
+// returned if the branch cannot be determined.
+func (c *conversion) githubDefaultBranch(path string) string {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return ""
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/repos/%s/%s", githubAPI(parts[0]), parts[1], parts[2]), nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	res, err := c.httpDo(req)
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+
+	var repo struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if res.StatusCode != http.StatusOK || json.NewDecoder(res.Body).Decode(&repo) != nil {
+		io.Copy(ioutil.Discard, res.Body)
+		return ""
+	}
+	return repo.DefaultBranch
+}
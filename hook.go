@@ -0,0 +1,33 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"os"
+	"os/exec"
+)
+
+// packageMoved invokes the post-move hook (if any) for a relocated package. In
+// read only mode nothing was moved, so the hook is not invoked either.
+func (c *conversion) packageMoved(path, dest string) error {
+	if c.onPackageMoved == nil || c.readonly() {
+		return nil
+	}
+	return c.onPackageMoved(path, dest)
+}
+
+// commandHook creates a post-move hook running a shell command. The canonical
+// import path and the destination folder are passed both as positional args
+// ($1 and $2) and via the UNGX_PATH and UNGX_DEST environment variables.
+func (c *conversion) commandHook(command string) func(path, dest string) error {
+	return func(path, dest string) error {
+		hook := exec.CommandContext(c.interrupt, "sh", "-c", command, "ungx-hook", path, dest)
+		hook.Dir = c.projectDir()
+		hook.Stdout = c.commandOutput()
+		hook.Stderr = os.Stderr
+		hook.Env = append(os.Environ(), "UNGX_PATH="+path, "UNGX_DEST="+dest)
+		return hook.Run()
+	}
+}
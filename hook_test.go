@@ -0,0 +1,45 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests that the post-move callback of the options is invoked for every moved
+// package, and that a failing one aborts the conversion.
+func TestOnPackageMoved(t *testing.T) {
+	mem := memProject(t, gxProject)
+	opts := memOptions(t, mem, gxDecisions)
+
+	moved := make(map[string]string)
+	opts.OnPackageMoved = func(path, dest string) error {
+		moved[path] = filepath.ToSlash(dest)
+		return nil
+	}
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	want := map[string]string{
+		"github.com/a/foo": "gxlibs/github.com/a/foo",
+		"github.com/b/bar": "vendor/github.com/b/bar",
+	}
+	if !reflect.DeepEqual(moved, want) {
+		t.Errorf("moved packages mismatch: have %v, want %v", moved, want)
+	}
+	// Ensure a failing callback aborts the conversion
+	mem = memProject(t, gxProject)
+	opts = memOptions(t, mem, gxDecisions)
+	opts.OnPackageMoved = func(path, dest string) error {
+		return errors.New("boom")
+	}
+	if _, err := Convert(opts); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("failing callback error mismatch: have %v, want boom", err)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// httpBackoff is the initial wait time before retrying a failed HTTP request.
+var httpBackoff = 500 * time.Millisecond
+
+// newHTTPClient creates the client of a new conversion, capping the concurrent
+// connections and bounding the time of each request. The returned semaphore
+// enforces the cap across all the hosts.
+func newHTTPClient(conns int, timeout time.Duration) (*http.Client, chan struct{}) {
+	if conns < 1 {
+		conns = 1
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxConnsPerHost = conns
+	transport.MaxIdleConnsPerHost = conns
+
+	return &http.Client{Transport: transport, Timeout: timeout}, make(chan struct{}, conns)
+}
+
+// httpGet issues a GET request through the connection capped client of the
+// conversion. The connection slot is released when the response body is closed.
+func (c *conversion) httpGet(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.httpDo(req)
+}
+
+// httpDo issues a body-less request through the connection capped client of the
+// conversion, retrying transient failures (see transient). The response of the
+// last attempt is returned, so a persistent server error is still visible to the
+// caller. The connection slot is released when the response body is closed.
+func (c *conversion) httpDo(req *http.Request) (*http.Response, error) {
+	backoff := httpBackoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.httpAttempt(req)
+		if attempt >= c.config.HTTPRetries || !c.transient(res, err) {
+			return res, err
+		}
+		if err == nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			err = errors.New(res.Status)
+		}
+		c.logWarn("Failed to fetch %s, retrying in %v: %v", req.URL, backoff, err)
+		if err := c.sleep(backoff); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// transient reports whether a failed request is worth retrying: network errors
+// flagged as timeouts or temporary, and gateway responses (502, 503 and 504).
+// Other errors will fail the same way again, and nothing is retried once the
+// conversion was aborted.
+func (c *conversion) transient(res *http.Response, err error) bool {
+	if c.interrupt.Err() != nil {
+		return false
+	}
+	if err != nil {
+		var nerr net.Error
+		return errors.As(err, &nerr) && (nerr.Timeout() || nerr.Temporary())
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// httpAttempt issues a single request through the connection capped client of
+// the conversion. The connection slot is released when the response body is closed.
+func (c *conversion) httpAttempt(req *http.Request) (*http.Response, error) {
+	slots := c.httpSlots
+	slots <- struct{}{}
+
+	res, err := c.httpClient.Do(req.WithContext(c.interrupt))
+	if err != nil {
+		<-slots
+		return nil, err
+	}
+	res.Body = &slotReleaser{ReadCloser: res.Body, slots: slots}
+	return res, nil
+}
+
+// slotReleaser is a response body wrapper releasing the connection slot of the
+// request when closed.
+type slotReleaser struct {
+	io.ReadCloser
+	slots chan struct{}
+	once  sync.Once
+}
+
+func (r *slotReleaser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(func() { <-r.slots })
+	return err
+}
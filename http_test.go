@@ -0,0 +1,149 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Tests that the HTTP client of a conversion never has more requests in flight than the
+// configured connection cap, even when hammered by many goroutines.
+func TestHTTPConnectionCap(t *testing.T) {
+	tests := []struct {
+		name     string
+		conns    int // Connection cap to configure
+		requests int // Number of concurrent requests to issue
+		want     int // Maximum number of requests expected in flight
+	}{
+		{"serial", 1, 16, 1},
+		{"capped", 4, 64, 4},
+		{"uncapped", 32, 8, 8},
+		{"invalid cap", 0, 16, 1},
+	}
+	for _, tt := range tests {
+		var active, peak, conns int32
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cur := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+
+			for {
+				max := atomic.LoadInt32(&peak)
+				if cur <= max || atomic.CompareAndSwapInt32(&peak, max, cur) {
+					break
+				}
+			}
+			// Hold the connection long enough for the others to pile up
+			time.Sleep(20 * time.Millisecond)
+			fmt.Fprint(w, "ok")
+		}))
+		srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		srv.Start()
+
+		c := newConversion(Options{MaxHTTPConns: tt.conns, Quiet: true})
+
+		var pend sync.WaitGroup
+		pend.Add(tt.requests)
+		for i := 0; i < tt.requests; i++ {
+			go func() {
+				defer pend.Done()
+
+				res, err := c.httpGet(srv.URL)
+				if err != nil {
+					t.Errorf("%s: request failed: %v", tt.name, err)
+					return
+				}
+				io.Copy(ioutil.Discard, res.Body)
+				res.Body.Close()
+			}()
+		}
+		pend.Wait()
+		srv.Close()
+
+		if peak := atomic.LoadInt32(&peak); int(peak) != tt.want {
+			t.Errorf("%s: peak concurrency mismatch: have %d, want %d", tt.name, peak, tt.want)
+		}
+		if conns := atomic.LoadInt32(&conns); int(conns) > tt.want {
+			t.Errorf("%s: opened connections mismatch: have %d, want at most %d", tt.name, conns, tt.want)
+		}
+	}
+}
+
+// Tests that only transient failures (timeouts and gateway errors) are retried,
+// while permanent ones and aborted conversions fail right away.
+func TestHTTPRetries(t *testing.T) {
+	defer func(backoff time.Duration) { httpBackoff = backoff }(httpBackoff)
+	httpBackoff = time.Millisecond
+
+	tests := []struct {
+		name      string
+		responses []int // Status codes to serve in order, 0 for hanging past the timeout
+		cancelled bool  // Whether the conversion is already aborted
+		want      int   // Number of requests expected
+		status    int   // Final status expected, 0 for an error
+	}{
+		{"success", []int{200}, false, 1, 200},
+		{"not found", []int{404}, false, 1, 404},
+		{"internal error", []int{500}, false, 1, 500},
+		{"not implemented", []int{501}, false, 1, 501},
+		{"bad gateway", []int{502, 200}, false, 2, 200},
+		{"unavailable", []int{503, 503, 200}, false, 3, 200},
+		{"gateway timeout", []int{504}, false, 3, 504},
+		{"timeout", []int{0, 200}, false, 2, 200},
+		{"aborted", []int{503}, true, 0, 0},
+	}
+	for _, tt := range tests {
+		var served int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(atomic.AddInt32(&served, 1)) - 1
+			if n >= len(tt.responses) {
+				n = len(tt.responses) - 1
+			}
+			if tt.responses[n] == 0 {
+				time.Sleep(200 * time.Millisecond)
+				return
+			}
+			w.WriteHeader(tt.responses[n])
+		}))
+		c := newConversion(Options{HTTPRetries: 2, HTTPTimeout: 50 * time.Millisecond, Quiet: true})
+
+		if tt.cancelled {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			c.interrupt = ctx
+		}
+		res, err := c.httpGet(srv.URL)
+
+		switch {
+		case tt.status == 0 && err == nil:
+			t.Errorf("%s: request succeeded with %s", tt.name, res.Status)
+		case tt.status != 0 && err != nil:
+			t.Errorf("%s: request failed: %v", tt.name, err)
+		case err == nil && res.StatusCode != tt.status:
+			t.Errorf("%s: status mismatch: have %d, want %d", tt.name, res.StatusCode, tt.status)
+		}
+		if err == nil {
+			res.Body.Close()
+		}
+		srv.Close()
+
+		if have := int(atomic.LoadInt32(&served)); have != tt.want {
+			t.Errorf("%s: request count mismatch: have %d, want %d", tt.name, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// installDeps retrieves all the gx dependencies into the local vendor folder,
+// returning any changes gx did outside of it to report them separately from
+// ungx's own. The changes are returned even if the install failed.
+func (c *conversion) installDeps() ([]ReportChange, error) {
+	before, err := c.takeSnapshot(".", filepath.Join("vendor", "gx"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot working tree: %v", err)
+	}
+	existing, err := c.installedHashes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installed dependencies: %v", err)
+	}
+	ctx := c.interrupt
+	if c.config.InstallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.InstallTimeout)
+		defer cancel()
+	}
+	deps := exec.CommandContext(ctx, "gx", "install", "--local")
+	deps.Dir = c.projectDir()
+	deps.Stdout = c.commandOutput()
+	deps.Stderr = os.Stderr
+	if c.config.Quiet {
+		deps.Stdout = ioutil.Discard
+	}
+
+	// Run gx in its own process group so a timeout or interrupt takes down any
+	// helpers too
+	setProcessGroup(deps)
+	deps.Cancel = func() error { return killProcessGroup(deps) }
+	deps.WaitDelay = time.Second
+
+	c.logInfo("Vendoring in gx dependencies")
+	err = deps.Run()
+	if err != nil && ctx.Err() != nil {
+		if err := c.removeNewHashes(existing); err != nil {
+			c.logWarn("Failed to clean up partial gx install: %v", err)
+		}
+	}
+	// Collect the changes gx did, also if it failed midway
+	after, serr := c.takeSnapshot(".", filepath.Join("vendor", "gx"))
+	if serr != nil && err == nil {
+		return nil, fmt.Errorf("failed to snapshot working tree: %v", serr)
+	}
+	var changes []ReportChange
+	if serr == nil {
+		created, modified, deleted := before.diff(after)
+		for _, diff := range []struct {
+			change string
+			paths  []string
+		}{{"created", created}, {"modified", modified}, {"deleted", deleted}} {
+			for _, path := range diff.paths {
+				c.logWarn("Warning, gx install %s %s", diff.change, path)
+				changes = append(changes, ReportChange{File: filepath.ToSlash(path), Change: diff.change, Reason: "gx install --local"})
+			}
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			if err := c.interrupted(); err != nil {
+				return changes, err
+			}
+			return changes, fmt.Errorf("gx install timed out after %v", c.config.InstallTimeout)
+		}
+		return changes, fmt.Errorf("failed to vendor dependencies: %v", err)
+	}
+	return changes, nil
+}
+
+// installedHashes returns the set of gx hashes already present in the vendor
+// folder, used to tell apart the ones an interrupted install left behind.
+func (c *conversion) installedHashes() (map[string]bool, error) {
+	hashes := make(map[string]bool)
+
+	dirs, err := c.fsys.ReadDir(filepath.Join("vendor", "gx", "ipfs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return hashes, nil
+		}
+		return nil, err
+	}
+	for _, dir := range dirs {
+		hashes[dir.Name()] = true
+	}
+	return hashes, nil
+}
+
+// removeNewHashes deletes every gx hash from the vendor folder that was not
+// present before the install started, dropping any partially fetched package.
+func (c *conversion) removeNewHashes(existing map[string]bool) error {
+	dirs, err := c.fsys.ReadDir(filepath.Join("vendor", "gx", "ipfs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, dir := range dirs {
+		if existing[dir.Name()] {
+			continue
+		}
+		c.logInfo("Removing partially installed gx/ipfs/%s", dir.Name())
+		if err := c.fsys.RemoveAll(filepath.Join("vendor", "gx", "ipfs", dir.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that the files gx install changes outside of its vendor folder reach the
+// report, separately from ungx's own rewrites, even if the install fails.
+func TestInstallChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		fails  bool
+		want   []ReportChange
+	}{
+		{
+			name:   "clean install",
+			script: "exit 0\n",
+		},
+		{
+			name:   "dirtied tree",
+			script: "echo '{}' > package.json\necho lock > gx.lock\nrm notes.txt\n",
+			want: []ReportChange{
+				{File: "gx.lock", Change: "created", Reason: "gx install --local"},
+				{File: "package.json", Change: "modified", Reason: "gx install --local"},
+				{File: "notes.txt", Change: "deleted", Reason: "gx install --local"},
+			},
+		},
+		{
+			name:   "touched source",
+			script: "echo '// Generated by gx' >> util.go\n",
+			want:   []ReportChange{{File: "util.go", Change: "modified", Reason: "gx install --local"}},
+		},
+		{
+			name:   "failed install",
+			script: "echo lock > gx.lock\nexit 1\n",
+			fails:  true,
+			want:   []ReportChange{{File: "gx.lock", Change: "created", Reason: "gx install --local"}},
+		},
+	}
+	for _, tt := range tests {
+		fakeCommand(t, "gx", tt.script)
+
+		files := map[string]string{"package.json": `{"gxDependencies": []}`, "notes.txt": "notes\n", "util.go": "package main\n"}
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		opts := memOptions(t, nil, gxDecisions)
+		opts.FS = osFS{dir: diskProject(t, files)}
+
+		report, err := Convert(opts)
+		if (err != nil) != tt.fails {
+			t.Fatalf("%s: conversion error mismatch: have %v, want failure %v", tt.name, err, tt.fails)
+		}
+		if report == nil {
+			t.Fatalf("%s: no report returned", tt.name)
+		}
+		if !reflect.DeepEqual(report.Changed, tt.want) {
+			t.Errorf("%s: changes mismatch: have %+v, want %+v", tt.name, report.Changed, tt.want)
+		}
+		for _, file := range report.Rewritten {
+			if file == "package.json" || file == "gx.lock" {
+				t.Errorf("%s: gx change %s reported as rewritten", tt.name, file)
+			}
+		}
+	}
+}
+
+// Tests that a gx install hanging past the timeout is killed along with its
+// helpers, failing with a timeout error and dropping the partially fetched
+// packages, but keeping the ones installed before.
+func TestInstallTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		fails   bool
+	}{
+		{
+			name:    "finishes in time",
+			script:  "mkdir -p vendor/gx/ipfs/QmNew/bar\n",
+			timeout: 5 * time.Second,
+		},
+		{
+			name:    "no timeout",
+			script:  "mkdir -p vendor/gx/ipfs/QmNew/bar\n",
+			timeout: 0,
+		},
+		{
+			// The helper would leave a trace if it outlived the killed gx
+			name:    "hangs past timeout",
+			script:  "mkdir -p vendor/gx/ipfs/QmNew/bar\n(sleep 1; touch helper-alive) &\nsleep 30\n",
+			timeout: 200 * time.Millisecond,
+			fails:   true,
+		},
+	}
+	for _, tt := range tests {
+		fakeCommand(t, "gx", tt.script)
+
+		dir := diskProject(t, map[string]string{"vendor/gx/ipfs/QmFoo/foo/foo.go": "package foo\n"})
+		c := newConversion(Options{FS: osFS{dir: dir}, InstallTimeout: tt.timeout, Quiet: true})
+
+		start := time.Now()
+		_, err := c.installDeps()
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: install took too long: %v", tt.name, elapsed)
+		}
+		if (err != nil) != tt.fails {
+			t.Fatalf("%s: install error mismatch: have %v, want failure %v", tt.name, err, tt.fails)
+		}
+		if err != nil && !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("%s: unclear install error: %v", tt.name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "vendor/gx/ipfs/QmFoo/foo/foo.go")); err != nil {
+			t.Errorf("%s: previously installed package lost: %v", tt.name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "vendor/gx/ipfs/QmNew")); (err == nil) == tt.fails {
+			t.Errorf("%s: newly installed package presence mismatch: have %v, want %v", tt.name, err == nil, !tt.fails)
+		}
+		if tt.fails {
+			time.Sleep(1500 * time.Millisecond)
+			if _, err := os.Stat(filepath.Join(dir, "helper-alive")); err == nil {
+				t.Errorf("%s: gx helper survived the timeout", tt.name)
+			}
+		}
+	}
+}
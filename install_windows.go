@@ -0,0 +1,30 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"os"
+	"os/exec"
+)
+
+// processAlive returns whether a process with the given pid is running. On
+// Windows finding a process fails if it doesn't exist.
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	proc.Release()
+	return true
+}
+
+// setProcessGroup is a noop on Windows, process groups are not supported.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup terminates the started command. Windows has no process
+// groups, so only the gx process itself can be killed.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// markModified records that the project is being modified.
+func (c *conversion) markModified() {
+	atomic.StoreInt32(&c.modified, 1)
+}
+
+// interrupted returns an error if the conversion in progress was cancelled, nil
+// otherwise. It is checked between the steps of the conversion, so an abort
+// never leaves a package half moved or a file half written.
+func (c *conversion) interrupted() error {
+	if err := c.interrupt.Err(); err != nil {
+		return fmt.Errorf("conversion interrupted: %v", err)
+	}
+	return nil
+}
+
+// sleep waits for the given duration, returning early with an error if the
+// conversion in progress is cancelled in the meantime.
+func (c *conversion) sleep(wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.interrupt.Done():
+		return c.interrupted()
+	}
+}
+
+// warnPartial logs the steps to recover from an interruption if the project was
+// already being modified when the conversion was aborted.
+func (c *conversion) warnPartial() {
+	if atomic.LoadInt32(&c.modified) == 0 {
+		return
+	}
+	logError("Conversion interrupted midway, the project may be partially converted")
+	if c.config.UndoScript != "" {
+		logError("Run %s to revert the package moves done so far", c.config.UndoScript)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// licenseMarkers maps well known licenses to phrases that identify their texts.
+// All the phrases of a license need to be present for a match. The list is
+// ordered so that more specific licenses are checked before generic ones.
+var licenseMarkers = []struct {
+	name    string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"cc0 1.0 universal"}},
+}
+
+// detectLicense scans a package folder for license files and tries to identify
+// the license they contain. If no license file is found, "None" is returned; if
+// it's not recognized, "Unknown" is returned.
+func (c *conversion) detectLicense(dir string) (string, error) {
+	infos, err := c.fsys.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	found := false
+	for _, info := range infos {
+		name := strings.ToUpper(info.Name())
+		if info.IsDir() || !(strings.HasPrefix(name, "LICENSE") || strings.HasPrefix(name, "LICENCE") || strings.HasPrefix(name, "COPYING")) {
+			continue
+		}
+		found = true
+
+		blob, err := c.fsys.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return "", err
+		}
+		// Normalize the whitespace so line wrapping doesn't break the phrases
+		text := strings.ToLower(string(bytes.Join(bytes.Fields(blob), []byte(" "))))
+		for _, license := range licenseMarkers {
+			matched := true
+			for _, phrase := range license.phrases {
+				if !strings.Contains(text, phrase) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return license.name, nil
+			}
+		}
+	}
+	if !found {
+		return "None", nil
+	}
+	return "Unknown", nil
+}
+
+// writeLicenses detects the license of every converted dependency and writes a
+// report listing them along with a summary of the counts per license.
+func (c *conversion) writeLicenses(file string, pkgs map[string]string) error {
+	var (
+		paths  []string
+		counts = make(map[string]int)
+		report bytes.Buffer
+	)
+	for path := range pkgs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		license, err := c.detectLicense(pkgs[path])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&report, "%s\t%s\n", path, license)
+		counts[license]++
+	}
+	var licenses []string
+	for license := range counts {
+		licenses = append(licenses, license)
+	}
+	sort.Strings(licenses)
+
+	fmt.Fprintln(&report)
+	for _, license := range licenses {
+		fmt.Fprintf(&report, "%s: %d\n", license, counts[license])
+	}
+	return ioutil.WriteFile(file, report.Bytes(), 0644)
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that the well known licenses are recognized from their texts, even if
+// wrapped differently or placed in differently named files.
+func TestDetectLicense(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string // License files within the package folder
+		want  string
+	}{
+		{
+			name:  "mit",
+			files: map[string]string{"LICENSE": "MIT License\n\nPermission is hereby granted, free of charge, to any person obtaining a copy\n"},
+			want:  "MIT",
+		},
+		{
+			name:  "rewrapped mit",
+			files: map[string]string{"LICENSE": "Permission is hereby\ngranted,   free of\n\tcharge\n"},
+			want:  "MIT",
+		},
+		{
+			name:  "apache",
+			files: map[string]string{"LICENSE.txt": "                 Apache License\n           Version 2.0, January 2004\n"},
+			want:  "Apache-2.0",
+		},
+		{
+			name:  "bsd 3 clause",
+			files: map[string]string{"LICENSE": "Redistribution and use in source and binary forms, with or without\nmodification, are permitted...\nNeither the name of the copyright holder\n"},
+			want:  "BSD-3-Clause",
+		},
+		{
+			name:  "bsd 2 clause",
+			files: map[string]string{"LICENSE": "Redistribution and use in source and binary forms, with or without\nmodification, are permitted...\n"},
+			want:  "BSD-2-Clause",
+		},
+		{
+			name:  "lesser gpl before gpl",
+			files: map[string]string{"COPYING": "GNU LESSER GENERAL PUBLIC LICENSE\nVersion 3, 29 June 2007\n\nThis version of the GNU Lesser General Public License incorporates\nthe terms of version 3 of the GNU General Public License\n"},
+			want:  "LGPL-3.0",
+		},
+		{
+			name:  "british spelling",
+			files: map[string]string{"licence.md": "This is free and unencumbered software released into the public domain.\n"},
+			want:  "Unlicense",
+		},
+		{
+			name:  "unrecognized",
+			files: map[string]string{"LICENSE": "All rights reserved.\n"},
+			want:  "Unknown",
+		},
+		{
+			name:  "missing",
+			files: map[string]string{"README": "Permission is hereby granted, free of charge\n"},
+			want:  "None",
+		},
+	}
+	for _, tt := range tests {
+		files := map[string]string{"pkg/pkg.go": "package pkg\n"}
+		for name, content := range tt.files {
+			files["pkg/"+name] = content
+		}
+		c := newConversion(Options{FS: memProject(t, files), Quiet: true})
+
+		have, err := c.detectLicense("pkg")
+		if err != nil {
+			t.Errorf("%s: failed to detect license: %v", tt.name, err)
+			continue
+		}
+		if have != tt.want {
+			t.Errorf("%s: license mismatch: have %s, want %s", tt.name, have, tt.want)
+		}
+	}
+}
+
+// Tests that the license report lists every converted dependency sorted by path,
+// followed by the counts per license.
+func TestConvertLicenses(t *testing.T) {
+	files := map[string]string{
+		"vendor/gx/ipfs/QmFoo/foo/LICENSE": "Permission is hereby granted, free of charge, to any person\n",
+		"vendor/gx/ipfs/QmBar/bar/LICENSE": "Apache License\nVersion 2.0, January 2004\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	opts := memOptions(t, memProject(t, files), gxDecisions)
+	opts.Licenses = filepath.Join(t.TempDir(), "licenses.txt")
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	blob, err := ioutil.ReadFile(opts.Licenses)
+	if err != nil {
+		t.Fatalf("failed to read license report: %v", err)
+	}
+	want := "github.com/a/foo\tMIT\ngithub.com/b/bar\tApache-2.0\n\nApache-2.0: 1\nMIT: 1\n"
+	if string(blob) != want {
+		t.Errorf("license report mismatch:\nhave:\n%s\nwant:\n%s", blob, want)
+	}
+}
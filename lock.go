@@ -0,0 +1,50 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lockFile is the name of the file guarding a project against being converted
+// by multiple ungx processes at the same time.
+const lockFile = ".ungx.lock"
+
+// acquireLock creates the lock file of the project in the current directory,
+// recording the pid of the process holding it, and returns a function to release
+// it. If the lock is held by a process that's not running any more (e.g. one that
+// was killed midway), it's considered stale and is taken over.
+func (c *conversion) acquireLock() (func(), error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		err := c.fsys.CreateFile(lockFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+		if err == nil {
+			return func() { c.fsys.Remove(lockFile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		// Someone else holds the lock, bail out unless they are gone
+		blob, err := c.fsys.ReadFile(lockFile)
+		if err != nil {
+			return nil, err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(blob)))
+		if err != nil {
+			return nil, fmt.Errorf("corrupt lock file %s, remove it if no other conversion is running", lockFile)
+		}
+		if processAlive(pid) {
+			return nil, fmt.Errorf("another conversion (pid %d) is in progress, holding %s", pid, lockFile)
+		}
+		c.logInfo("Removing stale lock of exited process %d", pid)
+		if err := c.fsys.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, errors.New("lock contended by concurrent conversions")
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests that a conversion is rejected while another live process holds the
+// project lock, but a stale lock of an exited process is taken over.
+func TestConvertLock(t *testing.T) {
+	// Find the pid of a process that surely exited already
+	cmd := exec.Command("go", "version")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run short lived process: %v", err)
+	}
+	dead := cmd.Process.Pid
+
+	tests := []struct {
+		name   string
+		lock   string // Content of the lock file before converting
+		dryrun bool
+		fail   string // Expected error fragment, empty for success
+	}{
+		{"live holder", fmt.Sprintf("%d\n", os.Getpid()), false, "another conversion"},
+		{"stale holder", fmt.Sprintf("%d\n", dead), false, ""},
+		{"corrupt lock", "garbage\n", false, "corrupt lock file"},
+		{"dry run with live holder", fmt.Sprintf("%d\n", os.Getpid()), true, ""},
+	}
+	for _, tt := range tests {
+		files := map[string]string{lockFile: tt.lock}
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		mem := memProject(t, files)
+		opts := memOptions(t, mem, gxDecisions)
+		opts.DryRun = tt.dryrun
+
+		_, err := Convert(opts)
+		switch {
+		case tt.fail != "":
+			if err == nil || !strings.Contains(err.Error(), tt.fail) {
+				t.Errorf("%s: error mismatch: have %v, want %q", tt.name, err, tt.fail)
+			}
+			// The rejected run must leave the tree, lock included, alone
+			if have := fsFiles(t, mem); !reflect.DeepEqual(have, files) {
+				t.Errorf("%s: tree modified by rejected conversion", tt.name)
+			}
+		case err != nil:
+			t.Errorf("%s: failed to convert: %v", tt.name, err)
+		case tt.dryrun:
+			if blob, _ := mem.ReadFile(lockFile); string(blob) != tt.lock {
+				t.Errorf("%s: lock modified: have %q, want %q", tt.name, blob, tt.lock)
+			}
+		default:
+			checkConverted(t, mem)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// logDebug logs the fine grained details of the conversion (e.g. every single
+// file rewritten), which are only shown in verbose mode.
+func (c *conversion) logDebug(format string, args ...interface{}) {
+	if c.config.Verbose && !c.config.Quiet {
+		log.Printf(format, args...)
+	}
+}
+
+// logInfo logs the progress of the conversion at the granularity of packages,
+// which is shown unless in quiet mode.
+func (c *conversion) logInfo(format string, args ...interface{}) {
+	if !c.config.Quiet {
+		log.Printf(format, args...)
+	}
+}
+
+// logWarn logs a recoverable problem the conversion worked around, which is
+// shown unless in quiet mode.
+func (c *conversion) logWarn(format string, args ...interface{}) {
+	if !c.config.Quiet {
+		log.Printf(format, args...)
+	}
+}
+
+// logError logs a failure of the conversion, which is always shown.
+func logError(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// commandOutput returns the writer the standard output of child processes (e.g.
+// gx, go get, hooks) is forwarded to, which is stderr if stdout is reserved for
+// the JSON summary.
+func (c *conversion) commandOutput() io.Writer {
+	if c.config.SummaryJSON {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -0,0 +1,178 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestFile is the name of the manifest written into the project root after
+// a successful conversion.
+const manifestFile = "ungx.manifest.json"
+
+// manifest is a machine readable record of every decision of a conversion, to
+// be diffed between runs to detect dependency resolution changes.
+type manifest struct {
+	Root     string            `json:"root"`     // Import path of the converted project
+	Packages []manifestPackage `json:"packages"` // Outcome of each gx dependency
+	Versions map[string]int    `json:"versions"` // Number of gx versions of each canonical path
+	Rewrites map[string]string `json:"rewrites"` // Import path rewrite rules applied
+}
+
+// manifestPackage is the conversion record of a single gx dependency.
+type manifestPackage struct {
+	Hash       string `json:"hash"`               // Hash the dependency was installed under
+	Path       string `json:"path"`               // Canonical import path of the dependency
+	Dvcsimport string `json:"dvcsimport"`         // Normalized dvcsimport of the package definition
+	Release    string `json:"release,omitempty"`  // Release version of the package, if known
+	Action     string `json:"action"`             // Either "embed", "vendor", "require", "dedupe" or "skip"
+	Location   string `json:"location,omitempty"` // Final on-disk location, if moved
+}
+
+// writeManifest assembles the manifest of a conversion and writes it into the
+// project root.
+func (c *conversion) writeManifest(summary *Report, dvcsimports map[string]string, releases map[string]string, versions map[string]int, rewrites map[string]string) error {
+	m := &manifest{
+		Root:     summary.Root,
+		Versions: versions,
+		Rewrites: rewrites,
+	}
+	for _, pkg := range summary.Packages {
+		m.Packages = append(m.Packages, manifestPackage{
+			Hash:       pkg.Hash,
+			Path:       pkg.Path,
+			Dvcsimport: dvcsimports[pkg.Hash],
+			Release:    releases[pkg.Hash],
+			Action:     pkg.Action,
+			Location:   filepath.ToSlash(pkg.Target),
+		})
+	}
+	sort.Slice(m.Packages, func(i, j int) bool { return m.Packages[i].Hash < m.Packages[j].Hash })
+
+	blob, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.fsys.WriteFile(manifestFile, append(blob, '\n'), 0644)
+}
+
+// loadManifest reads the manifest of a previous conversion.
+func (c *conversion) loadManifest(file string) (*manifest, error) {
+	blob, err := c.fsys.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := new(manifest)
+	if err := json.Unmarshal(blob, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// converted returns whether the previous conversion recorded in a manifest is
+// still complete, i.e. every direct gx dependency of the project was converted
+// and is still in place on the given file system. Skipped packages are never considered complete, since
+// a rerun (e.g. of another phase) might convert them.
+func (m *manifest) converted(fs FS) bool {
+	blob, err := fs.ReadFile("package.json")
+	if err != nil {
+		return false
+	}
+	var spec struct {
+		Deps []struct {
+			Hash string `json:"hash"`
+		} `json:"gxDependencies"`
+	}
+	if err := json.Unmarshal(blob, &spec); err != nil {
+		return false
+	}
+	recorded := make(map[string]bool)
+	for _, pkg := range m.Packages {
+		if pkg.Action == "skip" {
+			return false
+		}
+		if pkg.Location != "" {
+			if _, err := fs.Stat(filepath.FromSlash(pkg.Location)); err != nil {
+				return false
+			}
+		}
+		recorded[pkg.Hash] = true
+	}
+	for _, dep := range spec.Deps {
+		if !recorded[dep.Hash] {
+			return false
+		}
+	}
+	return true
+}
+
+// reclassified returns whether any package converted by a previous run would
+// now be classified differently, i.e. embedded instead of vendored or the other
+// way around. Packages embedded due to clashing versions are never reclassified.
+// Decisions missing from the cache of a hermetic conversion count as changed, so
+// the full conversion runs and reports them.
+func (c *conversion) reclassified(m *manifest, workspace string, embeds map[string]bool) bool {
+	for _, pkg := range m.Packages {
+		if (pkg.Action != "embed" && pkg.Action != "vendor") || m.Versions[pkg.Path] > 1 {
+			continue
+		}
+		embedded := embeds[pkg.Path]
+		if !embedded {
+			ref := releaseRef(pkg.Release)
+			if c.config.RequireOfflineDecisions {
+				if _, ok := c.embedDecisions.cached(c.decisionKey(pkg.Path, ref)); !ok {
+					return true
+				}
+			}
+			embedded = c.shouldEmbed(workspace, pkg.Path, ref)
+		}
+		if embedded != (pkg.Action == "embed") {
+			return true
+		}
+	}
+	return false
+}
+
+// moved returns whether the manifest records a gx dependency as converted into a
+// destination (or a folder within it). It is safe to call on a nil manifest.
+func (m *manifest) moved(hash string, dst string) bool {
+	if m == nil {
+		return false
+	}
+	dst = filepath.ToSlash(dst)
+	for _, pkg := range m.Packages {
+		if pkg.Hash != hash || pkg.Location == "" || (pkg.Action != "embed" && pkg.Action != "vendor") {
+			continue
+		}
+		if dst == pkg.Location || strings.HasPrefix(dst, pkg.Location+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRewrites reads the import path rewrite rules of a previous conversion,
+// either from its manifest or report, or from a plain JSON object mapping old
+// import paths to new ones.
+func loadRewrites(file string) (map[string]string, error) {
+	blob, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(blob, &m); err == nil && m.Rewrites != nil {
+		return m.Rewrites, nil
+	}
+	var rules map[string]string
+	if err := json.Unmarshal(blob, &rules); err != nil {
+		return nil, fmt.Errorf("neither a manifest nor a rewrite mapping: %v", err)
+	}
+	return rules, nil
+}
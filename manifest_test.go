@@ -0,0 +1,219 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests that converting an already converted package is a no-op, which neither
+// reinstalls the gx dependencies nor touches any file of the first run.
+func TestConvertTwice(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{"gopath mode", "gopath"},
+		{"modules mode", "modules"},
+	}
+	for _, tt := range tests {
+		// Create a fake gx recording its invocations instead of fetching anything
+		calls := filepath.Join(t.TempDir(), "calls")
+		fakeCommand(t, "gx", "echo \"$@\" >> "+calls+"\n")
+
+		// Create a project with its gx dependencies already installed
+		files := map[string]string{"package.json": `{"gxDependencies": [{"hash": "QmFoo", "name": "foo"}, {"hash": "QmBar", "name": "bar"}]}`}
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		dir := diskProject(t, files)
+
+		opts := memOptions(t, nil, gxDecisions)
+		opts.FS = osFS{dir: dir}
+		opts.Mode = tt.mode
+
+		first, err := Convert(opts)
+		if err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		converted := fsFiles(t, opts.FS)
+		if _, ok := converted[manifestFile]; !ok {
+			t.Fatalf("%s: manifest missing after conversion", tt.name)
+		}
+		second, err := Convert(opts)
+		if err != nil {
+			t.Fatalf("%s: failed to reconvert package: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(first.Rewrites, second.Rewrites) {
+			t.Errorf("%s: rewrite rules mismatch: have %v, want %v", tt.name, second.Rewrites, first.Rewrites)
+		}
+		if len(second.Packages) != 0 || len(second.Rewritten) != 0 {
+			t.Errorf("%s: second conversion did something: %d packages, %d files", tt.name, len(second.Packages), len(second.Rewritten))
+		}
+		if reconverted := fsFiles(t, opts.FS); !reflect.DeepEqual(reconverted, converted) {
+			t.Errorf("%s: files changed by second conversion:\nhave %v\nwant %v", tt.name, reconverted, converted)
+		}
+		if blob, _ := ioutil.ReadFile(calls); string(blob) != "install --local\n" {
+			t.Errorf("%s: gx invocations mismatch: have %q, want one install", tt.name, blob)
+		}
+	}
+}
+
+// Tests that a package whose classification changed since the previous run is
+// relocated to its new folder if requested, with the imports following it, and
+// that it's left where it was otherwise.
+func TestConvertReclassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		decisions string // Decisions cached for the second run
+		relocate  bool
+		present   string // File expected after the second run
+		absent    string // Folder expected gone after the second run
+		imported  string // Import expected in main.go after the second run
+	}{
+		{
+			name:      "vendored now embedded",
+			decisions: `{"github.com/a/foo@v1.0.0": true, "github.com/b/bar": true}`,
+			relocate:  true,
+			present:   "gxlibs/github.com/b/bar/bar.go",
+			absent:    "vendor/github.com/b/bar",
+			imported:  `"example.com/proj/gxlibs/github.com/b/bar"`,
+		},
+		{
+			name:      "embedded now vendored",
+			decisions: `{"github.com/a/foo@v1.0.0": false, "github.com/b/bar": false}`,
+			relocate:  true,
+			present:   "vendor/github.com/a/foo/foo.go",
+			absent:    "gxlibs/github.com/a/foo",
+			imported:  `"github.com/a/foo"`,
+		},
+		{
+			name:      "relocation disabled",
+			decisions: `{"github.com/a/foo@v1.0.0": true, "github.com/b/bar": true}`,
+			present:   "vendor/github.com/b/bar/bar.go",
+			absent:    "gxlibs/github.com/b/bar",
+			imported:  `"github.com/b/bar"`,
+		},
+	}
+	for _, tt := range tests {
+		// Create a fake gx reinstalling the gx copies on every run
+		files := map[string]string{"package.json": `{"gxDependencies": [{"hash": "QmFoo", "name": "foo"}, {"hash": "QmBar", "name": "bar"}]}`}
+		stash := make(map[string]string)
+		for path, content := range gxProject {
+			files[path] = content
+			if strings.HasPrefix(path, "vendor/gx/") {
+				stash[strings.TrimPrefix(path, "vendor/gx/")] = content
+			}
+		}
+		fakeCommand(t, "gx", "mkdir -p vendor/gx && cp -R "+filepath.Join(diskProject(t, stash), "ipfs")+" vendor/gx/\n")
+
+		dir := diskProject(t, files)
+
+		opts := memOptions(t, nil, gxDecisions)
+		opts.FS = osFS{dir: dir}
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		opts = memOptions(t, nil, tt.decisions)
+		opts.FS = osFS{dir: dir}
+		opts.RelocateReclassified = tt.relocate
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to reconvert package: %v", tt.name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, tt.present)); err != nil {
+			t.Errorf("%s: missing %s after reconversion: %v", tt.name, tt.present, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, tt.absent)); err == nil {
+			t.Errorf("%s: %s left behind after reconversion", tt.name, tt.absent)
+		}
+		for _, hash := range []string{"QmFoo", "QmBar"} {
+			if _, err := os.Stat(filepath.Join(dir, "vendor", "gx", "ipfs", hash)); err == nil {
+				t.Errorf("%s: gx copy %s left behind after reconversion", tt.name, hash)
+			}
+		}
+		if blob, _ := ioutil.ReadFile(filepath.Join(dir, "main.go")); !strings.Contains(string(blob), tt.imported) {
+			t.Errorf("%s: main.go import %s missing:\n%s", tt.name, tt.imported, blob)
+		}
+		// A third run with the same classification must not change anything
+		converted := fsFiles(t, opts.FS)
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert package a third time: %v", tt.name, err)
+		}
+		if have := fsFiles(t, opts.FS); !reflect.DeepEqual(have, converted) {
+			t.Errorf("%s: files changed by third conversion", tt.name)
+		}
+	}
+}
+
+// Tests that only rewriting reapplies the rules of a previous conversion, saved
+// either in its manifest or as a plain mapping, without touching dependencies.
+func TestConvertOnlyRewrite(t *testing.T) {
+	// Convert a project once to obtain a genuine manifest
+	first := memProject(t, gxProject)
+	if _, err := Convert(memOptions(t, first, gxDecisions)); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	manifest, err := first.ReadFile(manifestFile)
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	tests := []struct {
+		name  string
+		rules string // Content of the saved rewrite rules
+		fail  bool
+	}{
+		{"manifest", string(manifest), false},
+		{"plain mapping", `{"gx/ipfs/QmFoo/foo": "example.com/proj/gxlibs/github.com/a/foo", "gx/ipfs/QmBar/bar": "github.com/b/bar"}`, false},
+		{"invalid mapping", `["gx/ipfs/QmFoo/foo"]`, true},
+	}
+	for _, tt := range tests {
+		// Reintroduce some gx imports into an already converted tree
+		files := map[string]string{
+			"gxlibs/github.com/a/foo/foo.go": "package foo\n\nfunc Foo() {}\n",
+			"vendor/github.com/b/bar/bar.go": "package bar\n\nfunc Bar() {}\n",
+			"main.go":                        gxProject["main.go"],
+			"sub/sub.go":                     "package sub\n\nimport \"gx/ipfs/QmFoo/foo/inner\"\n\nvar _ = inner.Inner\n",
+		}
+		mem := memProject(t, files)
+
+		rules := filepath.Join(t.TempDir(), "rules.json")
+		if err := ioutil.WriteFile(rules, []byte(tt.rules), 0644); err != nil {
+			t.Fatalf("%s: failed to save rules: %v", tt.name, err)
+		}
+		opts := memOptions(t, mem, "{}")
+		opts.OnlyRewrite = rules
+
+		report, err := Convert(opts)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: invalid rules accepted", tt.name)
+			}
+			if have := fsFiles(t, mem); !reflect.DeepEqual(have, files) {
+				t.Errorf("%s: tree modified by failed rewrite", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to rewrite: %v", tt.name, err)
+		}
+		want := map[string]string{
+			"gxlibs/github.com/a/foo/foo.go": files["gxlibs/github.com/a/foo/foo.go"],
+			"vendor/github.com/b/bar/bar.go": files["vendor/github.com/b/bar/bar.go"],
+			"main.go":                        "package main\n\nimport (\n\t\"example.com/proj/gxlibs/github.com/a/foo\"\n\t\"github.com/b/bar\"\n)\n\nfunc main() { foo.Foo(); bar.Bar() }\n",
+			"sub/sub.go":                     "package sub\n\nimport \"example.com/proj/gxlibs/github.com/a/foo/inner\"\n\nvar _ = inner.Inner\n",
+		}
+		if have := fsFiles(t, mem); !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: rewritten tree mismatch:\nhave %q\nwant %q", tt.name, have, want)
+		}
+		if len(report.Rewritten) != 2 {
+			t.Errorf("%s: rewritten file count mismatch: have %d, want 2", tt.name, len(report.Rewritten))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// writeMappingCSV writes the hash to canonical path mapping of all the gx
+// dependencies into a CSV file, along with the number of versions of each path
+// and the action taken. Rows are sorted by path.
+func writeMappingCSV(file string, pkgs []ReportPackage, versions map[string]int) error {
+	sorted := append([]ReportPackage{}, pkgs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Path != sorted[j].Path {
+			return sorted[i].Path < sorted[j].Path
+		}
+		return sorted[i].Hash < sorted[j].Hash
+	})
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"hash", "path", "versions", "action"})
+	for _, pkg := range sorted {
+		w.Write([]string{pkg.Hash, pkg.Path, strconv.Itoa(versions[pkg.Path]), pkg.Action})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return out.Close()
+}
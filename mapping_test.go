@@ -0,0 +1,54 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests that dumping the dependency mapping writes a CSV file with a header and
+// one row per gx package, sorted by path and then hash.
+func TestConvertDumpMapping(t *testing.T) {
+	files := map[string]string{
+		"old.go": "package main\n\nimport \"gx/ipfs/QmAfoo/foo\"\n\nvar _ = foo.Foo\n",
+
+		"vendor/gx/ipfs/QmAfoo/foo/package.json":  `{"name": "foo", "version": "0.9.0", "gx": {"dvcsimport": "github.com/a/foo"}}`,
+		"vendor/gx/ipfs/QmAfoo/foo/foo.go":        "package foo\n\nfunc Foo() {}\n",
+		"vendor/gx/ipfs/QmMeta/meta/package.json": `{"name": "meta", "gx": {"dvcsimport": "github.com/0/meta"}}`,
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	opts := memOptions(t, memProject(t, files), `{"github.com/a/foo@v1.0.0": true, "github.com/a/foo@v0.9.0": true, "github.com/b/bar": false}`)
+	opts.DumpMapping = filepath.Join(t.TempDir(), "mapping.csv")
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert: %v", err)
+	}
+	out, err := os.Open(opts.DumpMapping)
+	if err != nil {
+		t.Fatalf("failed to open mapping: %v", err)
+	}
+	defer out.Close()
+
+	have, err := csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse mapping: %v", err)
+	}
+	want := [][]string{
+		{"hash", "path", "versions", "action"},
+		{"QmMeta", "github.com/0/meta", "0", "skip"},
+		{"QmAfoo", "github.com/a/foo", "2", "embed"},
+		{"QmFoo", "github.com/a/foo", "2", "embed"},
+		{"QmBar", "github.com/b/bar", "1", "vendor"},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("mapping mismatch:\nhave %q\nwant %q", have, want)
+	}
+}
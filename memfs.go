@@ -0,0 +1,321 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory FS, allowing a conversion to be exercised (e.g. in tests
+// or to preview it) without touching the disk. Paths are interpreted relative to
+// the root of the file system, which starts out as an empty folder.
+type MemFS struct {
+	nodes map[string]*memNode // Files, folders and symlinks keyed by clean slash path
+	lock  sync.RWMutex
+}
+
+// memNode is a single file, folder or symlink within an in-memory file system.
+type memNode struct {
+	mode os.FileMode // Type and permission bits of the node
+	data []byte      // Contents of a file, or the target of a symlink
+	time time.Time   // Last modification time of the node
+}
+
+// NewMemFS creates an empty in-memory file system.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		nodes: map[string]*memNode{".": {mode: os.ModeDir | 0755, time: time.Now()}},
+	}
+}
+
+// memPath converts an OS path into the key of its node.
+func memPath(fp string) string {
+	fp = strings.TrimPrefix(path.Clean(filepath.ToSlash(fp)), "/")
+	if fp == "" {
+		return "."
+	}
+	return fp
+}
+
+// memError wraps an error of an in-memory file system operation the same way
+// the os package does, so os.IsNotExist and friends keep working.
+func memError(op string, fp string, err error) error {
+	return &os.PathError{Op: op, Path: fp, Err: err}
+}
+
+// resolve follows the symlinks of a node until reaching a file or folder. The
+// lock needs to be held by the caller.
+func (fs *MemFS) resolve(key string) (string, *memNode, bool) {
+	for hops := 0; hops < 16; hops++ {
+		node, ok := fs.nodes[key]
+		if !ok || node.mode&os.ModeSymlink == 0 {
+			return key, node, ok
+		}
+		target := string(node.data)
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(key), target)
+		}
+		key = memPath(target)
+	}
+	return key, nil, false
+}
+
+// parentDir checks that the parent of a node exists and is a folder. The lock
+// needs to be held by the caller.
+func (fs *MemFS) parentDir(op string, fp string, key string) error {
+	if _, parent, ok := fs.resolve(path.Dir(key)); !ok || !parent.mode.IsDir() {
+		return memError(op, fp, os.ErrNotExist)
+	}
+	return nil
+}
+
+// children returns the keys of the direct descendants of a folder, sorted by
+// name. The lock needs to be held by the caller.
+func (fs *MemFS) children(key string) []string {
+	var keys []string
+	for child := range fs.nodes {
+		if child != "." && child != key && path.Dir(child) == key {
+			keys = append(keys, child)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// descendant returns whether a key is within the subtree of another one.
+func descendant(key string, root string) bool {
+	return key == root || root == "." || strings.HasPrefix(key, root+"/")
+}
+
+func (fs *MemFS) Stat(fp string) (os.FileInfo, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	_, node, ok := fs.resolve(memPath(fp))
+	if !ok {
+		return nil, memError("stat", fp, os.ErrNotExist)
+	}
+	return &memInfo{name: path.Base(memPath(fp)), node: node}, nil
+}
+
+func (fs *MemFS) ReadDir(fp string) ([]os.FileInfo, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	key, node, ok := fs.resolve(memPath(fp))
+	if !ok {
+		return nil, memError("open", fp, os.ErrNotExist)
+	}
+	if !node.mode.IsDir() {
+		return nil, memError("readdirent", fp, errors.New("not a directory"))
+	}
+	var infos []os.FileInfo
+	for _, child := range fs.children(key) {
+		infos = append(infos, &memInfo{name: path.Base(child), node: fs.nodes[child]})
+	}
+	return infos, nil
+}
+
+func (fs *MemFS) ReadFile(fp string) ([]byte, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	_, node, ok := fs.resolve(memPath(fp))
+	if !ok {
+		return nil, memError("open", fp, os.ErrNotExist)
+	}
+	if node.mode.IsDir() {
+		return nil, memError("read", fp, errors.New("is a directory"))
+	}
+	return append([]byte{}, node.data...), nil
+}
+
+func (fs *MemFS) WriteFile(fp string, data []byte, perm os.FileMode) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	key, node, ok := fs.resolve(memPath(fp))
+	if ok && node.mode.IsDir() {
+		return memError("open", fp, errors.New("is a directory"))
+	}
+	if err := fs.parentDir("open", fp, key); err != nil {
+		return err
+	}
+	fs.nodes[key] = &memNode{mode: perm.Perm(), data: append([]byte{}, data...), time: time.Now()}
+	return nil
+}
+
+func (fs *MemFS) CreateFile(fp string, data []byte, perm os.FileMode) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	key := memPath(fp)
+	if _, ok := fs.nodes[key]; ok {
+		return memError("open", fp, os.ErrExist)
+	}
+	if err := fs.parentDir("open", fp, key); err != nil {
+		return err
+	}
+	fs.nodes[key] = &memNode{mode: perm.Perm(), data: append([]byte{}, data...), time: time.Now()}
+	return nil
+}
+
+func (fs *MemFS) Readlink(fp string) (string, error) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	node, ok := fs.nodes[memPath(fp)]
+	if !ok {
+		return "", memError("readlink", fp, os.ErrNotExist)
+	}
+	if node.mode&os.ModeSymlink == 0 {
+		return "", memError("readlink", fp, errors.New("invalid argument"))
+	}
+	return string(node.data), nil
+}
+
+func (fs *MemFS) Symlink(target, fp string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	key := memPath(fp)
+	if _, ok := fs.nodes[key]; ok {
+		return memError("symlink", fp, os.ErrExist)
+	}
+	if err := fs.parentDir("symlink", fp, key); err != nil {
+		return err
+	}
+	fs.nodes[key] = &memNode{mode: os.ModeSymlink | 0777, data: []byte(target), time: time.Now()}
+	return nil
+}
+
+func (fs *MemFS) MkdirAll(fp string, perm os.FileMode) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	key := "."
+	for _, part := range strings.Split(memPath(fp), "/") {
+		key = path.Join(key, part)
+
+		resolved, node, ok := fs.resolve(key)
+		if !ok {
+			fs.nodes[resolved] = &memNode{mode: os.ModeDir | perm.Perm(), time: time.Now()}
+			continue
+		}
+		if !node.mode.IsDir() {
+			return memError("mkdir", fp, errors.New("not a directory"))
+		}
+	}
+	return nil
+}
+
+func (fs *MemFS) Rename(src, dst string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	skey, dkey := memPath(src), memPath(dst)
+	if _, ok := fs.nodes[skey]; !ok {
+		return memError("rename", src, os.ErrNotExist)
+	}
+	if err := fs.parentDir("rename", dst, dkey); err != nil {
+		return err
+	}
+	if node, ok := fs.nodes[dkey]; ok && node.mode.IsDir() && len(fs.children(dkey)) > 0 {
+		return memError("rename", dst, errors.New("directory not empty"))
+	}
+	if descendant(dkey, skey) && dkey != skey {
+		return memError("rename", dst, errors.New("invalid argument"))
+	}
+	for key, node := range fs.nodes {
+		if descendant(key, skey) {
+			delete(fs.nodes, key)
+			fs.nodes[dkey+strings.TrimPrefix(key, skey)] = node
+		}
+	}
+	return nil
+}
+
+func (fs *MemFS) Remove(fp string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	key := memPath(fp)
+	if _, ok := fs.nodes[key]; !ok {
+		return memError("remove", fp, os.ErrNotExist)
+	}
+	if len(fs.children(key)) > 0 {
+		return memError("remove", fp, errors.New("directory not empty"))
+	}
+	delete(fs.nodes, key)
+	return nil
+}
+
+func (fs *MemFS) RemoveAll(fp string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	root := memPath(fp)
+	for key := range fs.nodes {
+		if key != "." && descendant(key, root) {
+			delete(fs.nodes, key)
+		}
+	}
+	return nil
+}
+
+func (fs *MemFS) Walk(root string, fn filepath.WalkFunc) error {
+	fs.lock.RLock()
+	node, ok := fs.nodes[memPath(root)]
+	fs.lock.RUnlock()
+
+	if !ok {
+		return fn(root, nil, memError("lstat", root, os.ErrNotExist))
+	}
+	err := fs.walk(root, &memInfo{name: path.Base(memPath(root)), node: node}, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walk recursively descends into a folder, calling fn for each node the same
+// way filepath.Walk does.
+func (fs *MemFS) walk(fp string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(fp, info, nil)
+	}
+	infos, err := fs.ReadDir(fp)
+	if err = fn(fp, info, err); err != nil || infos == nil {
+		return err
+	}
+	for _, child := range infos {
+		if err := fs.walk(filepath.Join(fp, child.Name()), child, fn); err != nil {
+			if !child.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// memInfo is the os.FileInfo of an in-memory file system node.
+type memInfo struct {
+	name string
+	node *memNode
+}
+
+func (fi *memInfo) Name() string       { return fi.name }
+func (fi *memInfo) Size() int64        { return int64(len(fi.node.data)) }
+func (fi *memInfo) Mode() os.FileMode  { return fi.node.mode }
+func (fi *memInfo) ModTime() time.Time { return fi.node.time }
+func (fi *memInfo) IsDir() bool        { return fi.node.mode.IsDir() }
+func (fi *memInfo) Sys() interface{}   { return nil }
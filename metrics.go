@@ -0,0 +1,48 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// writeMetrics writes the statistics of a conversion run into a Prometheus
+// textfile, to be picked up by a node exporter's textfile collector.
+func (c *conversion) writeMetrics(path string, summary *Report, duration time.Duration) error {
+	actions := map[string]int{"embed": 0, "vendor": 0, "require": 0, "skip": 0}
+	for _, pkg := range summary.Packages {
+		actions[pkg.Action]++
+	}
+	names := make([]string, 0, len(actions))
+	for action := range actions {
+		names = append(names, action)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "# HELP ungx_packages Number of gx dependencies by conversion action.\n")
+	fmt.Fprintf(&out, "# TYPE ungx_packages gauge\n")
+	for _, action := range names {
+		fmt.Fprintf(&out, "ungx_packages{action=%q} %d\n", action, actions[action])
+	}
+	fmt.Fprintf(&out, "# HELP ungx_files_rewritten Number of files with rewritten imports.\n")
+	fmt.Fprintf(&out, "# TYPE ungx_files_rewritten gauge\n")
+	fmt.Fprintf(&out, "ungx_files_rewritten %d\n", len(summary.Rewritten))
+
+	fmt.Fprintf(&out, "# HELP ungx_network_probes Number of embed decisions needing network access.\n")
+	fmt.Fprintf(&out, "# TYPE ungx_network_probes gauge\n")
+	fmt.Fprintf(&out, "ungx_network_probes %d\n", atomic.LoadInt64(&c.networkProbes))
+
+	fmt.Fprintf(&out, "# HELP ungx_duration_seconds Wall clock duration of the conversion.\n")
+	fmt.Fprintf(&out, "# TYPE ungx_duration_seconds gauge\n")
+	fmt.Fprintf(&out, "ungx_duration_seconds %g\n", duration.Seconds())
+
+	return writeFileAtomic(path, out.Bytes(), 0644)
+}
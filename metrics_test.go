@@ -0,0 +1,75 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// metricSample matches a single sample line of the Prometheus text format.
+var metricSample = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="[^"]*"(,[a-zA-Z_][a-zA-Z0-9_]*="[^"]*")*\})? ([-+]?[0-9.]+([eE][-+]?[0-9]+)?)$`)
+
+// Tests that the metrics textfile is valid Prometheus text format, with every
+// sample declared by a preceding HELP and TYPE, and holds the run's statistics.
+func TestConvertMetrics(t *testing.T) {
+	opts := memOptions(t, memProject(t, gxProject), gxDecisions)
+	opts.MetricsFile = filepath.Join(t.TempDir(), "ungx.prom")
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	blob, err := ioutil.ReadFile(opts.MetricsFile)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	var (
+		helped  = make(map[string]bool)
+		typed   = make(map[string]bool)
+		samples = make(map[string]string)
+	)
+	for i, line := range strings.Split(strings.TrimSuffix(string(blob), "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") {
+			helped[strings.Fields(line)[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 || (fields[3] != "gauge" && fields[3] != "counter") {
+				t.Errorf("line %d: invalid type declaration %q", i+1, line)
+			}
+			typed[fields[2]] = true
+			continue
+		}
+		match := metricSample.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("line %d: invalid sample %q", i+1, line)
+			continue
+		}
+		if !helped[match[1]] || !typed[match[1]] {
+			t.Errorf("line %d: sample of undeclared metric %s", i+1, match[1])
+		}
+		samples[match[1]+match[2]] = match[4]
+	}
+	want := map[string]string{
+		`ungx_packages{action="embed"}`:   "1",
+		`ungx_packages{action="vendor"}`:  "1",
+		`ungx_packages{action="require"}`: "0",
+		`ungx_packages{action="skip"}`:    "0",
+		`ungx_files_rewritten`:            "1",
+		`ungx_network_probes`:             "0",
+	}
+	for metric, value := range want {
+		if samples[metric] != value {
+			t.Errorf("%s: value mismatch: have %q, want %q", metric, samples[metric], value)
+		}
+	}
+	if _, ok := samples["ungx_duration_seconds"]; !ok {
+		t.Errorf("conversion duration missing:\n%s", blob)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// moduleProxy is the Go module proxy queried for the module form of packages.
+var moduleProxy = "https://proxy.golang.org"
+
+// moduleVersion queries the Go module proxy for the module version of the given
+// gx release ref, returning an error if it cannot be resolved as a module. Tags
+// that aren't valid module versions (e.g. missing a major version suffix) are
+// resolved by the proxy to the pseudo-version of their commit.
+func (c *conversion) moduleVersion(path string, ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("release version unknown")
+	}
+	res, err := c.httpGet(fmt.Sprintf("%s/%s/@v/%s.info", moduleProxy, escapeModulePath(path), url.PathEscape(ref)))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("module proxy responded with %s", res.Status)
+	}
+	var info struct {
+		Version string
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	if info.Version == "" {
+		return "", fmt.Errorf("module proxy returned no version")
+	}
+	return info.Version, nil
+}
+
+// escapeModulePath encodes a module path for the module proxy protocol, which
+// replaces every upper case letter with an exclamation mark and its lower case.
+func escapeModulePath(path string) string {
+	var escaped strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			escaped.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// moduleGoVersion is the go directive of the go.mod files created by ungx. The
+// gx ecosystem predates modules, so the oldest version supporting all the used
+// go.mod features is declared.
+const moduleGoVersion = "1.16"
+
+// localModuleVersion is the placeholder version local directory replacements are
+// required at.
+const localModuleVersion = "v0.0.0-00010101000000-000000000000"
+
+// addModuleRequires appends a require directive to the go.mod file of the project
+// for each of the given modules, creating the file if it doesn't exist yet.
+// Modules already required are left untouched.
+func (c *conversion) addModuleRequires(root string, requires map[string]string) error {
+	mod, perm, err := c.readGoMod(root)
+	if err != nil {
+		return err
+	}
+	for _, path := range sortedPaths(requires) {
+		if moduleRequired(mod, path) {
+			continue
+		}
+		mod += fmt.Sprintf("\nrequire %s %s\n", path, requires[path])
+	}
+	return c.fsys.WriteFile("go.mod", []byte(mod), perm)
+}
+
+// addModuleReplaces requires each of the given modules in the go.mod file of the
+// project, replacing it with a local directory (relative to the project root).
+// Every such directory gets a go.mod of its own if it doesn't have one yet, as
+// required for directory replacements, requiring the other replaced modules it
+// imports once rewritten. Modules already required are left untouched.
+func (c *conversion) addModuleReplaces(root string, replaces map[string]string, rules map[string]string) error {
+	mod, perm, err := c.readGoMod(root)
+	if err != nil {
+		return err
+	}
+	for _, path := range sortedPaths(replaces) {
+		dir := replaces[path]
+		if _, err := c.fsys.Stat(filepath.Join(dir, "go.mod")); os.IsNotExist(err) {
+			nested, err := c.nestedGoMod(path, dir, replaces, rules)
+			if err != nil {
+				return err
+			}
+			if err := c.fsys.WriteFile(filepath.Join(dir, "go.mod"), []byte(nested), 0644); err != nil {
+				return err
+			}
+		}
+		if moduleRequired(mod, path) {
+			continue
+		}
+		mod += fmt.Sprintf("\nrequire %s %s\n", path, localModuleVersion)
+		mod += fmt.Sprintf("replace %s => ./%s\n", path, filepath.ToSlash(dir))
+	}
+	return c.fsys.WriteFile("go.mod", []byte(mod), perm)
+}
+
+// nestedGoMod assembles the go.mod file of a replaced module, requiring all the
+// other replaced modules imported by its Go files once rewritten. The replace directives of the
+// project's go.mod resolve them, the nested ones are ignored by the go tool.
+func (c *conversion) nestedGoMod(path string, dir string, replaces map[string]string, rules map[string]string) (string, error) {
+	imported := make(map[string]string)
+	err := c.fsys.Walk(dir, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".go") {
+			return nil
+		}
+		src, err := c.fsys.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), fp, src, parser.ImportsOnly)
+		if err != nil {
+			return nil // Unparsable files are reported by the rewrite
+		}
+		for _, spec := range file.Imports {
+			imp, _ := strconv.Unquote(spec.Path.Value)
+			imp = applyRules(imp, rules)
+			for other := range replaces {
+				if other != path && (imp == other || strings.HasPrefix(imp, other+"/")) {
+					imported[other] = localModuleVersion
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	mod := fmt.Sprintf("module %s\n\ngo %s\n", path, moduleGoVersion)
+	for _, other := range sortedPaths(imported) {
+		mod += fmt.Sprintf("\nrequire %s %s\n", other, imported[other])
+	}
+	return mod, nil
+}
+
+// readGoMod reads the go.mod file of the project along with its permissions,
+// or creates the contents of a new one if it doesn't exist yet.
+func (c *conversion) readGoMod(root string) (string, os.FileMode, error) {
+	blob, err := c.fsys.ReadFile("go.mod")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", 0, err
+		}
+		blob = []byte(fmt.Sprintf("module %s\n\ngo %s\n", root, moduleGoVersion))
+	}
+	// Keep the permissions of an existing go.mod file
+	perm := os.FileMode(0644)
+	if info, err := c.fsys.Stat("go.mod"); err == nil {
+		perm = info.Mode().Perm()
+	}
+	mod := string(blob)
+	if !strings.HasSuffix(mod, "\n") {
+		mod += "\n"
+	}
+	return mod, perm, nil
+}
+
+// moduleRequired returns whether a go.mod file already requires a module.
+func moduleRequired(mod string, path string) bool {
+	return regexp.MustCompile(`(?m)^\s*(require\s+)?` + regexp.QuoteMeta(path) + `\s+v`).MatchString(mod)
+}
+
+// sortedPaths returns the keys of a module map in a deterministic order.
+func sortedPaths(modules map[string]string) []string {
+	paths := make([]string, 0, len(modules))
+	for path := range modules {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests that a project converted in modules mode builds against the generated
+// go.mod files without any network access.
+func TestModulesOfflineBuild(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	// Create a project whose embedded dependency imports a vendored one
+	files := map[string]string{
+		"vendor/gx/ipfs/QmFoo/foo/foo.go": "package foo\n\nimport \"gx/ipfs/QmBar/bar\"\n\nfunc Foo() { bar.Bar() }\n",
+	}
+	for path, content := range gxProject {
+		if _, ok := files[path]; !ok {
+			files[path] = content
+		}
+	}
+	dir := diskProject(t, files)
+
+	fakeCommand(t, "gx", "exit 0\n")
+
+	opts := memOptions(t, nil, gxDecisions)
+	opts.FS = osFS{dir: dir}
+	opts.Mode = "modules"
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vendor")); err == nil {
+		t.Errorf("vendor folder left behind in modules mode")
+	}
+	// Build the converted project with the network and the module cache disabled
+	build := exec.Command("go", "build", "./...")
+	build.Dir = dir
+	build.Env = append(os.Environ(), "GO111MODULE=on", "GOFLAGS=-mod=readonly", "GOPROXY=off", "GONOSUMDB=*", "GOWORK=off", "GOTOOLCHAIN=local", "GOPATH="+t.TempDir())
+	if out, err := build.CombinedOutput(); err != nil {
+		gomod, _ := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+		t.Fatalf("failed to build converted project: %v\n%s\ngo.mod:\n%s", err, out, gomod)
+	}
+}
+
+// Tests that gx packages whose vendored release resolves as a module are required
+// at that version, while the others are still embedded.
+func TestConvertReplaceWithRequire(t *testing.T) {
+	defer func(proxy string) { moduleProxy = proxy }(moduleProxy)
+
+	tests := []struct {
+		name    string
+		version string // Version the proxy resolves the release to, empty if none
+		require string // Expected require directive, empty if embedded
+	}{
+		{"release module", "v1.0.0", "require github.com/a/foo v1.0.0"},
+		{"release commit", "v0.0.0-20180101000000-0123456789ab", "require github.com/a/foo v0.0.0-20180101000000-0123456789ab"},
+		{"no module", "", ""},
+	}
+	for _, tt := range tests {
+		var queries []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queries = append(queries, r.URL.Path)
+			if tt.version == "" || r.URL.Path != "/github.com/a/foo/@v/v1.0.0.info" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `{"Version": %q}`, tt.version)
+		}))
+		moduleProxy = srv.URL
+
+		mem := memProject(t, gxProject)
+		opts := memOptions(t, mem, gxDecisions)
+		opts.ReplaceWithRequire = true
+
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		srv.Close()
+
+		if !reflect.DeepEqual(queries, []string{"/github.com/a/foo/@v/v1.0.0.info"}) {
+			t.Errorf("%s: proxy queries mismatch: have %v, want the release only", tt.name, queries)
+		}
+		gomod, _ := mem.ReadFile("go.mod")
+		if tt.require == "" {
+			if _, err := mem.Stat("gxlibs/github.com/a/foo/foo.go"); err != nil {
+				t.Errorf("%s: package not embedded: %v", tt.name, err)
+			}
+			continue
+		}
+		if !strings.Contains(string(gomod), tt.require+"\n") {
+			t.Errorf("%s: go.mod missing %q:\n%s", tt.name, tt.require, gomod)
+		}
+		if strings.Contains(string(gomod), "replace") {
+			t.Errorf("%s: go.mod contains replace directive:\n%s", tt.name, gomod)
+		}
+		if main, _ := mem.ReadFile("main.go"); !strings.Contains(string(main), `"github.com/a/foo"`) {
+			t.Errorf("%s: main.go not importing the module:\n%s", tt.name, main)
+		}
+	}
+}
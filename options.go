@@ -0,0 +1,289 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"time"
+)
+
+// Options configures a conversion. The zero value is a plain in place conversion
+// without any of the optional features, but the tunables (e.g. retries) are only
+// set sensibly by DefaultOptions.
+type Options struct {
+	// Fork defines an optional import path to rewrite the main package to. It's
+	// main use is when a gx package is forked into a different repo and avoids
+	// having to do an extra rewrite after copying the code.
+	Fork string
+
+	// Embed defines an optional comma separated list of import paths which should
+	// be embedded into the sources directly instead of vendoring. This can be used
+	// to pin an external dependency who's API is broken.
+	Embed string
+
+	// OnlyEmbed and OnlyVendor restrict the conversion to a single class of deps,
+	// leaving the other class in the gx vendor folder for a subsequent run. This
+	// can be used to split a conversion into smaller, reviewable commits.
+	OnlyEmbed  bool
+	OnlyVendor bool
+
+	// ReplaceWithRequire enables requiring gx based dependencies as proper modules
+	// in go.mod instead of embedding them, if the Go module proxy can resolve their
+	// vendored release. Others are still embedded.
+	ReplaceWithRequire bool
+
+	// Patch defines an optional file to write the conversion into as a git style
+	// patch, instead of modifying the project in place. Package moves are emitted
+	// as renames (and git mv hints), so the patch can be applied via git apply.
+	Patch string
+
+	// VerifyCID enables recomputing the IPFS content hash of every vendored gx
+	// package and comparing it to the hash it is stored under, detecting packages
+	// that were tampered with or corrupted.
+	VerifyCID bool
+
+	// RewriteIf defines an optional regular expression restricting the import path
+	// rewrites to files whose contents match it. This can be used to convert the
+	// usages of a single dependency at a time.
+	RewriteIf string
+
+	// DryRun enables analysing the project and resolving the embed/vendor decisions
+	// of all gx dependencies (including network probes), logging every intended
+	// action and reporting the plan without moving or rewriting any files.
+	DryRun bool
+
+	// GitCommits enables committing the conversion into the current git repository
+	// in logical chunks: embedded packages, vendored packages and import rewrites.
+	// The working tree must be clean, so the commits contain nothing else.
+	GitCommits bool
+
+	// ReportFile defines an optional file to write a JSON report of the conversion
+	// into, detailing the action taken for each gx dependency.
+	ReportFile string
+
+	// RewriteProtos enables rewriting the go_package options of protobuf
+	// definitions too, not just the imports of Go source files.
+	RewriteProtos bool
+
+	// ExcludeFiles defines an optional list of files (relative to the project root)
+	// which must never be rewritten, e.g. generated files with a known good state.
+	ExcludeFiles []string
+
+	// VerifyImports enables checking after the conversion that every import of the
+	// project resolves to an actual package, catching gaps in the rewrite rules.
+	VerifyImports bool
+
+	// Verify enables building the project after the conversion, failing with the
+	// compiler output if the rewritten imports don't compile.
+	Verify bool
+
+	// BuildTags, GOOS and GOARCH are passed to `go list` when resolving the import
+	// path of the project, needed if it only builds with specific constraints.
+	BuildTags string
+	GOOS      string
+	GOARCH    string
+
+	// Licenses defines an optional file to write the detected licenses of all the
+	// embedded and vendored dependencies into, for compliance audits.
+	Licenses string
+
+	// RewriteScripts defines optional comma separated glob patterns of non-Go files
+	// (e.g. Makefiles, shell scripts) in which to rewrite the import paths too.
+	RewriteScripts string
+
+	// UndoScript defines an optional shell script to generate, which reverts all
+	// the package moves done by the conversion and lists the rewritten files.
+	UndoScript string
+
+	// DependencyReport defines an optional file to write the gx dependencies into,
+	// grouped by their repositories, for a more digestible view of large trees.
+	DependencyReport string
+
+	// PerPackageHook defines an optional shell command to run after each package
+	// is moved, receiving the canonical import path and the destination folder.
+	PerPackageHook string
+
+	// OnPackageMoved is an optional callback invoked after each dependency has been
+	// moved, receiving its canonical import path and the folder it was moved to
+	// (relative to the project root). It runs before the PerPackageHook, and an
+	// error returned from it aborts the conversion.
+	OnPackageMoved func(path, dest string) error
+
+	// GitMoves enables moving packages via `git mv` so that git history and blame
+	// follow the moved files. Untracked packages fall back to plain renames.
+	GitMoves bool
+
+	// CacheFile defines an optional file to persist the embed/vendor decisions in,
+	// so subsequent runs can skip the network probes of already decided paths.
+	CacheFile string
+
+	// Provenance enables generating a Go file into every embedded package recording
+	// the gx hash and upstream path it originates from.
+	Provenance bool
+
+	// Strict turns internal consistency check failures into fatal errors instead
+	// of mere warnings.
+	Strict bool
+
+	// Scope restricts the conversion to the gx dependencies imported by the
+	// packages matching a Go import pattern, leaving the rest as gx hashes.
+	Scope string
+
+	// Only and Skip define optional comma separated globs of canonical import paths
+	// restricting which gx dependencies are converted. Others are left in place
+	// with their imports unchanged. Skip takes precedence: a dependency matching
+	// both is skipped. A glob matching a parent path (e.g. the repository) selects
+	// all the packages within.
+	Only string
+	Skip string
+
+	// RelocateReclassified moves packages converted by a previous run to their new
+	// location if their classification changed, instead of keeping both copies.
+	RelocateReclassified bool
+
+	// MetricsFile is the path of a Prometheus textfile to write the statistics of
+	// the conversion into.
+	MetricsFile string
+
+	// DependenciesOnly installs and classifies all the gx dependencies, caching the
+	// embed decisions, without converting anything. A later conversion using the
+	// same cache needs no network access.
+	DependenciesOnly bool
+
+	// EventsFile is a file (or - for stdout) to stream the report entries into as
+	// JSON lines while the conversion progresses, for driving interactive tools.
+	EventsFile string
+
+	// Events is an optional channel to stream the entries of the report into while
+	// the conversion is in progress. Sends block, so the channel needs to be
+	// drained concurrently. It is closed when the conversion returns, whether it
+	// succeeded or not.
+	Events chan<- ReportEntry
+
+	// Workers is the number of dependencies whose embed/vendor decisions are made
+	// concurrently. Network connections are further capped by MaxHTTPConns.
+	Workers int
+
+	// SuggestStdlib reports the dependencies that merely shim functionality which
+	// is nowadays part of the standard library.
+	SuggestStdlib bool
+
+	// Mode selects how embedded dependencies are imported. In gopath mode imports
+	// are rewritten to the gxlibs copies, whereas in modules mode imports keep the
+	// canonical paths and go.mod replace directives point them to the copies. As
+	// the go tool can't mix replaces with a vendor folder, modules mode places the
+	// plain Go dependencies next to the embedded ones.
+	Mode string
+
+	// DumpMapping is a CSV file to dump the hash to path mapping of all the gx
+	// dependencies into, along with the embed/vendor decisions.
+	DumpMapping string
+
+	// LibDir is the folder (relative to the project root) gx based dependencies
+	// are embedded into.
+	LibDir string
+
+	// OnUnreadable is the policy for gx package folders that cannot be read or
+	// parsed. Either way they are left unconverted, but with fail the conversion
+	// returns an error at the end, whereas with skip they are only reported.
+	OnUnreadable string
+
+	// SummaryJSON prints a compact, single line JSON summary of the conversion to
+	// stdout at the end, while all the logs go to stderr.
+	SummaryJSON bool
+
+	// RewritePathConstants enables rewriting string constants holding import paths
+	// too (e.g. used by plugin systems at runtime), not just import declarations.
+	RewritePathConstants bool
+
+	// RequireOfflineDecisions makes the conversion fail if classifying any
+	// dependency would need a network probe instead of a cached or explicitly
+	// declared decision.
+	RequireOfflineDecisions bool
+
+	// DedupeSemver collapses the semver compatible versions of a gx dependency into
+	// the newest one instead of embedding each of them separately.
+	DedupeSemver bool
+
+	// KeepImportComments rewrites the paths within import comments instead of
+	// stripping the comments, keeping the import path enforcement in place.
+	KeepImportComments bool
+
+	// NoFormat disables running gofmt on the Go files modified by the rewrites,
+	// leaving them byte for byte as rewritten.
+	NoFormat bool
+
+	// ChangedFiles defines an optional file to write the newline separated list of
+	// files rewritten or moved by the conversion into, e.g. for external formatters.
+	ChangedFiles string
+
+	// Verbose enables logging the fine grained details of the conversion, such as
+	// every single file rewritten, instead of only the per package progress.
+	Verbose bool
+
+	// Quiet suppresses all logging except for the errors.
+	Quiet bool
+
+	// OnlyRewrite skips installing, classifying and moving the gx dependencies, and
+	// only reapplies the import rewrites recorded by a previous conversion.
+	OnlyRewrite string
+
+	// GetRetries and GetBackoff configure how many times a failed go get download
+	// is retried before giving up (and embedding) and how long to wait in between.
+	// The backoff is doubled after each failed attempt.
+	GetRetries int
+	GetBackoff time.Duration
+
+	// MaxHTTPConns caps the number of concurrent outbound HTTP connections, both in
+	// total and per host, to be polite to hosts like GitHub and avoid exhausting
+	// the local sockets during large parallel conversions.
+	MaxHTTPConns int
+
+	// HTTPTimeout and HTTPRetries bound the time a single HTTP request may take and
+	// how many times transient failures (network timeouts and temporary errors, or
+	// 502, 503 and 504 responses) are retried before giving up. Other failures are
+	// returned right away. The backoff between retries is doubled after each one.
+	HTTPTimeout time.Duration
+	HTTPRetries int
+
+	// InstallTimeout bounds the time gx is allowed to spend fetching dependencies,
+	// since a stuck IPFS fetch would otherwise hang the conversion indefinitely.
+	InstallTimeout time.Duration
+
+	// OutputDir is an optional directory to copy the package into and convert the
+	// copy, leaving the original package untouched. A relative path is resolved
+	// against the root of the package.
+	OutputDir string
+
+	// GitHubRawHosts maps additional GitHub Enterprise hosts to the endpoints
+	// serving the raw contents of the repositories hosted on them.
+	GitHubRawHosts map[string]string
+
+	// FS is the file system holding the project to convert, defaulting to the
+	// operating system's (rooted at the current directory) if unset. Features that
+	// need to shell out (installing the gx dependencies, go list, git, hooks and
+	// builds) are unavailable on any other file system: the dependencies need to
+	// be installed into it up front and the ImportPath set explicitly.
+	FS FS
+
+	// ImportPath is the import path of the project to convert. If unset, it is
+	// resolved via go list.
+	ImportPath string
+}
+
+// DefaultOptions returns the options of a plain conversion, with all the
+// tunables set to their defaults.
+func DefaultOptions() Options {
+	return Options{
+		Workers:      8,
+		Mode:         "gopath",
+		LibDir:       "gxlibs",
+		OnUnreadable: "fail",
+		GetRetries:   3,
+		GetBackoff:   time.Second,
+		MaxHTTPConns: 8,
+		HTTPTimeout:  10 * time.Second,
+		HTTPRetries:  2,
+	}
+}
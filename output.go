@@ -0,0 +1,76 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// copyPackage copies the package at the root of the file system into an empty
+// (or not yet existing) output directory and roots the conversion in it. A
+// relative output directory is resolved against the package root too.
+func (c *conversion) copyPackage(dir string) error {
+	src, err := filepath.Abs(c.diskPath("."))
+	if err != nil {
+		return err
+	}
+	dst := dir
+	if !filepath.IsAbs(dst) {
+		dst = filepath.Join(src, dst)
+	}
+	if dst == src || strings.HasPrefix(dst, src+string(filepath.Separator)) {
+		return fmt.Errorf("output %s is inside the package", dir)
+	}
+	if entries, err := c.fsys.ReadDir(dst); err == nil && len(entries) > 0 {
+		return fmt.Errorf("output %s is not empty", dir)
+	}
+	c.logInfo("Copying package into %s", dst)
+	if err := c.copyTree(src, dst); err != nil {
+		return err
+	}
+	c.fsys = osFS{dir: dst}
+	return nil
+}
+
+// writeChangedFiles writes the newline separated list of all the files changed
+// by the conversion into a file: the ones rewritten in place and the ones moved
+// along with their packages.
+func (c *conversion) writeChangedFiles(file string) error {
+	changed := make(map[string]bool)
+	for _, path := range c.undo.files {
+		changed[path] = true
+	}
+	for _, move := range c.undo.moves {
+		err := c.fsys.Walk(move.dst, func(fp string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode().IsRegular() {
+				changed[fp] = true
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var list bytes.Buffer
+	for _, path := range paths {
+		fmt.Fprintln(&list, path)
+	}
+	return ioutil.WriteFile(file, list.Bytes(), 0644)
+}
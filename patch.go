@@ -0,0 +1,233 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// move is a pending package relocation, recorded instead of executed when the
+// conversion is requested to be emitted as a patch.
+type move struct {
+	src string // Current path of the package folder
+	dst string // Canonical path the package would be moved to
+}
+
+// movedPath returns the path a file would end up at after all the recorded
+// moves are applied.
+func (c *conversion) movedPath(fp string) string {
+	for _, move := range c.moves {
+		if fp == move.src {
+			return move.dst
+		}
+		if strings.HasPrefix(fp, move.src+string(filepath.Separator)) {
+			return move.dst + fp[len(move.src):]
+		}
+	}
+	return fp
+}
+
+// writeMoveHints writes the recorded moves as git mv commands. The hints are
+// placed before the first diff, so git apply will ignore them.
+func (c *conversion) writeMoveHints(out *bytes.Buffer) {
+	for _, move := range c.moves {
+		fmt.Fprintf(out, "# git mv %s %s\n", filepath.ToSlash(move.src), filepath.ToSlash(move.dst))
+	}
+	if len(c.moves) > 0 {
+		fmt.Fprintln(out)
+	}
+}
+
+// writeDiff writes a git style unified diff of a file into the output buffer,
+// also handling the file being renamed in the process.
+func writeDiff(out *bytes.Buffer, src, dst string, oldblob, newblob []byte) {
+	src, dst = filepath.ToSlash(src), filepath.ToSlash(dst)
+
+	fmt.Fprintf(out, "diff --git a/%s b/%s\n", src, dst)
+	if bytes.Equal(oldblob, newblob) {
+		if src != dst {
+			fmt.Fprintf(out, "similarity index 100%%\nrename from %s\nrename to %s\n", src, dst)
+		}
+		return
+	}
+	if src != dst {
+		fmt.Fprintf(out, "rename from %s\nrename to %s\n", src, dst)
+	}
+	fmt.Fprintf(out, "--- a/%s\n+++ b/%s\n", src, dst)
+
+	oldlines, newlines := splitLines(oldblob), splitLines(newblob)
+	for _, hunk := range diffHunks(diffLines(oldlines, newlines), 3) {
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(hunk.oldStart, hunk.oldCount), hunkRange(hunk.newStart, hunk.newCount))
+		for _, line := range hunk.lines {
+			out.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+}
+
+// splitLines splits a blob into lines, retaining the line terminators.
+func splitLines(blob []byte) []string {
+	var lines []string
+	for len(blob) > 0 {
+		idx := bytes.IndexByte(blob, '\n')
+		if idx < 0 {
+			lines = append(lines, string(blob))
+			break
+		}
+		lines = append(lines, string(blob[:idx+1]))
+		blob = blob[idx+1:]
+	}
+	return lines
+}
+
+// edit is a single line operation in a diff: a kept, deleted or inserted line.
+type edit struct {
+	op   byte // One of ' ', '-' or '+'
+	line string
+}
+
+// diffLines computes the shortest edit script transforming the old lines into
+// the new ones, using Myers' algorithm.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	v := make([]int, 2*max+1)
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, d, max)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the Myers trace backwards, assembling the edit script.
+func backtrack(trace [][]int, a, b []string, d, offset int) []edit {
+	var edits []edit
+
+	x, y := len(a), len(b)
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prev int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prev = k + 1
+		} else {
+			prev = k - 1
+		}
+		px := v[offset+prev]
+		py := px - prev
+
+		for x > px && y > py {
+			x, y = x-1, y-1
+			edits = append(edits, edit{' ', a[x]})
+		}
+		if x == px {
+			y--
+			edits = append(edits, edit{'+', b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{'-', a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		edits = append(edits, edit{' ', a[x]})
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// hunk is a group of nearby changes with their surrounding context lines.
+type hunk struct {
+	oldStart, oldCount int
+	newStart, newCount int
+	lines              []string
+}
+
+// diffHunks groups an edit script into hunks with the given number of context
+// lines around each change.
+func diffHunks(edits []edit, context int) []hunk {
+	// Precompute the old and new line numbers preceding each edit
+	oldnos := make([]int, len(edits)+1)
+	newnos := make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldnos[i+1], newnos[i+1] = oldnos[i], newnos[i]
+		if e.op != '+' {
+			oldnos[i+1]++
+		}
+		if e.op != '-' {
+			newnos[i+1]++
+		}
+	}
+	// Group changes closer than twice the context into the same hunk
+	var hunks []hunk
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		end := i
+		for j := i + 1; j < len(edits) && j-end <= 2*context+1; j++ {
+			if edits[j].op != ' ' {
+				end = j
+			}
+		}
+		start, stop := i-context, end+1+context
+		if start < 0 {
+			start = 0
+		}
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+		h := hunk{oldStart: oldnos[start] + 1, newStart: newnos[start] + 1}
+		for _, e := range edits[start:stop] {
+			h.lines = append(h.lines, string(e.op)+e.line)
+			if e.op != '+' {
+				h.oldCount++
+			}
+			if e.op != '-' {
+				h.newCount++
+			}
+		}
+		hunks = append(hunks, h)
+		i = stop
+	}
+	return hunks
+}
+
+// hunkRange formats a line range of a hunk header.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// goFiles returns the contents of all the Go files within a folder, keyed by
+// their slash separated relative paths.
+func goFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		blob, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(blob)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read Go files of %s: %v", dir, err)
+	}
+	return files
+}
+
+// Tests that the conversion patch is accepted by git apply, and that applying it
+// results in the same Go files as converting in place, including the moves.
+func TestConvertPatch(t *testing.T) {
+	fakeCommand(t, "gx", "exit 0\n")
+
+	tests := []struct {
+		name  string
+		files map[string]string // Files overriding the ones of gxProject
+	}{
+		{
+			name: "import rewrites",
+		},
+		{
+			name: "rewritten moved package",
+			files: map[string]string{
+				"vendor/gx/ipfs/QmFoo/foo/foo.go": "package foo\n\nimport \"gx/ipfs/QmBar/bar\"\n\nfunc Foo() { bar.Bar() }\n",
+			},
+		},
+		{
+			name: "missing trailing newline",
+			files: map[string]string{
+				"main.go": "package main\n\nimport (\n\t\"gx/ipfs/QmBar/bar\"\n\t\"gx/ipfs/QmFoo/foo\"\n)\n\nfunc main() { foo.Foo(); bar.Bar() }",
+			},
+		},
+		{
+			name: "windows line endings",
+			files: map[string]string{
+				"main.go": "package main\r\n\r\nimport (\r\n\t\"gx/ipfs/QmBar/bar\"\r\n\t\"gx/ipfs/QmFoo/foo\"\r\n)\r\n\r\nfunc main() { foo.Foo(); bar.Bar() }\r\n",
+			},
+		},
+		{
+			name: "adjacent hunks",
+			files: map[string]string{
+				"main.go": "package main\n\nimport \"gx/ipfs/QmFoo/foo\"\n\n" + strings.Repeat("// Filler\n", 4) + "\nimport \"gx/ipfs/QmBar/bar\"\n\nfunc main() { foo.Foo(); bar.Bar() }\n",
+			},
+		},
+		{
+			name: "barely separate hunks",
+			files: map[string]string{
+				"main.go": "package main\n\nimport \"gx/ipfs/QmFoo/foo\"\n\n" + strings.Repeat("// Filler\n", 5) + "\nimport \"gx/ipfs/QmBar/bar\"\n\nfunc main() { foo.Foo(); bar.Bar() }\n",
+			},
+		},
+		{
+			name: "distant hunks",
+			files: map[string]string{
+				"main.go": "package main\n\nimport \"gx/ipfs/QmFoo/foo\"\n\n" + strings.Repeat("// Filler\n", 20) + "\nimport \"gx/ipfs/QmBar/bar\"\n\nfunc main() { foo.Foo(); bar.Bar() }\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		files := make(map[string]string)
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		for path, content := range tt.files {
+			files[path] = content
+		}
+		// Convert one copy in place, the expected outcome of the patch
+		opts := memOptions(t, nil, gxDecisions)
+		opts.FS = osFS{dir: diskProject(t, files)}
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert in place: %v", tt.name, err)
+		}
+		want := goFiles(t, opts.FS.(osFS).dir)
+
+		// Emit the conversion of another copy as a patch, and apply that with git
+		dir := diskProject(t, files)
+		original := goFiles(t, dir)
+
+		opts = memOptions(t, nil, gxDecisions)
+		opts.FS = osFS{dir: dir}
+		opts.Patch = filepath.Join(t.TempDir(), "ungx.patch")
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to create patch: %v", tt.name, err)
+		}
+		if reflect.DeepEqual(want, original) {
+			t.Fatalf("%s: nothing converted", tt.name)
+		}
+		if have := goFiles(t, dir); !reflect.DeepEqual(have, original) {
+			t.Errorf("%s: files modified in patch mode", tt.name)
+		}
+		gitRun(t, dir, "apply", "--check", opts.Patch)
+		gitRun(t, dir, "apply", opts.Patch)
+
+		if have := goFiles(t, dir); !reflect.DeepEqual(have, want) {
+			blob, _ := ioutil.ReadFile(opts.Patch)
+			t.Errorf("%s: patched files mismatch:\nhave %q\nwant %q\npatch:\n%s", tt.name, have, want, blob)
+		}
+	}
+}
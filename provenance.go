@@ -0,0 +1,43 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// provenanceFile is the name of the generated file recording where an embedded
+// package originates from.
+const provenanceFile = "ungx_provenance.go"
+
+// writeProvenance generates a Go file into an embedded package recording its
+// original gx hash and upstream import path, so the origin of the code is
+// visible in the source itself. Folders without a Go package and packages that
+// already contain a file with the same name are skipped.
+func (c *conversion) writeProvenance(dir string, hash string, path string) error {
+	name, err := c.packageName(dir)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		c.logInfo("Skipping provenance of %s, no Go package in %s", path, dir)
+		return nil
+	}
+	file := filepath.Join(dir, provenanceFile)
+	if _, err := c.fsys.Stat(file); err == nil {
+		c.logInfo("Skipping provenance of %s, %s already exists", path, file)
+		return nil
+	}
+	source := fmt.Sprintf(`// Code generated by ungx. DO NOT EDIT.
+
+// This package was embedded by ungx from gx/ipfs/%s, originating
+// from upstream %s.
+
+package %s
+`, hash, path, name)
+
+	return c.fsys.WriteFile(file, []byte(source), 0644)
+}
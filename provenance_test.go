@@ -0,0 +1,69 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tests that provenance files are written into every embedded folder holding Go
+// code, including the nested folders of hashes with multiple ones.
+func TestConvertProvenance(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  map[string]string // Provenance file folders to their gx origin
+	}{
+		{
+			name: "single folder",
+			files: map[string]string{
+				"vendor/gx/ipfs/QmFoo/foo/package.json": gxProject["vendor/gx/ipfs/QmFoo/foo/package.json"],
+				"vendor/gx/ipfs/QmFoo/foo/foo.go":       "package foo\n",
+			},
+			want: map[string]string{"gxlibs/github.com/a/foo": "QmFoo/foo"},
+		},
+		{
+			name: "spec folder with a sibling",
+			files: map[string]string{
+				"vendor/gx/ipfs/QmFoo/foo/package.json": gxProject["vendor/gx/ipfs/QmFoo/foo/package.json"],
+				"vendor/gx/ipfs/QmFoo/foo/foo.go":       "package foo\n",
+				"vendor/gx/ipfs/QmFoo/aaa/aaa.go":       "package aaa\n",
+			},
+			want: map[string]string{"gxlibs/github.com/a/foo": "QmFoo/foo", "gxlibs/github.com/a/foo/aaa": "QmFoo/aaa"},
+		},
+		{
+			name: "nested spec with code siblings",
+			files: map[string]string{
+				"vendor/gx/ipfs/QmFoo/meta/foo/package.json": gxProject["vendor/gx/ipfs/QmFoo/foo/package.json"],
+				"vendor/gx/ipfs/QmFoo/aaa/aaa.go":            "package aaa\n",
+				"vendor/gx/ipfs/QmFoo/bbb/bbb.go":            "package bbb\n",
+			},
+			want: map[string]string{"gxlibs/github.com/a/foo/aaa": "QmFoo/aaa", "gxlibs/github.com/a/foo/bbb": "QmFoo/bbb"},
+		},
+	}
+	for _, tt := range tests {
+		tt.files["main.go"] = "package main\n"
+
+		mem := memProject(t, tt.files)
+		opts := memOptions(t, mem, gxDecisions)
+		opts.Provenance = true
+
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		for dir, origin := range tt.want {
+			blob, err := mem.ReadFile(filepath.Join(dir, provenanceFile))
+			if err != nil {
+				t.Errorf("%s: missing provenance in %s: %v", tt.name, dir, err)
+				continue
+			}
+			if !strings.Contains(string(blob), "gx/ipfs/"+origin+",") {
+				t.Errorf("%s: provenance origin mismatch in %s: want %s\n%s", tt.name, dir, origin, blob)
+			}
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Report is a machine readable summary of a conversion, detailing what ungx did
+// with each gx dependency and which files it rewrote.
+type Report struct {
+	Root      string            `json:"root"`               // Import path of the converted project
+	Packages  []ReportPackage   `json:"packages"`           // Actions taken for each gx dependency
+	Rewritten []string          `json:"rewritten"`          // Files whose imports were rewritten
+	Rewrites  map[string]string `json:"rewrites,omitempty"` // Import path rewrite rules applied
+	Changed   []ReportChange    `json:"changed,omitempty"`  // Files changed by gx, not by ungx
+
+	events chan<- ReportEntry // Optional channel of the caller to stream the entries into
+	stream chan<- ReportEntry // Optional event file stream, closed when finished
+
+	failures int // Number of errors the conversion finished with
+}
+
+// ReportEntry is a single entry of a report, streamed while the conversion is
+// still in progress. Exactly one of the fields is set.
+type ReportEntry struct {
+	Package *ReportPackage `json:"package,omitempty"` // Action taken for a gx dependency
+	File    string         `json:"file,omitempty"`    // File whose imports were rewritten
+	Change  *ReportChange  `json:"change,omitempty"`  // File changed by gx, not by ungx
+}
+
+// ReportPackage is the conversion outcome of a single gx dependency.
+type ReportPackage struct {
+	Hash   string `json:"hash"`             // Hash the dependency was installed under
+	Path   string `json:"path"`             // Canonical import path of the dependency
+	Action string `json:"action"`           // Either "embed", "vendor", "require", "dedupe" or "skip"
+	Target string `json:"target,omitempty"` // Location the dependency was moved to
+	Reason string `json:"reason,omitempty"` // Why the action was chosen
+}
+
+// ReportChange is a file outside of the gx vendor folder that was changed by a
+// step of the conversion other than ungx's own rewrites (e.g. gx install).
+type ReportChange struct {
+	File   string `json:"file"`   // File changed, relative to the project root
+	Change string `json:"change"` // Either "created", "modified" or "deleted"
+	Reason string `json:"reason"` // Step of the conversion that changed the file
+}
+
+// add records the action taken for a gx dependency.
+func (r *Report) add(hash, path, action, target, reason string) {
+	pkg := ReportPackage{
+		Hash:   hash,
+		Path:   path,
+		Action: action,
+		Target: target,
+		Reason: reason,
+	}
+	r.Packages = append(r.Packages, pkg)
+	r.emit(ReportEntry{Package: &pkg})
+}
+
+// rewrote records a file whose imports were rewritten.
+func (r *Report) rewrote(file string) {
+	r.Rewritten = append(r.Rewritten, file)
+	r.emit(ReportEntry{File: file})
+}
+
+// changed records a file changed by a step other than ungx's own rewrites.
+func (r *Report) changed(change ReportChange) {
+	r.Changed = append(r.Changed, change)
+	r.emit(ReportEntry{Change: &change})
+}
+
+// emit streams a new entry of the report to the listeners, if any.
+func (r *Report) emit(entry ReportEntry) {
+	if r.events != nil {
+		r.events <- entry
+	}
+	if r.stream != nil {
+		r.stream <- entry
+	}
+}
+
+// finish closes the event file stream of the report, if any, signalling that
+// the conversion is done. The caller's channel is left open.
+func (r *Report) finish() {
+	if r.stream != nil {
+		close(r.stream)
+		r.stream = nil
+	}
+	r.events = nil
+}
+
+// save sorts the contents of the report and writes it into a JSON file.
+func (r *Report) save(file string) error {
+	sort.Slice(r.Packages, func(i, j int) bool { return r.Packages[i].Hash < r.Packages[j].Hash })
+	sort.Strings(r.Rewritten)
+
+	blob, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(blob, '\n'), 0644)
+}
+
+// printPlan logs the conversion plan contained in a report in a human readable
+// form, sorted by import path.
+func (c *conversion) printPlan(r *Report) {
+	pkgs := append([]ReportPackage{}, r.Packages...)
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Path < pkgs[j].Path })
+
+	c.logInfo("Conversion plan for %s:", r.Root)
+	for _, pkg := range pkgs {
+		if pkg.Target != "" {
+			c.logInfo("  %-6s %s (gx/ipfs/%s) to %s: %s", pkg.Action, pkg.Path, pkg.Hash, pkg.Target, pkg.Reason)
+		} else {
+			c.logInfo("  %-6s %s (gx/ipfs/%s): %s", pkg.Action, pkg.Path, pkg.Hash, pkg.Reason)
+		}
+	}
+	for _, change := range r.Changed {
+		c.logInfo("  %s %s by %s", change.File, change.Change, change.Reason)
+	}
+	c.logInfo("  %d files would have their imports rewritten", len(r.Rewritten))
+}
+
+// LoadReport reads a previously saved conversion report.
+func LoadReport(file string) (*Report, error) {
+	blob, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	r := new(Report)
+	if err := json.Unmarshal(blob, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// DiffReports compares two conversion reports and returns the meaningful
+// differences between them: changes in the project root, dependencies only
+// present in one of them, dependencies with a different path, action or target
+// and files only rewritten by one of them. Ordering and the free form reasons
+// are not considered meaningful.
+func DiffReports(a, b *Report) []string {
+	var diffs []string
+	if a.Root != b.Root {
+		diffs = append(diffs, fmt.Sprintf("~ root: %s -> %s", a.Root, b.Root))
+	}
+	// Compare the dependencies by their gx hashes
+	var (
+		olds   = make(map[string]ReportPackage)
+		news   = make(map[string]ReportPackage)
+		hashes []string
+	)
+	for _, pkg := range a.Packages {
+		olds[pkg.Hash] = pkg
+		hashes = append(hashes, pkg.Hash)
+	}
+	for _, pkg := range b.Packages {
+		news[pkg.Hash] = pkg
+		hashes = append(hashes, pkg.Hash)
+	}
+	for _, hash := range uniqueSorted(hashes) {
+		old, inOld := olds[hash]
+		cur, inNew := news[hash]
+
+		switch {
+		case !inNew:
+			diffs = append(diffs, fmt.Sprintf("- package %s (%s): %s %s", hash, old.Path, old.Action, old.Target))
+		case !inOld:
+			diffs = append(diffs, fmt.Sprintf("+ package %s (%s): %s %s", hash, cur.Path, cur.Action, cur.Target))
+		default:
+			if old.Path != cur.Path {
+				diffs = append(diffs, fmt.Sprintf("~ package %s path: %s -> %s", hash, old.Path, cur.Path))
+			}
+			if old.Action != cur.Action {
+				diffs = append(diffs, fmt.Sprintf("~ package %s (%s) action: %s -> %s", hash, cur.Path, old.Action, cur.Action))
+			}
+			if old.Target != cur.Target {
+				diffs = append(diffs, fmt.Sprintf("~ package %s (%s) target: %s -> %s", hash, cur.Path, old.Target, cur.Target))
+			}
+		}
+	}
+	// Compare the set of rewritten files
+	oldFiles := make(map[string]bool)
+	for _, file := range a.Rewritten {
+		oldFiles[file] = true
+	}
+	newFiles := make(map[string]bool)
+	for _, file := range b.Rewritten {
+		newFiles[file] = true
+	}
+	for _, file := range uniqueSorted(append(append([]string{}, a.Rewritten...), b.Rewritten...)) {
+		switch {
+		case !newFiles[file]:
+			diffs = append(diffs, fmt.Sprintf("- rewritten %s", file))
+		case !oldFiles[file]:
+			diffs = append(diffs, fmt.Sprintf("+ rewritten %s", file))
+		}
+	}
+	return diffs
+}
+
+// uniqueSorted deduplicates a list of strings and sorts them alphabetically.
+func uniqueSorted(items []string) []string {
+	seen := make(map[string]bool)
+
+	var unique []string
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			unique = append(unique, item)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// resultSummary is the compact result of a conversion printed for scripts. The
+// schema is stable: fields may be added, but never renamed or removed.
+type resultSummary struct {
+	Success   bool    `json:"success"`   // Whether the conversion finished without errors
+	Embedded  int     `json:"embedded"`  // Number of dependencies embedded
+	Vendored  int     `json:"vendored"`  // Number of dependencies vendored
+	Required  int     `json:"required"`  // Number of dependencies required as modules
+	Deduped   int     `json:"deduped"`   // Number of dependencies superseded by a newer version
+	Skipped   int     `json:"skipped"`   // Number of dependencies left unconverted
+	Rewritten int     `json:"rewritten"` // Number of files with rewritten imports
+	Errors    int     `json:"errors"`    // Number of failures encountered
+	Duration  float64 `json:"duration"`  // Wall clock duration in seconds
+}
+
+// printSummary writes the compact result of the conversion as a single line of
+// JSON into the given writer.
+func (r *Report) printSummary(w io.Writer, duration time.Duration, errors int) error {
+	summary := resultSummary{
+		Success:   errors == 0,
+		Rewritten: len(r.Rewritten),
+		Errors:    errors,
+		Duration:  duration.Seconds(),
+	}
+	for _, pkg := range r.Packages {
+		switch pkg.Action {
+		case "embed":
+			summary.Embedded++
+		case "vendor":
+			summary.Vendored++
+		case "require":
+			summary.Required++
+		case "dedupe":
+			summary.Deduped++
+		default:
+			summary.Skipped++
+		}
+	}
+	return json.NewEncoder(w).Encode(summary)
+}
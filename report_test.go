@@ -0,0 +1,141 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Tests that the JSON summary is printed for both successful and failed runs,
+// reporting the outcome of the conversion.
+func TestSummaryJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  string
+		success bool
+		errors  int
+	}{
+		{"successful conversion", "", true, 0},
+		{"rejected options", "-", false, 1},
+	}
+	for _, tt := range tests {
+		opts := memOptions(t, memProject(t, gxProject), gxDecisions)
+		opts.SummaryJSON = true
+		opts.EventsFile = tt.events
+
+		// Capture the summary printed to stdout
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("%s: failed to create stdout pipe: %v", tt.name, err)
+		}
+		stdout := os.Stdout
+		os.Stdout = writer
+
+		_, err = Convert(opts)
+
+		os.Stdout = stdout
+		writer.Close()
+		blob, _ := ioutil.ReadAll(reader)
+
+		if (err == nil) != tt.success {
+			t.Errorf("%s: conversion error mismatch: have %v, want success %v", tt.name, err, tt.success)
+		}
+		var summary resultSummary
+		if err := json.Unmarshal(blob, &summary); err != nil {
+			t.Errorf("%s: failed to parse summary %q: %v", tt.name, blob, err)
+			continue
+		}
+		if summary.Success != tt.success || summary.Errors != tt.errors {
+			t.Errorf("%s: summary mismatch: have success %v errors %d, want %v and %d", tt.name, summary.Success, summary.Errors, tt.success, tt.errors)
+		}
+	}
+}
+
+// Tests that diffing two saved reports lists the meaningful differences between
+// them, ignoring ordering and the free form reasons.
+func TestDiffReports(t *testing.T) {
+	var (
+		foo = ReportPackage{Hash: "QmFoo", Path: "github.com/a/foo", Action: "embed", Target: "gxlibs/github.com/a/foo", Reason: "gx based upstream"}
+		bar = ReportPackage{Hash: "QmBar", Path: "github.com/b/bar", Action: "vendor", Target: "vendor/github.com/b/bar", Reason: "plain Go upstream"}
+	)
+	tests := []struct {
+		name string
+		a, b Report
+		want []string
+	}{
+		{
+			name: "identical reports",
+			a:    Report{Root: "example.com/proj", Packages: []ReportPackage{foo, bar}, Rewritten: []string{"main.go"}},
+			b:    Report{Root: "example.com/proj", Packages: []ReportPackage{foo, bar}, Rewritten: []string{"main.go"}},
+		},
+		{
+			name: "reordered with different reasons",
+			a:    Report{Root: "example.com/proj", Packages: []ReportPackage{foo, bar}, Rewritten: []string{"a.go", "main.go"}},
+			b: Report{Root: "example.com/proj", Packages: []ReportPackage{
+				{Hash: "QmBar", Path: "github.com/b/bar", Action: "vendor", Target: "vendor/github.com/b/bar", Reason: "cached decision"},
+				{Hash: "QmFoo", Path: "github.com/a/foo", Action: "embed", Target: "gxlibs/github.com/a/foo", Reason: "cached decision"},
+			}, Rewritten: []string{"main.go", "a.go"}},
+		},
+		{
+			name: "changed classification",
+			a:    Report{Root: "example.com/proj", Packages: []ReportPackage{foo, bar}},
+			b: Report{Root: "example.com/proj", Packages: []ReportPackage{foo,
+				{Hash: "QmBar", Path: "github.com/b/bar", Action: "embed", Target: "gxlibs/github.com/b/bar"},
+			}},
+			want: []string{
+				"~ package QmBar (github.com/b/bar) action: vendor -> embed",
+				"~ package QmBar (github.com/b/bar) target: vendor/github.com/b/bar -> gxlibs/github.com/b/bar",
+			},
+		},
+		{
+			name: "new and dropped embeds",
+			a:    Report{Root: "example.com/proj", Packages: []ReportPackage{foo}},
+			b:    Report{Root: "example.com/proj", Packages: []ReportPackage{bar}},
+			want: []string{
+				"+ package QmBar (github.com/b/bar): vendor vendor/github.com/b/bar",
+				"- package QmFoo (github.com/a/foo): embed gxlibs/github.com/a/foo",
+			},
+		},
+		{
+			name: "different file sets",
+			a:    Report{Root: "example.com/proj", Rewritten: []string{"a.go", "main.go"}},
+			b:    Report{Root: "example.com/proj", Rewritten: []string{"main.go", "b.go"}},
+			want: []string{"- rewritten a.go", "+ rewritten b.go"},
+		},
+		{
+			name: "different root",
+			a:    Report{Root: "example.com/proj", Packages: []ReportPackage{foo}},
+			b:    Report{Root: "example.com/fork", Packages: []ReportPackage{{Hash: "QmFoo", Path: "github.com/a/foo", Action: "embed", Target: "gxlibs/github.com/a/foo"}}},
+			want: []string{"~ root: example.com/proj -> example.com/fork"},
+		},
+	}
+	for _, tt := range tests {
+		// Round trip the reports through disk, as the command line tool does
+		var reports []*Report
+		for _, report := range []Report{tt.a, tt.b} {
+			file := filepath.Join(t.TempDir(), "report.json")
+			blob, err := json.Marshal(report)
+			if err != nil {
+				t.Fatalf("%s: failed to encode report: %v", tt.name, err)
+			}
+			if err := ioutil.WriteFile(file, blob, 0644); err != nil {
+				t.Fatalf("%s: failed to save report: %v", tt.name, err)
+			}
+			loaded, err := LoadReport(file)
+			if err != nil {
+				t.Fatalf("%s: failed to load report: %v", tt.name, err)
+			}
+			reports = append(reports, loaded)
+		}
+		if diffs := DiffReports(reports[0], reports[1]); !reflect.DeepEqual(diffs, tt.want) {
+			t.Errorf("%s: diff mismatch:\nhave %q\nwant %q", tt.name, diffs, tt.want)
+		}
+	}
+}
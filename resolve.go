@@ -0,0 +1,404 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// shouldEmbed returns whether a package identified by its import path should be
+// embedded directly into a ungx-ed package or whether vendoring is enough. The
+// deciding factor is whether the package's canonical version is gx based or not,
+// since we can't vendor gx packages.
+//
+// Packages hosted in the same repository on a well known code host share the
+// decision, so only the repository root is ever probed.
+//
+// The ref is the git ref of the vendored release (if known), which is checked
+// instead of the default branch, since the latter may have adopted or dropped gx
+// since. Decisions are cached per release.
+func (c *conversion) shouldEmbed(workspace string, path string, ref string) bool {
+	embed, err := c.embedDecisions.decide(c.decisionKey(path, ref), func() (bool, bool) {
+		embed, conclusive := c.probeEmbed(workspace, c.decisionKey(path, ""), ref)
+
+		// A probe cut short by an interruption decided nothing, don't remember it
+		return embed, conclusive && c.interrupted() == nil
+	})
+	if err != nil {
+		c.logWarn("Warning, failed to persist decision cache: %v", err)
+	}
+	return embed
+}
+
+// releaseRef returns the git tag gx releases of a given version are published
+// under, or an empty string if the version is unknown.
+func releaseRef(version string) string {
+	if _, ok := parseSemver(version); !ok {
+		return ""
+	}
+	return "v" + strings.TrimPrefix(version, "v")
+}
+
+// decisionKey returns the path under which the embed decision of a package is
+// cached, which is its repository root on well known code hosts, suffixed with
+// the release ref if known (different releases may differ in being gx based).
+func (c *conversion) decisionKey(path string, ref string) string {
+	if _, ok := c.repoHosts[strings.Split(path, "/")[0]]; ok {
+		path = c.repoRoot(path)
+	}
+	if ref != "" {
+		path += "@" + ref
+	}
+	return path
+}
+
+// undecidedPaths returns the packages whose embed decision isn't cached yet, so
+// classifying them would need to hit the network.
+func (c *conversion) undecidedPaths(paths []string, refs map[string]string) []string {
+	var undecided []string
+	for _, path := range paths {
+		if _, ok := c.embedDecisions.cached(c.decisionKey(path, refs[path])); !ok {
+			undecided = append(undecided, path)
+		}
+	}
+	return undecided
+}
+
+// probeEmbed does the actual network probing for shouldEmbed, bypassing the
+// decision cache. Besides the decision, it returns whether the decision is
+// conclusive, or was only made to be safe after a failure and shouldn't be
+// cached.
+func (c *conversion) probeEmbed(workspace string, path string, ref string) (bool, bool) {
+	c.logInfo("Deciding whether to vendor or embed %s", path)
+	atomic.AddInt64(&c.networkProbes, 1)
+
+	// Vanity import paths might be fronting a known code host, resolve them first
+	probe := path
+	if !c.rawHosted(probe) {
+		if repo := c.resolveVanity(path); repo != "" && c.rawHosted(repo) {
+			c.logInfo("Resolved vanity import path %s to %s", path, repo)
+
+			// Subpackages resolve into the repo, but the spec is at its root
+			probe = c.decisionKey(repo, "")
+		}
+	}
+	// If the import path points to a known code host, we can cheat and directly decide
+	if c.rawHosted(probe) {
+		// Check the vendored release if known, the default branch may have adopted or
+		// dropped gx since, so it must not stand in for a missing release
+		if ref != "" {
+			return c.probeRelease(path, probe, ref)
+		}
+		// Try the default branch if known, otherwise both common default names
+		branches := []string{"master", "main"}
+		if c.githubHosted(probe) {
+			if branch := c.githubDefaultBranch(probe); branch != "" {
+				branches = []string{branch}
+			}
+		}
+		for _, branch := range branches {
+			// Try to retrieve the gx package spec, embed on hard failure
+			url, _ := c.rawURL(probe, branch, "package.json")
+			res, err := c.httpGet(url)
+			if err != nil {
+				c.logWarn("Warning, failed to probe %s, embedding to be safe: %v", path, err)
+				return true, false
+			}
+			// Drain the body so the keep-alive connection can be reused by other probes
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+
+			switch res.StatusCode {
+			case http.StatusOK:
+				// The file exists, assume its a gx based project
+				return true, true
+			case http.StatusNotFound:
+				// A missing branch looks the same as a missing file, try the next one
+				continue
+			default:
+				// Anything else (rate limit, access denied, server error) proves nothing
+				c.logWarn("Warning, failed to probe %s, embedding to be safe: %s", path, res.Status)
+				return true, false
+			}
+		}
+		return false, true
+	}
+	// Unknown code host or something failed, we need to download the canonical code.
+	// Use an isolated GOPATH so concurrent probes can't step on each other's toes.
+	gopath, err := ioutil.TempDir(workspace, "gopath-")
+	if err != nil {
+		return true, false
+	}
+	defer os.RemoveAll(gopath)
+
+	backoff := c.config.GetBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.goGet(gopath, path)
+		if err == nil {
+			_, err := os.Stat(filepath.Join(gopath, "src", path, "package.json"))
+			return err == nil, true
+		}
+		if err == errPackageNotFound {
+			return true, true
+		}
+		if _, ok := err.(*permanentError); ok || attempt >= c.config.GetRetries {
+			c.logWarn("Warning, failed to download %s, embedding to be safe: %v", path, err)
+			return true, false
+		}
+		c.logWarn("Failed to download %s, retrying in %v: %v", path, backoff, err)
+		if c.sleep(backoff) != nil {
+			return true, false
+		}
+		backoff *= 2
+	}
+}
+
+// probeRelease decides whether a release of a package hosted on a known code host
+// is gx based. A missing tag looks the same as a missing file, so if the package
+// definition is not found, the existence of the tag itself is checked too.
+func (c *conversion) probeRelease(path string, probe string, ref string) (bool, bool) {
+	url, _ := c.rawURL(probe, ref, "package.json")
+	status, err := c.httpStatus(url)
+	if err != nil {
+		c.logWarn("Warning, failed to probe %s, embedding to be safe: %v", path, err)
+		return true, false
+	}
+	switch status {
+	case http.StatusOK:
+		return true, true
+	case http.StatusNotFound:
+		// Definition missing, vendor if the release exists
+	default:
+		c.logWarn("Warning, failed to probe %s, embedding to be safe: %s", path, http.StatusText(status))
+		return true, false
+	}
+	url, _ = c.refURL(probe, ref)
+	if status, err = c.httpStatus(url); err != nil {
+		c.logWarn("Warning, failed to probe %s release %s, embedding to be safe: %v", path, ref, err)
+		return true, false
+	}
+	switch status {
+	case http.StatusOK:
+		return false, true
+	case http.StatusNotFound:
+		c.logWarn("Warning, release %s of %s not found, embedding to be safe", ref, path)
+		return true, false
+	default:
+		c.logWarn("Warning, failed to probe %s release %s, embedding to be safe: %s", path, ref, http.StatusText(status))
+		return true, false
+	}
+}
+
+// httpStatus retrieves a URL, returning only the response status. The body is
+// drained so the keep-alive connection can be reused by other probes.
+func (c *conversion) httpStatus(url string) (int, error) {
+	res, err := c.httpGet(url)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	return res.StatusCode, nil
+}
+
+// refURL returns the URL of the web page browsing the repo of an import path at
+// the given git ref, which only exists if the ref does.
+func (c *conversion) refURL(path string, ref string) (string, bool) {
+	root := c.repoRoot(path)
+	switch {
+	case c.githubHosted(path):
+		return fmt.Sprintf("https://%s/tree/%s", root, ref), true
+	case strings.HasPrefix(path, "gitlab.com/"):
+		return fmt.Sprintf("https://%s/-/tree/%s", root, ref), true
+	case strings.HasPrefix(path, "bitbucket.org/"):
+		return fmt.Sprintf("https://%s/src/%s", root, ref), true
+	}
+	return "", false
+}
+
+// rawURL returns the URL serving the raw contents of a file within the repo of
+// an import path at the given branch, if it's hosted on a known code host.
+func (c *conversion) rawURL(path string, branch string, file string) (string, bool) {
+	if c.githubHosted(path) {
+		return c.githubRawURL(path, branch, file), true
+	}
+	root := c.repoRoot(path)
+	if sub := strings.TrimPrefix(path[len(root):], "/"); sub != "" {
+		file = sub + "/" + file
+	}
+	switch strings.Split(path, "/")[0] {
+	case "gitlab.com":
+		return fmt.Sprintf("https://%s/-/raw/%s/%s", root, branch, file), true
+	case "bitbucket.org":
+		return fmt.Sprintf("https://%s/raw/%s/%s", root, branch, file), true
+	}
+	return "", false
+}
+
+// rawHosted returns whether an import path is hosted on a known code host whose
+// raw file contents can be fetched directly.
+func (c *conversion) rawHosted(path string) bool {
+	_, ok := c.rawURL(path, "", "")
+	return ok
+}
+
+// classifyPaths decides in one concurrent batch whether a set of packages should
+// be embedded or vendored, using a bounded pool of workers. Probes targeting the
+// same host share the keep-alive connections of the HTTP client. The refs hold
+// the git refs of the vendored releases, where known.
+func (c *conversion) classifyPaths(workspace string, paths []string, refs map[string]string, workers int) map[string]bool {
+	var (
+		decisions = make(map[string]bool)
+		lock      sync.Mutex
+		tasks     = make(chan string)
+		pend      sync.WaitGroup
+	)
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers && i < len(paths); i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+
+			for path := range tasks {
+				// Drain the remaining paths without probing if aborted
+				if c.interrupted() != nil {
+					continue
+				}
+				embed := c.shouldEmbed(workspace, path, refs[path])
+
+				lock.Lock()
+				decisions[path] = embed
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		tasks <- path
+	}
+	close(tasks)
+	pend.Wait()
+
+	return decisions
+}
+
+// errPackageNotFound is returned by goGet if the requested package genuinely
+// does not exist, as opposed to a transient network failure.
+var errPackageNotFound = errors.New("package not found")
+
+// notFound matches the go get error messages signalling that retrying the
+// download of a package is pointless since it does not exist.
+var notFound = regexp.MustCompile(`cannot find package|unrecognized import path|repository not found|404 Not Found`)
+
+// unusableGet matches the go get error messages signalling that the toolchain
+// itself can't do the download (e.g. module only toolchain, invalid invocation),
+// so retrying is pointless regardless of the package.
+var unusableGet = regexp.MustCompile(`modules disabled by GO111MODULE|go\.mod file not found|path@version syntax|flag provided but not defined|unknown flag|usage: go get|cannot find GOROOT|requires go >= |toolchain not available`)
+
+// permanentError is returned by goGet if the download failed in a way retrying
+// cannot fix, wrapping the go get failure.
+type permanentError struct {
+	err error
+}
+
+// Error implements the error interface, returning the wrapped failure.
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// goGet downloads the canonical code of a package into the given workspace. If
+// the package does not exist, errPackageNotFound is returned, whereas if go get
+// is unusable altogether, a permanentError is.
+func (c *conversion) goGet(gopath string, path string) error {
+	var stderr bytes.Buffer
+
+	get := exec.CommandContext(c.interrupt, "go", "get", "-d", path+"/...")
+	get.Stdout = c.commandOutput()
+	get.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	get.Dir = gopath
+
+	// Force GOPATH mode, module mode would edit the go.mod of the enclosing module
+	// (even in a dry run) or outright fail outside of one
+	get.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+
+	if err := get.Run(); err != nil {
+		if notFound.Match(stderr.Bytes()) {
+			return errPackageNotFound
+		}
+		if unusableGet.Match(stderr.Bytes()) {
+			return &permanentError{fmt.Errorf("go get unusable: %s", strings.TrimSpace(stderr.String()))}
+		}
+		// A missing or broken go binary won't fix itself either
+		if _, ok := err.(*exec.ExitError); !ok {
+			return &permanentError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// goImport matches the go-import meta tags served by vanity import path hosts,
+// extracting the import prefix, the version control system and the repo root.
+var goImport = regexp.MustCompile(`<meta\s+name="go-import"\s+content="([^"\s]+)\s+([^"\s]+)\s+([^"\s]+)"`)
+
+// resolveVanity retrieves the go-import meta tag of a vanity import path and
+// returns the repository it points to, stripped of the scheme. An empty string
+// is returned if the path cannot be resolved.
+func (c *conversion) resolveVanity(path string) string {
+	c.vanitiesLock.Lock()
+	repo, ok := c.vanities[path]
+	c.vanitiesLock.Unlock()
+
+	if ok {
+		return repo
+	}
+	repo = c.lookupVanity(path)
+
+	c.vanitiesLock.Lock()
+	c.vanities[path] = repo
+	c.vanitiesLock.Unlock()
+
+	return repo
+}
+
+// lookupVanity does the network request of resolving a vanity import path.
+func (c *conversion) lookupVanity(path string) string {
+	res, err := c.httpGet(fmt.Sprintf("https://%s?go-get=1", path))
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+
+	blob, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return ""
+	}
+	for _, match := range goImport.FindAllSubmatch(blob, -1) {
+		prefix := string(match[1])
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+		repo := string(match[3])
+		if idx := strings.Index(repo, "://"); idx >= 0 {
+			repo = repo[idx+3:]
+		}
+		return strings.TrimSuffix(repo, ".git") + strings.TrimPrefix(path, prefix)
+	}
+	return ""
+}
@@ -0,0 +1,305 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCommand places a fake binary running the given shell script first in the
+// PATH for the duration of a test.
+func fakeCommand(t *testing.T, name string, script string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake " + name + " binary is a shell script")
+	}
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("failed to create fake %s: %v", name, err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// Tests that go get runs in GOPATH mode from within the temporary workspace, so
+// it can't touch the go.mod of a module the user runs the conversion from.
+func TestGoGetEnvironment(t *testing.T) {
+	c := newConversion(DefaultOptions())
+
+	out := filepath.Join(t.TempDir(), "env")
+	fakeCommand(t, "go", "echo \"$GO111MODULE|$GOFLAGS|$GOPATH|$(pwd)\" > "+out+"\n")
+
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOFLAGS", "-mod=mod")
+
+	gopath := t.TempDir()
+	if err := c.goGet(gopath, "example.com/foo"); err != nil {
+		t.Fatalf("failed to run go get: %v", err)
+	}
+	blob, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read go get environment: %v", err)
+	}
+	// Resolve symlinks, some temp folders (e.g. macOS) are behind one
+	dir := gopath
+	if real, err := filepath.EvalSymlinks(gopath); err == nil {
+		dir = real
+	}
+	if have, want := strings.TrimSpace(string(blob)), "off||"+gopath+"|"+dir; have != want {
+		t.Errorf("go get environment mismatch: have %q, want %q", have, want)
+	}
+}
+
+// Tests that go get downloads are retried on transient failures, but not if the
+// failure is permanent (missing package, unusable toolchain).
+func TestProbeEmbedRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int    // Number of go get runs failing before a success
+		stderr   string // Error message of the failing runs
+		embed    bool
+		calls    int
+	}{
+		{"success", 0, "", true, 1},
+		{"transient failure", 1, "connection reset by peer", true, 2},
+		{"exhausted retries", 5, "connection reset by peer", true, 3},
+		{"missing package", 5, "cannot find package", true, 1},
+		{"module only toolchain", 5, "go: modules disabled by GO111MODULE=off", true, 1},
+		{"invalid invocation", 5, "flag provided but not defined: -d", true, 1},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		calls := filepath.Join(dir, "calls")
+
+		// Fail the first runs, then download a gx based package
+		fakeCommand(t, "go", "echo run >> "+calls+"\n"+
+			"if [ $(wc -l < "+calls+") -le "+strconv.Itoa(tt.failures)+" ]; then echo '"+tt.stderr+"' >&2; exit 1; fi\n"+
+			"mkdir -p $GOPATH/src/example.com/foo && echo '{}' > $GOPATH/src/example.com/foo/package.json\n")
+
+		c := newConversion(Options{GetRetries: 2, GetBackoff: time.Millisecond, Quiet: true})
+		c.vanities["example.com/foo"] = "" // Don't resolve over the network
+
+		if embed, _ := c.probeEmbed(dir, "example.com/foo", ""); embed != tt.embed {
+			t.Errorf("%s: decision mismatch: have embed %v, want %v", tt.name, embed, tt.embed)
+		}
+		blob, _ := ioutil.ReadFile(calls)
+		if have := strings.Count(string(blob), "run"); have != tt.calls {
+			t.Errorf("%s: go get run count mismatch: have %d, want %d", tt.name, have, tt.calls)
+		}
+	}
+}
+
+// Tests that only a found or missing package definition decides conclusively,
+// whereas any other response status embeds to be safe without being cached.
+func TestProbeEmbedStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int // Response status of the package definition on every branch
+		embed  bool
+		cached bool
+	}{
+		{"gx based", http.StatusOK, true, true},
+		{"plain go", http.StatusNotFound, false, true},
+		{"access denied", http.StatusForbidden, true, false},
+		{"rate limited", http.StatusTooManyRequests, true, false},
+		{"server error", http.StatusInternalServerError, true, false},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		c := newConversion(Options{
+			GitHubRawHosts: map[string]string{"git.example.com": srv.Listener.Addr().String()},
+			MaxHTTPConns:   1,
+			Quiet:          true,
+		})
+		c.httpClient = srv.Client()
+		t.Setenv("GITHUB_TOKEN", "")
+
+		if embed := c.shouldEmbed(t.TempDir(), "git.example.com/a/foo", ""); embed != tt.embed {
+			t.Errorf("%s: decision mismatch: have embed %v, want %v", tt.name, embed, tt.embed)
+		}
+		if _, ok := c.embedDecisions.cached("git.example.com/a/foo"); ok != tt.cached {
+			t.Errorf("%s: cached mismatch: have %v, want %v", tt.name, ok, tt.cached)
+		}
+		srv.Close()
+	}
+}
+
+// Tests that releases are probed at their tag only, never falling back to the
+// default branch, and that the decisions are cached per release.
+func TestProbeEmbedRelease(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   int // Response status of the package definition at the tag
+		tag    int // Response status of the tag's web page
+		embed  bool
+		cached bool
+	}{
+		{"gx based release", http.StatusOK, http.StatusOK, true, true},
+		{"plain go release", http.StatusNotFound, http.StatusOK, false, true},
+		{"missing release", http.StatusNotFound, http.StatusNotFound, true, false},
+		{"unreachable release", http.StatusNotFound, http.StatusInternalServerError, true, false},
+	}
+	for _, tt := range tests {
+		var branches []string
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Host == "raw.example.com" && r.URL.Path == "/a/foo/v1.0.0/package.json":
+				w.WriteHeader(tt.spec)
+			case r.Host == "git.example.com" && r.URL.Path == "/a/foo/tree/v1.0.0":
+				w.WriteHeader(tt.tag)
+			default:
+				branches = append(branches, r.Host+r.URL.Path)
+				w.WriteHeader(http.StatusOK)
+			}
+		}))
+		c := newConversion(Options{
+			GitHubRawHosts: map[string]string{"git.example.com": "raw.example.com"},
+			MaxHTTPConns:   1,
+			Quiet:          true,
+		})
+		// Route every host to the test server
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.InsecureSkipVerify = true
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, network, srv.Listener.Addr().String())
+		}
+		c.httpClient = &http.Client{Transport: transport}
+		t.Setenv("GITHUB_TOKEN", "")
+
+		if embed := c.shouldEmbed(t.TempDir(), "git.example.com/a/foo/bar", "v1.0.0"); embed != tt.embed {
+			t.Errorf("%s: decision mismatch: have embed %v, want %v", tt.name, embed, tt.embed)
+		}
+		if _, ok := c.embedDecisions.cached("git.example.com/a/foo@v1.0.0"); ok != tt.cached {
+			t.Errorf("%s: cached mismatch: have %v, want %v", tt.name, ok, tt.cached)
+		}
+		if _, ok := c.embedDecisions.cached("git.example.com/a/foo"); ok {
+			t.Errorf("%s: release decision cached for the default branch", tt.name)
+		}
+		if len(branches) > 0 {
+			t.Errorf("%s: unexpected fallback requests: %v", tt.name, branches)
+		}
+		srv.Close()
+	}
+}
+
+// Tests that vanity import paths fronting a known code host are resolved to the
+// repository they point to, which is then probed for a gx spec. The resolution
+// of the vanity path itself is cached.
+func TestProbeEmbedVanity(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string // Vanity import path to decide on
+		meta  string // Repository advertised by the vanity host
+		spec  int    // Response status of the package definition in the repo
+		embed bool
+	}{
+		{"gx based repo", "go.example.com/foo", "https://github.com/a/foo", http.StatusOK, true},
+		{"plain go repo", "go.example.com/foo", "https://github.com/a/foo", http.StatusNotFound, false},
+		{"gx based subpackage", "go.example.com/foo/bar", "https://github.com/a/foo.git", http.StatusOK, true},
+		{"plain go subpackage", "go.example.com/foo/bar", "https://github.com/a/foo.git", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		var lookups, probes int
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Host == "go.example.com":
+				lookups++
+				fmt.Fprintf(w, `<meta name="go-import" content="go.example.com/foo git %s">`, tt.meta)
+			case r.Host == "raw.example.com" && r.URL.Path == "/a/foo/master/package.json":
+				probes++
+				w.WriteHeader(tt.spec)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		c := newConversion(Options{
+			GitHubRawHosts: map[string]string{"github.com": "raw.example.com"},
+			MaxHTTPConns:   1,
+			Quiet:          true,
+		})
+		// Route every host to the test server
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.InsecureSkipVerify = true
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, network, srv.Listener.Addr().String())
+		}
+		c.httpClient = &http.Client{Transport: transport}
+		t.Setenv("GITHUB_TOKEN", "")
+
+		if embed := c.shouldEmbed(t.TempDir(), tt.path, ""); embed != tt.embed {
+			t.Errorf("%s: decision mismatch: have embed %v, want %v", tt.name, embed, tt.embed)
+		}
+		if probes != 1 {
+			t.Errorf("%s: repo probe count mismatch: have %d, want 1", tt.name, probes)
+		}
+		if repo, want := c.resolveVanity(tt.path), "github.com/a/foo"+strings.TrimPrefix(tt.path, "go.example.com/foo"); repo != want {
+			t.Errorf("%s: resolved repo mismatch: have %s, want %s", tt.name, repo, want)
+		}
+		if lookups != 1 {
+			t.Errorf("%s: vanity lookup count mismatch: have %d, want 1", tt.name, lookups)
+		}
+		srv.Close()
+	}
+}
+
+// BenchmarkClassifyGitHub measures classifying an all GitHub dependency set over
+// a link with some latency, probing one by one and with the pooled workers that
+// share the keep-alive connections of the HTTP client.
+func BenchmarkClassifyGitHub(b *testing.B) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		if strings.Contains(r.URL.Path, "/gx") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	b.Setenv("GITHUB_TOKEN", "")
+
+	paths := make([]string, 0, 64)
+	for i := 0; i < 64; i++ {
+		if i%2 == 0 {
+			paths = append(paths, fmt.Sprintf("github.com/org/gx%d", i))
+		} else {
+			paths = append(paths, fmt.Sprintf("github.com/org/plain%d", i))
+		}
+	}
+	for _, workers := range []int{1, DefaultOptions().Workers} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// Start every iteration with a cold decision cache
+				c := newConversion(Options{
+					GitHubRawHosts: map[string]string{"github.com": srv.Listener.Addr().String()},
+					MaxHTTPConns:   DefaultOptions().MaxHTTPConns,
+					Quiet:          true,
+				})
+				c.httpClient = srv.Client()
+
+				decisions := c.classifyPaths(b.TempDir(), paths, nil, workers)
+				for _, path := range paths {
+					if decisions[path] != strings.Contains(path, "/gx") {
+						b.Fatalf("decision mismatch for %s: have embed %v", path, decisions[path])
+					}
+				}
+			}
+		})
+	}
+}
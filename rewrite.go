@@ -0,0 +1,520 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// importComment matches a single import comment in either line or block form.
+var importComment = regexp.MustCompile(`^(//\s*import\s+"[^"]*"\s*|/\*\s*import\s+"[^"]*"\s*\*/)$`)
+
+// importCommentPath matches the quoted import path within an import comment.
+var importCommentPath = regexp.MustCompile(`"[^"]*"`)
+
+// goPackage matches the go_package options of protobuf definitions, capturing
+// the Go import path (without the optional package name suffix).
+var goPackage = regexp.MustCompile(`(?m)^(\s*option\s+go_package\s*=\s*")([^";]+)`)
+
+// rewriteSource replaces all the import paths within a Go source file based on
+// the rewrite rules, also rewriting the project root to the fork path (if set)
+// and stripping import comments (or rewriting them if requested). Files of dep
+// managed projects only get their gx import paths rewritten.
+//
+// Only the paths of import declarations are touched. String literals mentioning
+// import paths (e.g. struct tags, reflection or plugin lookups) and comments are
+// left as is. If the file's imports cannot be parsed, they are not rewritten,
+// since there's no way to tell imports apart from other strings.
+func (c *conversion) rewriteSource(fp string, blob []byte, rules map[string]string, root string, managed bool) []byte {
+	// Strip the import comments from the original source, so rewrites can't interfere
+	if !managed && !c.config.KeepImportComments {
+		blob = c.stripImportComments(fp, blob)
+	}
+	// Dep managed projects must keep their own import paths, only drop the gx ones
+	if managed {
+		gxrules := make(map[string]string)
+		for gxpath, gopath := range rules {
+			if strings.HasPrefix(gxpath, "gx/") {
+				gxrules[gxpath] = gopath
+			}
+		}
+		rules = gxrules
+	}
+	rewrite := func(path string) string {
+		if managed {
+			return applyRules(path, rules)
+		}
+		return c.rewritePath(path, rules, root)
+	}
+	if !managed && c.config.KeepImportComments {
+		blob = rewriteImportComment(blob, rewrite)
+	}
+	// Multiple gx hashes may converge to the same path, drop the duplicate imports
+	if rewritten := c.rewriteImports(fp, blob, rewrite); !bytes.Equal(blob, rewritten) {
+		blob = c.dedupeImports(fp, rewritten)
+	}
+	if c.config.RewritePathConstants {
+		blob = rewritePathConstants(fp, blob, rewrite)
+	}
+	return blob
+}
+
+// rewriteTree replaces the import paths within all the files of the project
+// based on the rewrite rules, returning the planned writes without touching any
+// file. In patch mode the changes (and moves) are recorded into the diff, and in
+// dry-run mode they are only logged.
+func (c *conversion) rewriteTree(rules map[string]string, root string, depped []string, excluded map[string]bool, filter *regexp.Regexp, summary *Report, diff *bytes.Buffer) ([]fileWrite, error) {
+	var writes []fileWrite
+	err := c.fsys.Walk(".", func(fp string, fi os.FileInfo, err error) error {
+		// Abort if any error occurred, descend into directories
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return c.interrupted()
+		}
+		// Only Go files (and optionally protobuf definitions and scripts) need rewriting
+		dest := c.movedPath(fp)
+
+		source := strings.HasSuffix(fi.Name(), ".go")
+		proto := c.config.RewriteProtos && strings.HasSuffix(fi.Name(), ".proto")
+		script := !source && !proto && c.config.RewriteScripts != "" && matchesGlobs(fp, c.config.RewriteScripts)
+
+		// Symlinks may point out of the tree (or loop), never rewrite through them
+		symlink := fi.Mode()&os.ModeSymlink != 0
+		if symlink && (source || proto || script) {
+			c.logDebug("Skipping symlink %s", fp)
+		}
+		if symlink || (!source && !proto && !script) {
+			// In patch mode, other files only need to be tracked if they are moved
+			if dest != fp {
+				writeDiff(diff, fp, dest, nil, nil)
+			}
+			return nil
+		}
+		// Replace the relevant import paths in the file
+		oldblob, err := c.fsys.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		newblob := oldblob
+		if !excluded[fp] && (filter == nil || filter.Match(oldblob)) {
+			// Rewrite with Unix line endings, restoring Windows ones afterwards
+			crlf := bytes.Contains(oldblob, []byte("\r\n"))
+
+			blob := oldblob
+			if crlf {
+				blob = bytes.Replace(oldblob, []byte("\r\n"), []byte("\n"), -1)
+			}
+			if source {
+				// Dep managed packages may only have their gx imports rewritten
+				newblob = c.rewriteSource(fp, blob, rules, root, depManaged(depped, fp) != "")
+			} else if proto {
+				newblob = c.rewriteProto(blob, rules, root)
+			} else {
+				newblob = c.rewriteScript(blob, rules, root)
+			}
+			newblob = preserveTrailingNewline(blob, newblob)
+
+			if bytes.Equal(blob, newblob) {
+				newblob = oldblob
+			} else {
+				// Tidy up the files actually modified, leaving the rest of the tree alone
+				if source && !c.config.NoFormat {
+					newblob = preserveTrailingNewline(blob, c.formatSource(fp, newblob))
+				}
+				if crlf {
+					newblob = bytes.Replace(newblob, []byte("\n"), []byte("\r\n"), -1)
+				}
+			}
+		}
+		if c.config.Patch != "" {
+			if dest != fp || !bytes.Equal(oldblob, newblob) {
+				writeDiff(diff, fp, dest, oldblob, newblob)
+			}
+			if !bytes.Equal(oldblob, newblob) {
+				summary.rewrote(filepath.ToSlash(dest))
+			}
+			return nil
+		}
+		if !bytes.Equal(oldblob, newblob) {
+			summary.rewrote(filepath.ToSlash(dest))
+			if c.config.DryRun {
+				c.logDebug("Would rewrite imports in %s", dest)
+				return nil
+			}
+			c.logDebug("Rewriting imports in %s", dest)
+			writes = append(writes, fileWrite{path: fp, oldblob: oldblob, newblob: newblob, perm: fi.Mode().Perm()})
+		}
+		return nil
+	})
+	return writes, err
+}
+
+// formatSource runs gofmt on a rewritten Go source file, sorting the import
+// blocks and tidying up the whitespace left behind by the rewrites. If the file
+// cannot be formatted, it's returned as is.
+func (c *conversion) formatSource(fp string, blob []byte) []byte {
+	formatted, err := format.Source(blob)
+	if err != nil {
+		c.logWarn("Warning, cannot format %s, leaving it unformatted: %v", fp, err)
+		return blob
+	}
+	return formatted
+}
+
+// rewriteImports replaces the path of every import declaration within a Go
+// source file with the one returned by the rewrite function. Everything else in
+// the file is left untouched. If the imports cannot be parsed, the file is
+// returned unmodified.
+func (c *conversion) rewriteImports(fp string, blob []byte, rewrite func(path string) string) []byte {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fp, blob, parser.ImportsOnly)
+	if err != nil {
+		c.logWarn("Warning, cannot parse imports of %s, leaving it unchanged: %v", fp, err)
+		return blob
+	}
+	// Rewrite the import specs back to front so offsets remain valid
+	for i := len(file.Imports) - 1; i >= 0; i-- {
+		blob = replaceLiteral(fset, blob, file.Imports[i].Path, rewrite)
+	}
+	return blob
+}
+
+// rewritePathConstants replaces the values of string constants that hold an
+// import path (e.g. const ImportPath = "gx/ipfs/..." used for plugin lookups)
+// with the one returned by the rewrite function. Only constant declarations are
+// touched, any other string literal is left as is.
+func rewritePathConstants(fp string, blob []byte, rewrite func(path string) string) []byte {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fp, blob, 0)
+	if err != nil {
+		return blob
+	}
+	var lits []*ast.BasicLit
+	ast.Inspect(file, func(node ast.Node) bool {
+		gen, ok := node.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			return true
+		}
+		for _, spec := range gen.Specs {
+			for _, value := range spec.(*ast.ValueSpec).Values {
+				if lit, ok := value.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					lits = append(lits, lit)
+				}
+			}
+		}
+		return false
+	})
+	// Rewrite the constants back to front so offsets remain valid
+	for i := len(lits) - 1; i >= 0; i-- {
+		blob = replaceLiteral(fset, blob, lits[i], rewrite)
+	}
+	return blob
+}
+
+// replaceLiteral replaces the value of a string literal within a source file
+// with the one returned by the rewrite function, keeping its quoting style.
+func replaceLiteral(fset *token.FileSet, blob []byte, lit *ast.BasicLit, rewrite func(path string) string) []byte {
+	path, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return blob
+	}
+	repl := rewrite(path)
+	if repl == path {
+		return blob
+	}
+	quoted := strconv.Quote(repl)
+	if strings.HasPrefix(lit.Value, "`") {
+		quoted = "`" + repl + "`"
+	}
+	start, end := fset.Position(lit.Pos()).Offset, fset.Position(lit.End()).Offset
+	return append(append(append([]byte{}, blob[:start]...), quoted...), blob[end:]...)
+}
+
+// stripImportComments removes the import path enforcement comment from the
+// package clause of a Go source file. The comment is located via the syntax
+// tree, so only a genuine import comment on the package line is removed. If
+// the file cannot be parsed, it is reported and returned unmodified.
+func (c *conversion) stripImportComments(fp string, blob []byte) []byte {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fp, blob, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		c.logWarn("Warning, cannot parse package clause of %s, leaving its import comment: %v", fp, err)
+		return blob
+	}
+	comment := findImportComment(fset, file)
+	if comment == nil {
+		return blob
+	}
+	// Import comment found, cut it out along with the preceding whitespace
+	var (
+		end  = fset.Position(file.Name.End())
+		base = fset.File(file.Package).Base()
+	)
+	start, stop := end.Offset, int(comment.End())-base
+	if len(bytes.TrimSpace(blob[start:int(comment.Pos())-base])) != 0 {
+		start = int(comment.Pos()) - base
+	}
+	return append(append([]byte{}, blob[:start]...), blob[stop:]...)
+}
+
+// rewriteImportComment replaces the path within the import path enforcement
+// comment of a Go source file with the one returned by the rewrite function,
+// keeping the guard intact. If the path has no rewrite (or the file cannot be
+// parsed), the comment is left alone.
+func rewriteImportComment(blob []byte, rewrite func(path string) string) []byte {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", blob, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return blob
+	}
+	comment := findImportComment(fset, file)
+	if comment == nil {
+		return blob
+	}
+	loc := importCommentPath.FindStringIndex(comment.Text)
+	path, err := strconv.Unquote(comment.Text[loc[0]:loc[1]])
+	if err != nil {
+		return blob
+	}
+	repl := rewrite(path)
+	if repl == path {
+		return blob
+	}
+	offset := int(comment.Pos()) - fset.File(file.Package).Base()
+	start, end := offset+loc[0], offset+loc[1]
+	return append(append(append([]byte{}, blob[:start]...), strconv.Quote(repl)...), blob[end:]...)
+}
+
+// findImportComment returns the import path enforcement comment on the package
+// clause line of a parsed Go source file, or nil if there is none.
+func findImportComment(fset *token.FileSet, file *ast.File) *ast.Comment {
+	line := fset.Position(file.Name.End()).Line
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if comment.Pos() < file.Name.End() || fset.Position(comment.Pos()).Line != line {
+				continue
+			}
+			if importComment.MatchString(comment.Text) {
+				return comment
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteProto replaces the Go import paths within the go_package options of a
+// protobuf definition, leaving every other line untouched.
+func (c *conversion) rewriteProto(blob []byte, rules map[string]string, root string) []byte {
+	return goPackage.ReplaceAllFunc(blob, func(match []byte) []byte {
+		parts := goPackage.FindSubmatch(match)
+		return append(append([]byte{}, parts[1]...), c.rewritePath(string(parts[2]), rules, root)...)
+	})
+}
+
+// scriptToken matches the import path like tokens within scripts and Makefiles.
+var scriptToken = regexp.MustCompile(`[A-Za-z0-9_.~/-]+`)
+
+// rewriteScript replaces the import paths within a non-Go file (e.g. a Makefile
+// or a shell script invoking `go run`). Only whole import path tokens are
+// rewritten, so paths merely sharing a prefix are left untouched.
+func (c *conversion) rewriteScript(blob []byte, rules map[string]string, root string) []byte {
+	return scriptToken.ReplaceAllFunc(blob, func(token []byte) []byte {
+		return []byte(c.rewritePath(string(token), rules, root))
+	})
+}
+
+// matchesGlobs returns whether a file matches any of the comma separated glob
+// patterns, either by its base name or its slash separated relative path.
+func matchesGlobs(fp string, globs string) bool {
+	for _, glob := range strings.Split(globs, ",") {
+		if glob = strings.TrimSpace(glob); glob == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(glob, filepath.Base(fp)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(glob, filepath.ToSlash(fp)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rewritePath converts a single import path based on the longest matching rule
+// of the rewrite rules, also rewriting the project root to the fork (if set).
+func (c *conversion) rewritePath(path string, rules map[string]string, root string) string {
+	return c.forkPath(applyRules(path, rules), root)
+}
+
+// forkPath rewrites an import path within the project root to the fork (if set),
+// leaving any other path untouched.
+func (c *conversion) forkPath(path string, root string) string {
+	if c.config.Fork != "" && (path == root || strings.HasPrefix(path, root+"/")) {
+		path = c.config.Fork + path[len(root):]
+	}
+	return path
+}
+
+// forkRules rebases the rewrite rules onto the fork (if set), so that the paths
+// of embedded dependencies point at the fork even in files exempt from the root
+// rewrite (e.g. dep managed ones), and the recorded rules stay consistent with
+// the rewritten tree. Rules matching paths within the root are duplicated for
+// the fork, covering files already forked by a previous run.
+func (c *conversion) forkRules(rules map[string]string, root string) map[string]string {
+	if c.config.Fork == "" {
+		return rules
+	}
+	forked := make(map[string]string, len(rules))
+	for path, gopath := range rules {
+		forked[path] = c.forkPath(gopath, root)
+		if fork := c.forkPath(path, root); fork != path {
+			forked[fork] = forked[path]
+		}
+	}
+	return forked
+}
+
+// applyRules converts a single import path based on the longest matching rule
+// of the rewrite rules. Instead of checking every rule, the path's prefixes are
+// looked up from the longest to the shortest, so the cost only depends on the
+// number of path segments, not on the number of rules.
+func applyRules(path string, rules map[string]string) string {
+	for prefix := path; ; {
+		if gopath, ok := rules[prefix]; ok {
+			return gopath + path[len(prefix):]
+		}
+		idx := strings.LastIndex(prefix, "/")
+		if idx < 0 {
+			return path
+		}
+		prefix = prefix[:idx]
+	}
+}
+
+// dedupeImports detects imports that became duplicates after rewriting (e.g.
+// two gx hashes of the same package both mapping to one canonical path) and
+// removes the redundant ones. Duplicates with identical names (or blank ones)
+// are merged; conflicting aliases cannot be merged safely and are reported.
+func (c *conversion) dedupeImports(fp string, blob []byte) []byte {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fp, blob, parser.ImportsOnly)
+	if err != nil {
+		return blob
+	}
+	var (
+		seen    = make(map[string]*ast.ImportSpec)
+		owners  = make(map[*ast.ImportSpec]*ast.GenDecl)
+		removed = make(map[*ast.GenDecl][]*ast.ImportSpec)
+		decls   []*ast.GenDecl
+	)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		decls = append(decls, gen)
+		for _, spec := range gen.Specs {
+			imp := spec.(*ast.ImportSpec)
+			owners[imp] = gen
+
+			path, err := strconv.Unquote(imp.Path.Value)
+			if err != nil {
+				continue
+			}
+			prev, ok := seen[path]
+			if !ok {
+				seen[path] = imp
+				continue
+			}
+			// Duplicate import found, merge if the names are compatible
+			name, prevName := importName(imp), importName(prev)
+			if name != prevName && name != "_" {
+				if prevName != "_" {
+					c.logWarn("Warning, %s imports %s both as %q and %q, cannot merge", fp, path, prevName, name)
+					continue
+				}
+				// The previous import was blank, the current one supersedes it
+				seen[path], imp = imp, prev
+			}
+			c.logDebug("Removing duplicate import of %s from %s", path, fp)
+			removed[owners[imp]] = append(removed[owners[imp]], imp)
+		}
+	}
+	// Cut the removed specs out of their own declarations, dropping any that
+	// would be left empty altogether
+	type cut struct{ start, end int }
+
+	var cuts []cut
+	for _, gen := range decls {
+		specs := removed[gen]
+		if len(specs) == 0 {
+			continue
+		}
+		if len(specs) == len(gen.Specs) {
+			cuts = append(cuts, cut{fset.Position(gen.Pos()).Offset, fset.Position(gen.End()).Offset})
+			continue
+		}
+		for _, imp := range specs {
+			cuts = append(cuts, cut{fset.Position(imp.Pos()).Offset, fset.Position(imp.End()).Offset})
+		}
+	}
+	if len(cuts) == 0 {
+		return blob
+	}
+	sort.Slice(cuts, func(i, j int) bool { return cuts[i].start > cuts[j].start })
+	for _, span := range cuts {
+		// Expand the cut to the whole line if nothing else is on it
+		start, end := span.start, span.end
+		for start > 0 && (blob[start-1] == ' ' || blob[start-1] == '\t') {
+			start--
+		}
+		for end < len(blob) && (blob[end] == ' ' || blob[end] == '\t') {
+			end++
+		}
+		if (start == 0 || blob[start-1] == '\n') && end < len(blob) && blob[end] == '\n' {
+			end++
+		} else {
+			start, end = span.start, span.end
+		}
+		blob = append(blob[:start:start], blob[end:]...)
+	}
+	return blob
+}
+
+// importName returns the local name an import was declared with, or an empty
+// string if none was explicitly set.
+func importName(imp *ast.ImportSpec) string {
+	if imp.Name == nil {
+		return ""
+	}
+	return imp.Name.Name
+}
+
+// preserveTrailingNewline ensures a rewritten file ends with a newline only if
+// the original did too, so diffs only contain the genuine changes.
+func preserveTrailingNewline(oldblob, newblob []byte) []byte {
+	hadNewline := bytes.HasSuffix(oldblob, []byte("\n"))
+	hasNewline := bytes.HasSuffix(newblob, []byte("\n"))
+
+	switch {
+	case hadNewline && !hasNewline:
+		return append(newblob, '\n')
+	case !hadNewline && hasNewline:
+		return bytes.TrimRight(newblob, "\r\n")
+	}
+	return newblob
+}
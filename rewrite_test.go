@@ -0,0 +1,580 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Tests that imports of multiple gx hashes converging to the same canonical path
+// get deduplicated after the rewrite, unless their names conflict.
+func TestRewriteSourceDedupe(t *testing.T) {
+	c := newConversion(DefaultOptions())
+
+	rules := map[string]string{
+		"gx/ipfs/QmA/foo": "github.com/a/foo",
+		"gx/ipfs/QmB/foo": "github.com/a/foo",
+	}
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "plain and plain",
+			source: "package p\n\nimport (\n\t\"gx/ipfs/QmA/foo\"\n\t\"gx/ipfs/QmB/foo\"\n)\n",
+			want:   "package p\n\nimport (\n\t\"github.com/a/foo\"\n)\n",
+		},
+		{
+			name:   "plain and aliased",
+			source: "package p\n\nimport (\n\t\"gx/ipfs/QmA/foo\"\n\tfoo2 \"gx/ipfs/QmB/foo\"\n)\n",
+			want:   "package p\n\nimport (\n\t\"github.com/a/foo\"\n\tfoo2 \"github.com/a/foo\"\n)\n",
+		},
+		{
+			name:   "same aliases",
+			source: "package p\n\nimport (\n\tf \"gx/ipfs/QmA/foo\"\n\tf \"gx/ipfs/QmB/foo\"\n)\n",
+			want:   "package p\n\nimport (\n\tf \"github.com/a/foo\"\n)\n",
+		},
+		{
+			name:   "blank and plain",
+			source: "package p\n\nimport (\n\t_ \"gx/ipfs/QmA/foo\"\n\t\"gx/ipfs/QmB/foo\"\n)\n",
+			want:   "package p\n\nimport (\n\t\"github.com/a/foo\"\n)\n",
+		},
+		{
+			name:   "separate declarations",
+			source: "package p\n\nimport \"gx/ipfs/QmA/foo\"\nimport \"gx/ipfs/QmB/foo\"\n",
+			want:   "package p\n\nimport \"github.com/a/foo\"\n",
+		},
+		{
+			name:   "blank first in separate declarations",
+			source: "package p\n\nimport _ \"gx/ipfs/QmA/foo\"\nimport \"gx/ipfs/QmB/foo\"\n",
+			want:   "package p\n\nimport \"github.com/a/foo\"\n",
+		},
+		{
+			name:   "blank declaration and block",
+			source: "package p\n\nimport _ \"gx/ipfs/QmA/foo\"\n\nimport (\n\t\"fmt\"\n\t\"gx/ipfs/QmB/foo\"\n)\n",
+			want:   "package p\n\n\nimport (\n\t\"fmt\"\n\t\"github.com/a/foo\"\n)\n",
+		},
+		{
+			name:   "blank in block and declaration",
+			source: "package p\n\nimport (\n\t_ \"gx/ipfs/QmA/foo\"\n\t\"fmt\"\n)\n\nimport \"gx/ipfs/QmB/foo\"\n",
+			want:   "package p\n\nimport (\n\t\"fmt\"\n)\n\nimport \"github.com/a/foo\"\n",
+		},
+		{
+			name:   "blank alone in block",
+			source: "package p\n\nimport (\n\t_ \"gx/ipfs/QmA/foo\"\n)\nimport \"gx/ipfs/QmB/foo\"\n",
+			want:   "package p\n\nimport \"github.com/a/foo\"\n",
+		},
+		{
+			name:   "preexisting duplicates",
+			source: "package p\n\nimport (\n\t\"fmt\"\n\t\"fmt\"\n)\n",
+			want:   "package p\n\nimport (\n\t\"fmt\"\n\t\"fmt\"\n)\n",
+		},
+	}
+	for _, tt := range tests {
+		got := c.rewriteSource("p.go", []byte(tt.source), rules, "example.com/proj", false)
+		if string(got) != tt.want {
+			t.Errorf("%s: rewrite mismatch:\nhave:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+		if _, err := parser.ParseFile(token.NewFileSet(), "p.go", got, parser.ImportsOnly); err != nil {
+			t.Errorf("%s: rewritten source does not parse: %v", tt.name, err)
+		}
+	}
+}
+
+// Tests that only genuine import comments are stripped from the package clause,
+// and that files whose package clause cannot be parsed are left untouched.
+func TestStripImportComments(t *testing.T) {
+	c := newConversion(DefaultOptions())
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"line comment", "package p // import \"github.com/a/p\"\n", "package p\n"},
+		{"block comment", "package p /* import \"github.com/a/p\" */\n", "package p\n"},
+		{"no comment", "package p\n\nconst s = \"// import \\\"x\\\"\"\n", "package p\n\nconst s = \"// import \\\"x\\\"\"\n"},
+		{"unparseable", "pkg p // import \"github.com/a/p\"\n", "pkg p // import \"github.com/a/p\"\n"},
+	}
+	for _, tt := range tests {
+		if have := string(c.stripImportComments("p.go", []byte(tt.source))); have != tt.want {
+			t.Errorf("%s: strip mismatch: have %q, want %q", tt.name, have, tt.want)
+		}
+	}
+}
+
+// Tests that rewritten files keep their trailing newline (or lack thereof) and
+// line endings, both when formatting them and when leaving them as rewritten.
+func TestRewriteTreeTrailingNewline(t *testing.T) {
+	rules := map[string]string{"gx/ipfs/QmA/foo": "github.com/a/foo"}
+	tests := []struct {
+		name     string
+		source   string
+		noFormat bool
+		want     string
+	}{
+		{"formatted with newline", "package p\n\nimport \"gx/ipfs/QmA/foo\"\n", false, "package p\n\nimport \"github.com/a/foo\"\n"},
+		{"formatted without newline", "package p\n\nimport \"gx/ipfs/QmA/foo\"", false, "package p\n\nimport \"github.com/a/foo\""},
+		{"unformatted with newline", "package p\n\nimport \"gx/ipfs/QmA/foo\"\n", true, "package p\n\nimport \"github.com/a/foo\"\n"},
+		{"unformatted without newline", "package p\n\nimport \"gx/ipfs/QmA/foo\"", true, "package p\n\nimport \"github.com/a/foo\""},
+		{"formatted crlf without newline", "package p\r\n\r\nimport \"gx/ipfs/QmA/foo\"", false, "package p\r\n\r\nimport \"github.com/a/foo\""},
+		{"formatted crlf with newline", "package p\r\n\r\nimport \"gx/ipfs/QmA/foo\"\r\n", false, "package p\r\n\r\nimport \"github.com/a/foo\"\r\n"},
+	}
+	for _, tt := range tests {
+		mem := NewMemFS()
+		if err := mem.WriteFile("p.go", []byte(tt.source), 0644); err != nil {
+			t.Fatalf("%s: failed to create source: %v", tt.name, err)
+		}
+		c := newConversion(Options{FS: mem, NoFormat: tt.noFormat, Quiet: true})
+
+		writes, err := c.rewriteTree(rules, "example.com/proj", nil, nil, nil, new(Report), new(bytes.Buffer))
+		if err != nil {
+			t.Fatalf("%s: failed to rewrite tree: %v", tt.name, err)
+		}
+		if len(writes) != 1 {
+			t.Fatalf("%s: rewrite count mismatch: have %d, want 1", tt.name, len(writes))
+		}
+		if have := string(writes[0].newblob); have != tt.want {
+			t.Errorf("%s: rewrite mismatch: have %q, want %q", tt.name, have, tt.want)
+		}
+	}
+}
+
+// Tests that files keep their line ending style through the rewrites, including
+// the removal of import comments, fork rewrites and formatting, so Windows files
+// don't end up with mixed line terminators.
+func TestRewriteTreeLineEndings(t *testing.T) {
+	rules := map[string]string{
+		"gx/ipfs/QmA/foo": "github.com/a/foo",
+		"gx/ipfs/QmB/bar": "github.com/b/bar",
+	}
+	tests := []struct {
+		name   string
+		file   string
+		opts   Options
+		source string
+		want   string
+	}{
+		{
+			name:   "unix import block",
+			file:   "p.go",
+			source: "package p\n\nimport (\n\t\"gx/ipfs/QmB/bar\"\n\t\"gx/ipfs/QmA/foo\"\n)\n",
+			want:   "package p\n\nimport (\n\t\"github.com/a/foo\"\n\t\"github.com/b/bar\"\n)\n",
+		},
+		{
+			name:   "windows import block",
+			file:   "p.go",
+			source: "package p\r\n\r\nimport (\r\n\t\"gx/ipfs/QmB/bar\"\r\n\t\"gx/ipfs/QmA/foo\"\r\n)\r\n",
+			want:   "package p\r\n\r\nimport (\r\n\t\"github.com/a/foo\"\r\n\t\"github.com/b/bar\"\r\n)\r\n",
+		},
+		{
+			name:   "windows import comment",
+			file:   "p.go",
+			source: "package p // import \"gx/ipfs/QmP/p\"\r\n\r\nimport \"gx/ipfs/QmA/foo\"\r\n",
+			want:   "package p\r\n\r\nimport \"github.com/a/foo\"\r\n",
+		},
+		{
+			name:   "windows fork",
+			file:   "p.go",
+			opts:   Options{Fork: "example.com/fork"},
+			source: "package p\r\n\r\nimport (\r\n\t\"example.com/proj/sub\"\r\n\t\"gx/ipfs/QmA/foo\"\r\n)\r\n",
+			want:   "package p\r\n\r\nimport (\r\n\t\"example.com/fork/sub\"\r\n\t\"github.com/a/foo\"\r\n)\r\n",
+		},
+		{
+			name:   "windows unformatted",
+			file:   "p.go",
+			opts:   Options{NoFormat: true},
+			source: "package p\r\n\r\nimport (\r\n\t\"gx/ipfs/QmB/bar\"\r\n\t\"gx/ipfs/QmA/foo\"\r\n)\r\n",
+			want:   "package p\r\n\r\nimport (\r\n\t\"github.com/b/bar\"\r\n\t\"github.com/a/foo\"\r\n)\r\n",
+		},
+		{
+			name:   "windows protobuf",
+			file:   "p.proto",
+			opts:   Options{RewriteProtos: true},
+			source: "syntax = \"proto3\";\r\noption go_package = \"gx/ipfs/QmA/foo/pb\";\r\n",
+			want:   "syntax = \"proto3\";\r\noption go_package = \"github.com/a/foo/pb\";\r\n",
+		},
+	}
+	for _, tt := range tests {
+		mem := NewMemFS()
+		if err := mem.WriteFile(tt.file, []byte(tt.source), 0644); err != nil {
+			t.Fatalf("%s: failed to create source: %v", tt.name, err)
+		}
+		tt.opts.FS, tt.opts.Quiet = mem, true
+		c := newConversion(tt.opts)
+
+		writes, err := c.rewriteTree(rules, "example.com/proj", nil, nil, nil, new(Report), new(bytes.Buffer))
+		if err != nil {
+			t.Fatalf("%s: failed to rewrite tree: %v", tt.name, err)
+		}
+		if len(writes) != 1 {
+			t.Fatalf("%s: rewrite count mismatch: have %d, want 1", tt.name, len(writes))
+		}
+		if have := string(writes[0].newblob); have != tt.want {
+			t.Errorf("%s: rewrite mismatch: have %q, want %q", tt.name, have, tt.want)
+		}
+	}
+}
+
+// Tests that the rewrite walk skips symlinks, neither rewriting files outside of
+// the project through them nor replacing the links with regular files, and that
+// looping folder links don't trap it.
+func TestRewriteTreeSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+
+	const (
+		source = "package p\n\nimport \"gx/ipfs/QmA/foo\"\n"
+		want   = "package p\n\nimport \"github.com/a/foo\"\n"
+	)
+	tests := []struct {
+		name   string
+		link   string // Path of the symlink within the project
+		target string // Target of the symlink, relative to the link
+	}{
+		{"file outside of the tree", "link.go", "../outside/p.go"},
+		{"file inside of the tree", "link.go", "p.go"},
+		{"folder outside of the tree", "sub", "../outside"},
+		{"looping folder", "sub", "."},
+	}
+	for _, tt := range tests {
+		root := diskProject(t, map[string]string{
+			"project/p.go": source,
+			"outside/p.go": source,
+		})
+		dir := filepath.Join(root, "project")
+		if err := os.Symlink(tt.target, filepath.Join(dir, tt.link)); err != nil {
+			t.Fatalf("%s: failed to create symlink: %v", tt.name, err)
+		}
+		c := newConversion(Options{FS: osFS{dir: dir}, Quiet: true})
+
+		var diff bytes.Buffer
+		writes, err := c.rewriteTree(map[string]string{"gx/ipfs/QmA/foo": "github.com/a/foo"}, "example.com/proj", nil, nil, nil, new(Report), &diff)
+		if err != nil {
+			t.Fatalf("%s: failed to rewrite tree: %v", tt.name, err)
+		}
+		var paths []string
+		for _, w := range writes {
+			paths = append(paths, w.path)
+		}
+		if !reflect.DeepEqual(paths, []string{"p.go"}) {
+			t.Errorf("%s: rewritten files mismatch: have %v, want [p.go]", tt.name, paths)
+		}
+		if err := c.applyRewrites(writes, &diff); err != nil {
+			t.Fatalf("%s: failed to apply rewrites: %v", tt.name, err)
+		}
+		if blob, _ := ioutil.ReadFile(filepath.Join(root, "outside", "p.go")); string(blob) != source {
+			t.Errorf("%s: file outside of the tree modified:\n%s", tt.name, blob)
+		}
+		if blob, _ := ioutil.ReadFile(filepath.Join(dir, "p.go")); string(blob) != want {
+			t.Errorf("%s: file inside of the tree not rewritten:\n%s", tt.name, blob)
+		}
+		if info, err := os.Lstat(filepath.Join(dir, tt.link)); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s: symlink replaced: %v", tt.name, err)
+		}
+	}
+}
+
+// Tests that rewrites only match whole import path segments, so a dependency
+// whose path is a prefix of another's never corrupts the longer one, whether in
+// Go sources, protobuf definitions or scripts.
+func TestRewritePrefixCollision(t *testing.T) {
+	rules := map[string]string{
+		"gx/ipfs/QmA/foo":    "github.com/a/foo",
+		"gx/ipfs/QmB/foobar": "github.com/a/foobar",
+		"github.com/c/bar":   "example.com/proj/gxlibs/github.com/c/bar",
+	}
+	tests := []struct {
+		name   string
+		kind   string // One of "go", "proto" or "script"
+		fork   string
+		source string
+		want   string
+	}{
+		{
+			name:   "shorter dependency",
+			kind:   "go",
+			source: "package p\n\nimport \"gx/ipfs/QmA/foo\"\n",
+			want:   "package p\n\nimport \"github.com/a/foo\"\n",
+		},
+		{
+			name:   "longer dependency",
+			kind:   "go",
+			source: "package p\n\nimport \"gx/ipfs/QmB/foobar\"\n",
+			want:   "package p\n\nimport \"github.com/a/foobar\"\n",
+		},
+		{
+			name:   "both dependencies",
+			kind:   "go",
+			source: "package p\n\nimport (\n\t\"gx/ipfs/QmA/foo/sub\"\n\t\"gx/ipfs/QmB/foobar/sub\"\n)\n",
+			want:   "package p\n\nimport (\n\t\"github.com/a/foo/sub\"\n\t\"github.com/a/foobar/sub\"\n)\n",
+		},
+		{
+			name:   "canonical prefix",
+			kind:   "go",
+			source: "package p\n\nimport (\n\t\"github.com/c/bar\"\n\t\"github.com/c/barbaz\"\n)\n",
+			want:   "package p\n\nimport (\n\t\"example.com/proj/gxlibs/github.com/c/bar\"\n\t\"github.com/c/barbaz\"\n)\n",
+		},
+		{
+			name:   "fork prefix",
+			kind:   "go",
+			fork:   "example.com/fork",
+			source: "package p\n\nimport (\n\t\"example.com/proj/sub\"\n\t\"example.com/project\"\n)\n",
+			want:   "package p\n\nimport (\n\t\"example.com/fork/sub\"\n\t\"example.com/project\"\n)\n",
+		},
+		{
+			name:   "protobuf package",
+			kind:   "proto",
+			source: "option go_package = \"github.com/c/barbaz/pb\";\noption go_package = \"github.com/c/bar/pb\";\n",
+			want:   "option go_package = \"github.com/c/barbaz/pb\";\noption go_package = \"example.com/proj/gxlibs/github.com/c/bar/pb\";\n",
+		},
+		{
+			name:   "script tokens",
+			kind:   "script",
+			source: "go run gx/ipfs/QmB/foobar/cmd gx/ipfs/QmA/foo/cmd gx/ipfs/QmA/foobaz\n",
+			want:   "go run github.com/a/foobar/cmd github.com/a/foo/cmd gx/ipfs/QmA/foobaz\n",
+		},
+	}
+	for _, tt := range tests {
+		c := newConversion(Options{Fork: tt.fork, Quiet: true})
+
+		var have []byte
+		switch tt.kind {
+		case "go":
+			have = c.rewriteSource("p.go", []byte(tt.source), rules, "example.com/proj", false)
+		case "proto":
+			have = c.rewriteProto([]byte(tt.source), rules, "example.com/proj")
+		case "script":
+			have = c.rewriteScript([]byte(tt.source), rules, "example.com/proj")
+		}
+		if string(have) != tt.want {
+			t.Errorf("%s: rewrite mismatch:\nhave:\n%s\nwant:\n%s", tt.name, have, tt.want)
+		}
+	}
+}
+
+// Tests that only the files matching the rewrite filter get their imports
+// rewritten, leaving the others byte-identical.
+func TestConvertRewriteIf(t *testing.T) {
+	files := map[string]string{
+		"other/other.go": "package other\n\nimport \"gx/ipfs/QmFoo/foo\"\n\nfunc Other() { foo.Foo() }\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+	opts := memOptions(t, mem, gxDecisions)
+	opts.RewriteIf = `gx/ipfs/QmBar/`
+
+	report, err := Convert(opts)
+	if err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	checkConverted(t, mem)
+
+	if blob, _ := mem.ReadFile("other/other.go"); string(blob) != files["other/other.go"] {
+		t.Errorf("filtered out file rewritten:\n%s", blob)
+	}
+	if !reflect.DeepEqual(report.Rewritten, []string{"main.go"}) {
+		t.Errorf("rewritten files mismatch: have %v, want [main.go]", report.Rewritten)
+	}
+	// An invalid filter must be rejected before touching anything
+	mem = memProject(t, gxProject)
+	opts = memOptions(t, mem, gxDecisions)
+	opts.RewriteIf = `gx/ipfs/(`
+
+	if _, err := Convert(opts); err == nil {
+		t.Errorf("invalid rewrite filter accepted")
+	}
+	if _, err := mem.Stat("vendor/gx/ipfs/QmFoo/foo/foo.go"); err != nil {
+		t.Errorf("package moved despite invalid filter: %v", err)
+	}
+}
+
+// Tests that files excluded by their exact relative path are left byte-identical,
+// while files with similar paths are still rewritten.
+func TestConvertExcludeFiles(t *testing.T) {
+	files := map[string]string{
+		"gen/gen.go":     "package gen\n\nimport   \"gx/ipfs/QmFoo/foo\"\n\nvar _ = foo.Foo\n",
+		"gen/gen2.go":    "package gen\n\nimport \"gx/ipfs/QmFoo/foo\"\n\nvar _ = foo.Foo\n",
+		"sub/gen/gen.go": "package gen\n\nimport \"gx/ipfs/QmFoo/foo\"\n\nvar _ = foo.Foo\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+	opts := memOptions(t, mem, gxDecisions)
+	opts.ExcludeFiles = []string{"./gen/gen.go"}
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	checkConverted(t, mem)
+
+	if blob, _ := mem.ReadFile("gen/gen.go"); string(blob) != files["gen/gen.go"] {
+		t.Errorf("excluded file modified: have %q, want %q", blob, files["gen/gen.go"])
+	}
+	for _, path := range []string{"gen/gen2.go", "sub/gen/gen.go"} {
+		if blob, _ := mem.ReadFile(path); strings.Contains(string(blob), "gx/ipfs") {
+			t.Errorf("%s not rewritten:\n%s", path, blob)
+		}
+	}
+}
+
+// Tests that the import paths within the scripts matching the requested globs
+// are rewritten, while other non-Go files are left alone.
+func TestConvertRewriteScripts(t *testing.T) {
+	files := map[string]string{
+		"Makefile":         "tool:\n\tgo run gx/ipfs/QmFoo/foo/cmd/tool\n\tgo install gx/ipfs/QmBar/bar gx/ipfs/QmBarBaz/baz\n",
+		"scripts/build.sh": "#!/bin/sh\ngo build -o bar gx/ipfs/QmBar/bar\n",
+		"scripts/notes.md": "Run gx/ipfs/QmBar/bar to see the magic\n",
+	}
+	for path, content := range gxProject {
+		files[path] = content
+	}
+	mem := memProject(t, files)
+	opts := memOptions(t, mem, gxDecisions)
+	opts.RewriteScripts = "Makefile,scripts/*.sh"
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	want := map[string]string{
+		"Makefile":         "tool:\n\tgo run example.com/proj/gxlibs/github.com/a/foo/cmd/tool\n\tgo install github.com/b/bar gx/ipfs/QmBarBaz/baz\n",
+		"scripts/build.sh": "#!/bin/sh\ngo build -o bar github.com/b/bar\n",
+		"scripts/notes.md": files["scripts/notes.md"],
+	}
+	for path, content := range want {
+		if blob, _ := mem.ReadFile(path); string(blob) != content {
+			t.Errorf("%s: content mismatch:\nhave:\n%s\nwant:\n%s", path, blob, content)
+		}
+	}
+}
+
+// Tests that converting formats the rewritten Go files, sorting the scrambled
+// imports, unless disabled. Files without rewrites are never reformatted.
+func TestConvertFormat(t *testing.T) {
+	scrambled := "package main\n\nimport (\n\t\"gx/ipfs/QmFoo/foo\"\n\t\"fmt\"\n\t\"gx/ipfs/QmBar/bar\"\n)\n\nfunc main() {\n  fmt.Println(foo.Foo, bar.Bar)\n}\n"
+	untouched := "package main\n\nimport (\n\t\"os\"\n\t\"fmt\"\n)\n\nfunc helper() {\n  fmt.Println(os.Args)\n}\n"
+
+	tests := []struct {
+		name     string
+		noFormat bool
+		want     string
+	}{
+		{"formatted", false, "package main\n\nimport (\n\t\"example.com/proj/gxlibs/github.com/a/foo\"\n\t\"fmt\"\n\t\"github.com/b/bar\"\n)\n\nfunc main() {\n\tfmt.Println(foo.Foo, bar.Bar)\n}\n"},
+		{"unformatted", true, "package main\n\nimport (\n\t\"example.com/proj/gxlibs/github.com/a/foo\"\n\t\"fmt\"\n\t\"github.com/b/bar\"\n)\n\nfunc main() {\n  fmt.Println(foo.Foo, bar.Bar)\n}\n"},
+	}
+	for _, tt := range tests {
+		files := map[string]string{"helper.go": untouched}
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		files["main.go"] = scrambled
+
+		mem := memProject(t, files)
+		opts := memOptions(t, mem, gxDecisions)
+		opts.NoFormat = tt.noFormat
+
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		if blob, _ := mem.ReadFile("main.go"); string(blob) != tt.want {
+			t.Errorf("%s: rewritten file mismatch:\nhave:\n%s\nwant:\n%s", tt.name, blob, tt.want)
+		}
+		if blob, _ := mem.ReadFile("helper.go"); string(blob) != untouched {
+			t.Errorf("%s: untouched file reformatted:\n%s", tt.name, blob)
+		}
+	}
+}
+
+// Tests that string constants holding import paths are only rewritten when
+// requested, keeping their quoting and leaving variables alone.
+func TestConvertPathConstants(t *testing.T) {
+	source := "package main\n\nconst (\n\tFooPlugin = \"gx/ipfs/QmFoo/foo/plugin\"\n\tBarPlugin = `gx/ipfs/QmBar/bar`\n\tUnrelated = \"gx/ipfs/QmBaz/baz\"\n)\n\nvar FooPath = \"gx/ipfs/QmFoo/foo\"\n"
+
+	tests := []struct {
+		name    string
+		enabled bool
+		want    string
+	}{
+		{"disabled", false, source},
+		{"enabled", true, "package main\n\nconst (\n\tFooPlugin = \"example.com/proj/gxlibs/github.com/a/foo/plugin\"\n\tBarPlugin = `github.com/b/bar`\n\tUnrelated = \"gx/ipfs/QmBaz/baz\"\n)\n\nvar FooPath = \"gx/ipfs/QmFoo/foo\"\n"},
+	}
+	for _, tt := range tests {
+		files := map[string]string{"plugins.go": source}
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		mem := memProject(t, files)
+		opts := memOptions(t, mem, gxDecisions)
+		opts.RewritePathConstants = tt.enabled
+
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert package: %v", tt.name, err)
+		}
+		checkConverted(t, mem)
+
+		if blob, _ := mem.ReadFile("plugins.go"); string(blob) != tt.want {
+			t.Errorf("%s: content mismatch:\nhave:\n%s\nwant:\n%s", tt.name, blob, tt.want)
+		}
+	}
+}
+
+// BenchmarkApplyRules compares rewriting the imports of a source file via the
+// prefix lookup of the rewrite rules against the original rewrite, which ran a
+// pair of byte replacements over the whole file for every rule.
+func BenchmarkApplyRules(b *testing.B) {
+	c := newConversion(DefaultOptions())
+
+	rules := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		rules[fmt.Sprintf("gx/ipfs/Qm%04d/pkg%d", i, i)] = fmt.Sprintf("github.com/org%d/pkg%d", i%50, i)
+	}
+	var source bytes.Buffer
+	source.WriteString("package main\n\nimport (\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&source, "\t_ \"gx/ipfs/Qm%04d/pkg%d/sub/package\"\n", i*10, i*10)
+	}
+	source.WriteString(")\n")
+	blob := source.Bytes()
+
+	// replace is the rewrite of the original tool, predating the prefix lookup
+	replace := func(blob []byte) []byte {
+		newblob := blob
+		for gxpath, gopath := range rules {
+			newblob = bytes.Replace(newblob, []byte("\""+gxpath+"/"), []byte("\""+gopath+"/"), -1)
+			newblob = bytes.Replace(newblob, []byte("\""+gxpath+"\""), []byte("\""+gopath+"\""), -1)
+		}
+		return newblob
+	}
+	lookup := func(path string) string { return applyRules(path, rules) }
+
+	if have, want := c.rewriteImports("main.go", blob, lookup), replace(blob); !bytes.Equal(have, want) {
+		b.Fatalf("rewrite mismatch:\nhave:\n%s\nwant:\n%s", have, want)
+	}
+	b.Run("prefix lookup", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.rewriteImports("main.go", blob, lookup)
+		}
+	})
+	b.Run("byte replace", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			replace(blob)
+		}
+	})
+}
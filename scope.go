@@ -0,0 +1,76 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// gxImport matches the hash of a gx package within an import path.
+var gxImport = regexp.MustCompile(`gx/ipfs/(Qm[1-9A-HJ-NP-Za-km-z]+)(?:/|$)`)
+
+// scopedHashes returns the set of gx hashes transitively imported by the
+// packages matching the given Go import pattern (e.g. ./cmd/...).
+func (c *conversion) scopedHashes(pattern string) (map[string]bool, error) {
+	args := []string{"list", "-e", "-deps", "-f", "{{.ImportPath}}"}
+	if c.config.BuildTags != "" {
+		args = append(args, "-tags", c.config.BuildTags)
+	}
+	var stdout, stderr bytes.Buffer
+
+	list := exec.CommandContext(c.interrupt, "go", append(args, pattern)...)
+	list.Env = c.goListEnv()
+	list.Dir = c.projectDir()
+	list.Stdout = &stdout
+	list.Stderr = &stderr
+	if err := list.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	hashes := make(map[string]bool)
+	for _, path := range bytes.Split(stdout.Bytes(), []byte("\n")) {
+		if match := gxImport.FindSubmatch(path); match != nil {
+			hashes[string(match[1])] = true
+		}
+	}
+	return hashes, nil
+}
+
+// selectedPath returns whether a gx dependency identified by its canonical import
+// path should be converted according to the --only and --skip filters. A path
+// matching --skip is never converted, even if it matches --only too; otherwise,
+// if --only is set, the path must match it.
+func (c *conversion) selectedPath(path string) bool {
+	if c.config.Skip != "" && matchesPathGlobs(path, c.config.Skip) {
+		return false
+	}
+	return c.config.Only == "" || matchesPathGlobs(path, c.config.Only)
+}
+
+// matchesPathGlobs returns whether an import path, or any of its parent paths,
+// matches any of the comma separated glob patterns. Matching the parents too
+// allows a repository's path to select all the packages within.
+func matchesPathGlobs(importPath string, globs string) bool {
+	for _, glob := range strings.Split(globs, ",") {
+		if glob = strings.TrimSpace(glob); glob == "" {
+			continue
+		}
+		for prefix := importPath; ; {
+			if ok, _ := path.Match(glob, prefix); ok {
+				return true
+			}
+			idx := strings.LastIndex(prefix, "/")
+			if idx < 0 {
+				break
+			}
+			prefix = prefix[:idx]
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tests that a scoped conversion only converts the gx dependencies transitively
+// imported by the packages matching the scope, leaving the rest as gx hashes.
+func TestConvertScope(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	fakeCommand(t, "gx", "exit 0\n")
+
+	// Resolve the gx imports through the vendor folder of a GOPATH project
+	gopath := t.TempDir()
+	t.Setenv("GOPATH", gopath)
+	t.Setenv("GO111MODULE", "off")
+	t.Setenv("GOFLAGS", "")
+
+	files := map[string]string{
+		"cmd/a/main.go": "package main\n\nimport \"example.com/proj/lib\"\n\nfunc main() { lib.Lib() }\n",
+		"cmd/b/main.go": "package main\n\nimport \"gx/ipfs/QmBar/bar\"\n\nfunc main() { bar.Bar() }\n",
+		"lib/lib.go":    "package lib\n\nimport \"gx/ipfs/QmFoo/foo\"\n\nfunc Lib() { foo.Foo() }\n",
+	}
+	for path, content := range gxProject {
+		if path != "main.go" {
+			files[path] = content
+		}
+	}
+	dir := filepath.Join(gopath, "src", "example.com", "proj")
+	for path, content := range files {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create %s folder: %v", path, err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create %s: %v", path, err)
+		}
+	}
+	opts := memOptions(t, nil, `{"github.com/a/foo@v1.0.0": true}`)
+	opts.FS = osFS{dir: dir}
+	opts.Scope = "./cmd/a/..."
+
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	for _, path := range []string{"gxlibs/github.com/a/foo/foo.go", "vendor/gx/ipfs/QmBar/bar/bar.go"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path))); err != nil {
+			t.Errorf("%s missing after scoped conversion: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vendor", "github.com", "b", "bar")); err == nil {
+		t.Errorf("out of scope dependency converted")
+	}
+	if blob, _ := ioutil.ReadFile(filepath.Join(dir, "lib", "lib.go")); !strings.Contains(string(blob), `"example.com/proj/gxlibs/github.com/a/foo"`) {
+		t.Errorf("in scope import not rewritten:\n%s", blob)
+	}
+	if blob, _ := ioutil.ReadFile(filepath.Join(dir, "cmd", "b", "main.go")); string(blob) != files["cmd/b/main.go"] {
+		t.Errorf("out of scope import rewritten:\n%s", blob)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version of a gx package release.
+type semver struct {
+	major, minor, patch int
+	pre                 string // Pre-release suffix, sorting before the release
+}
+
+// parseSemver parses a major.minor.patch version with an optional v prefix, an
+// optional pre-release suffix and optional build metadata.
+func parseSemver(version string) (semver, bool) {
+	version = strings.TrimPrefix(version, "v")
+	if idx := strings.Index(version, "+"); idx >= 0 {
+		version = version[:idx]
+	}
+	var v semver
+	if idx := strings.Index(version, "-"); idx >= 0 {
+		version, v.pre = version[:idx], version[idx+1:]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+	nums := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		nums[i] = n
+	}
+	v.major, v.minor, v.patch = nums[0], nums[1], nums[2]
+	return v, true
+}
+
+// less returns whether the version is lower than another one.
+func (v semver) less(o semver) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+	if v.minor != o.minor {
+		return v.minor < o.minor
+	}
+	if v.patch != o.patch {
+		return v.patch < o.patch
+	}
+	if (v.pre == "") != (o.pre == "") {
+		return v.pre != ""
+	}
+	return v.pre < o.pre
+}
+
+// compatible returns whether two versions are API compatible, i.e. they share
+// the major version, or for pre-1.0 releases, the minor version too.
+func (v semver) compatible(o semver) bool {
+	if v.major != o.major {
+		return false
+	}
+	return v.major != 0 || v.minor == o.minor
+}
+
+// dedupeVersions finds the canonical paths installed under multiple hashes whose
+// versions are all semver compatible, and maps each of the older hashes to the
+// hash of the newest version superseding it. Paths with any version that can't
+// be parsed or is incompatible with the others are left out.
+func dedupeVersions(mappings map[string]string, releases map[string]string) map[string]string {
+	hashes := make(map[string][]string)
+	for hash, path := range mappings {
+		hashes[path] = append(hashes[path], hash)
+	}
+	superseded := make(map[string]string)
+	for _, group := range hashes {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Strings(group)
+
+		parsed := make(map[string]semver)
+		for _, hash := range group {
+			v, ok := parseSemver(releases[hash])
+			if !ok {
+				parsed = nil
+				break
+			}
+			parsed[hash] = v
+		}
+		if parsed == nil {
+			continue
+		}
+		newest := group[0]
+		for _, hash := range group[1:] {
+			if parsed[newest].less(parsed[hash]) {
+				newest = hash
+			}
+		}
+		compatible := true
+		for _, hash := range group {
+			if !parsed[hash].compatible(parsed[newest]) {
+				compatible = false
+				break
+			}
+		}
+		if !compatible {
+			continue
+		}
+		for _, hash := range group {
+			if hash != newest {
+				superseded[hash] = newest
+			}
+		}
+	}
+	return superseded
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// snapshot is a content fingerprint of a file tree, mapping each regular file's
+// path to the hash of its contents.
+type snapshot map[string][sha256.Size]byte
+
+// takeSnapshot fingerprints all the files under a root folder, skipping any of
+// the explicitly ignored paths (and the version control metadata).
+func (c *conversion) takeSnapshot(root string, ignore ...string) (snapshot, error) {
+	snap := make(snapshot)
+	err := c.fsys.Walk(root, func(fp string, fi os.FileInfo, err error) error {
+		// Abort if any error occurred, skip ignored directories
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if fi.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			for _, path := range ignore {
+				if fp == path {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		blob, err := c.fsys.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		snap[fp] = sha256.Sum256(blob)
+		return nil
+	})
+	return snap, err
+}
+
+// diff returns the list of files that were created, modified or deleted in the
+// new snapshot compared to the current one, each sorted alphabetically.
+func (snap snapshot) diff(next snapshot) (created, modified, deleted []string) {
+	for path, hash := range next {
+		if old, ok := snap[path]; !ok {
+			created = append(created, path)
+		} else if old != hash {
+			modified = append(modified, path)
+		}
+	}
+	for path := range snap {
+		if _, ok := next[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	sort.Strings(created)
+	sort.Strings(modified)
+	sort.Strings(deleted)
+
+	return created, modified, deleted
+}
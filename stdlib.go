@@ -0,0 +1,39 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"sort"
+)
+
+// stdlibShims maps the import paths of known packages that were superseded by
+// the standard library to their stdlib replacements.
+var stdlibShims = map[string]string{
+	"golang.org/x/net/context":        "context",
+	"golang.org/x/sync/syncmap":       "sync (sync.Map)",
+	"golang.org/x/xerrors":            "errors (with fmt.Errorf %w)",
+	"golang.org/x/exp/slices":         "slices",
+	"golang.org/x/exp/maps":           "maps",
+	"github.com/pkg/errors":           "errors (with fmt.Errorf %w)",
+	"github.com/btcsuite/fastsha256":  "crypto/sha256",
+	"github.com/mitchellh/go-homedir": "os (os.UserHomeDir)",
+}
+
+// suggestStdlib reports the converted dependencies that are known shims of
+// standard library functionality and could be dropped in favor of it. This is
+// advisory only, nothing is replaced automatically.
+func (c *conversion) suggestStdlib(pkgs []ReportPackage) {
+	sorted := append([]ReportPackage{}, pkgs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	for _, pkg := range sorted {
+		if pkg.Action == "skip" {
+			continue
+		}
+		if stdlib, ok := stdlibShims[pkg.Path]; ok {
+			c.logInfo("Suggestion, %s (%s) could be replaced by stdlib %s", pkg.Path, pkg.Hash, stdlib)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Tests that converting with stdlib suggestions enabled points out the known
+// shims among the dependencies, but leaves the code itself untouched.
+func TestConvertSuggestStdlib(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name    string
+		suggest bool   // Whether to request stdlib suggestions
+		context string // Content of the shim package's source file, empty for none
+		want    bool   // Whether a suggestion is expected
+	}{
+		{"suggested shim", true, "package context\n\nfunc Background() {}\n", true},
+		{"suggestions disabled", false, "package context\n\nfunc Background() {}\n", false},
+		{"skipped shim", true, "", false},
+	}
+	for _, tt := range tests {
+		files := map[string]string{
+			"vendor/gx/ipfs/QmCtx/context/package.json": `{"name": "context", "gx": {"dvcsimport": "golang.org/x/net/context"}}`,
+		}
+		if tt.context != "" {
+			files["vendor/gx/ipfs/QmCtx/context/context.go"] = tt.context
+			files["ctx.go"] = "package main\n\nimport \"gx/ipfs/QmCtx/context\"\n\nvar _ = context.Background\n"
+		}
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		mem := memProject(t, files)
+
+		opts := memOptions(t, mem, `{"github.com/a/foo@v1.0.0": true, "github.com/b/bar": false, "golang.org/x/net": false}`)
+		opts.SuggestStdlib = tt.suggest
+		opts.Quiet = false
+
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+
+		if _, err := Convert(opts); err != nil {
+			t.Fatalf("%s: failed to convert: %v", tt.name, err)
+		}
+		suggested := strings.Contains(logs.String(), "golang.org/x/net/context (QmCtx) could be replaced by stdlib context")
+		if suggested != tt.want {
+			t.Errorf("%s: suggestion %v, want %v:\n%s", tt.name, suggested, tt.want, logs.String())
+		}
+		if tt.context != "" {
+			blob, err := mem.ReadFile("ctx.go")
+			if err != nil {
+				t.Fatalf("%s: failed to read source: %v", tt.name, err)
+			}
+			if !strings.Contains(string(blob), "\"golang.org/x/net/context\"") {
+				t.Errorf("%s: shim import replaced:\n%s", tt.name, blob)
+			}
+		}
+	}
+}
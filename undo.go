@@ -0,0 +1,66 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// undoLog is the list of operations performed on the project, from which the
+// undo script is generated.
+type undoLog struct {
+	moves   []move   // Package moves executed, in order
+	dropped []string // Reinstalled gx copies deleted as already converted
+	files   []string // Files rewritten in place
+}
+
+// writeUndoScript generates a shell script reverting all the package moves done
+// so far (in reverse order) and listing the rewritten files. The script is
+// regenerated after every operation, so it remains usable even if ungx aborts
+// midway.
+//
+// Note, rewrites are not reversible without backups: the script only lists the
+// rewritten files, which need to be restored from version control.
+func (c *conversion) writeUndoScript(file string) error {
+	var script bytes.Buffer
+
+	script.WriteString("#!/bin/sh\n")
+	script.WriteString("# Generated by ungx to revert a conversion. Package moves are undone in\n")
+	script.WriteString("# reverse order; rewritten files must be restored from version control.\n")
+	script.WriteString("set -e\n\n")
+
+	// Conversions of a copy happen outside of the current directory
+	if dir := c.projectDir(); dir != "" {
+		fmt.Fprintf(&script, "cd %s\n\n", shellQuote(dir))
+	}
+
+	for i := len(c.undo.moves) - 1; i >= 0; i-- {
+		move := c.undo.moves[i]
+		fmt.Fprintf(&script, "mkdir -p %s\n", shellQuote(filepath.Dir(move.src)))
+		fmt.Fprintf(&script, "mv %s %s\n", shellQuote(move.dst), shellQuote(move.src))
+	}
+	if len(c.undo.dropped) > 0 {
+		script.WriteString("\n# Deleted duplicate gx copies (reinstall via `gx install --local`):\n")
+		for _, path := range c.undo.dropped {
+			fmt.Fprintf(&script, "#   %s\n", path)
+		}
+	}
+	if len(c.undo.files) > 0 {
+		script.WriteString("\n# Rewritten files (restore from backups or version control):\n")
+		for _, path := range c.undo.files {
+			fmt.Fprintf(&script, "# git checkout -- %s\n", shellQuote(path))
+		}
+	}
+	return ioutil.WriteFile(file, script.Bytes(), 0755)
+}
+
+// shellQuote quotes a string for safe use as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Tests that running the generated undo script moves every converted package
+// back to its gx location, and that it lists the rewritten files.
+func TestConvertUndoScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("undo scripts need a POSIX shell")
+	}
+	fakeCommand(t, "gx", "exit 0\n")
+
+	dir := diskProject(t, gxProject)
+
+	opts := memOptions(t, nil, gxDecisions)
+	opts.FS = osFS{dir: dir}
+	opts.UndoScript = filepath.Join(t.TempDir(), "undo.sh")
+	if _, err := Convert(opts); err != nil {
+		t.Fatalf("failed to convert package: %v", err)
+	}
+	script, err := ioutil.ReadFile(opts.UndoScript)
+	if err != nil {
+		t.Fatalf("failed to read undo script: %v", err)
+	}
+	if !strings.Contains(string(script), "# git checkout -- 'main.go'") {
+		t.Errorf("rewritten main.go not listed:\n%s", script)
+	}
+	// Run the script from elsewhere, it needs to find the project by itself
+	undo := exec.Command("sh", opts.UndoScript)
+	undo.Dir = t.TempDir()
+	if out, err := undo.CombinedOutput(); err != nil {
+		t.Fatalf("failed to run undo script: %v\n%s\nscript:\n%s", err, out, script)
+	}
+	for path, content := range gxProject {
+		if path == "main.go" {
+			continue // Rewrites are not reverted
+		}
+		if blob, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(path))); err != nil || string(blob) != content {
+			t.Errorf("%s not restored: %v", path, err)
+		}
+	}
+	for _, path := range []string{"gxlibs/github.com/a/foo", "vendor/github.com/b/bar"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path))); err == nil {
+			t.Errorf("%s left behind after undo", path)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// listError is an error reported by `go list -e` for a package or one of its
+// dependencies.
+type listError struct {
+	ImportStack []string // Chain of imports leading to the failing package
+	Pos         string   // Position of the failing import, if known
+	Err         string   // Description of the failure
+}
+
+// unresolvedImports runs `go list -e` on all the packages of the project and
+// returns a description of every import that fails to resolve, pointing at the
+// file and import path where possible.
+func (c *conversion) unresolvedImports() ([]string, error) {
+	var stdout, stderr bytes.Buffer
+
+	list := exec.CommandContext(c.interrupt, "go", "list", "-e", "-json", "./...")
+	list.Env = c.goListEnv()
+	list.Dir = c.projectDir()
+	list.Stdout = &stdout
+	list.Stderr = &stderr
+	if err := list.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr.Bytes())
+	}
+	seen := make(map[string]bool)
+	for dec := json.NewDecoder(&stdout); ; {
+		var pkg struct {
+			ImportPath string
+			Error      *listError
+			DepsErrors []*listError
+		}
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		for _, failure := range append([]*listError{pkg.Error}, pkg.DepsErrors...) {
+			if failure == nil {
+				continue
+			}
+			where := failure.Pos
+			if where == "" {
+				where = pkg.ImportPath
+			}
+			if n := len(failure.ImportStack); n > 0 {
+				where += ": import " + failure.ImportStack[n-1]
+			}
+			seen[where+": "+strings.TrimSpace(failure.Err)] = true
+		}
+	}
+	var failures []string
+	for failure := range seen {
+		failures = append(failures, failure)
+	}
+	sort.Strings(failures)
+	return failures, nil
+}
+
+// verifyBuild runs `go build ./...` on the project, honoring the requested build
+// constraints, and returns the compiler output if the build fails.
+func (c *conversion) verifyBuild() error {
+	args := []string{"build"}
+	if c.config.BuildTags != "" {
+		args = append(args, "-tags", c.config.BuildTags)
+	}
+	build := exec.CommandContext(c.interrupt, "go", append(args, "./...")...)
+	build.Env = c.goListEnv()
+	build.Dir = c.projectDir()
+
+	if out, err := build.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v\n%s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
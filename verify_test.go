@@ -0,0 +1,71 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// Tests that verifying the imports after a conversion fails on an import that
+// does not resolve, pointing at the file and import path, but passes otherwise.
+func TestConvertVerifyImports(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	defer log.SetOutput(os.Stderr)
+	fakeCommand(t, "gx", "exit 0\n")
+
+	// Resolve everything locally, without the network or the module cache
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOFLAGS", "-mod=mod")
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOTOOLCHAIN", "local")
+	t.Setenv("GOPATH", t.TempDir())
+
+	tests := []struct {
+		name       string
+		unresolved bool // Whether to add a file with an unresolvable import
+	}{
+		{"resolving imports", false},
+		{"unresolved import", true},
+	}
+	for _, tt := range tests {
+		files := make(map[string]string)
+		for path, content := range gxProject {
+			files[path] = content
+		}
+		if tt.unresolved {
+			files["broken/broken.go"] = "package broken\n\nimport \"example.com/proj/missing\"\n\nvar _ = missing.Missing\n"
+		}
+		opts := memOptions(t, nil, gxDecisions)
+		opts.FS = osFS{dir: diskProject(t, files)}
+		opts.Mode = "modules"
+		opts.VerifyImports = true
+		opts.Quiet = false
+
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+
+		_, err := Convert(opts)
+		if !tt.unresolved {
+			if err != nil {
+				t.Errorf("%s: failed to convert package: %v\n%s", tt.name, err, logs.String())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "unresolved imports") {
+			t.Errorf("%s: conversion error mismatch: have %v, want unresolved imports", tt.name, err)
+		}
+		if !strings.Contains(logs.String(), "broken.go") || !strings.Contains(logs.String(), "example.com/proj/missing") {
+			t.Errorf("%s: unresolved import not pointed at:\n%s", tt.name, logs.String())
+		}
+	}
+}
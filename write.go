@@ -0,0 +1,101 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeFileAtomic replaces the contents of a file by writing into a temporary
+// file next to it and renaming it over the original, so readers never observe
+// a partially written file. Temporary names embed the target's base name and a
+// random suffix, so concurrent writes into the same directory can't collide.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".ungx-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// fileWrite is a planned rewrite of a file's contents.
+type fileWrite struct {
+	path    string      // File to overwrite
+	oldblob []byte      // Original contents, restored on failure
+	newblob []byte      // Rewritten contents
+	perm    os.FileMode // Permissions to keep
+}
+
+// validateWrites ensures that every planned rewrite of a Go source file still
+// parses, unless the original didn't parse either.
+func validateWrites(writes []fileWrite) error {
+	for _, w := range writes {
+		if !strings.HasSuffix(w.path, ".go") {
+			continue
+		}
+		if _, err := parser.ParseFile(token.NewFileSet(), w.path, w.newblob, parser.ImportsOnly); err != nil {
+			if _, oerr := parser.ParseFile(token.NewFileSet(), w.path, w.oldblob, parser.ImportsOnly); oerr == nil {
+				return fmt.Errorf("rewritten %s does not parse: %v", w.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// commitWrites executes a batch of planned rewrites, invoking done after each
+// file. Every file is replaced atomically, and if any write fails, all the files
+// rewritten before it are restored to their original contents, so the batch
+// either succeeds in full or leaves the files untouched. The only exception is
+// a failure during the restoration itself, in which case the files that could
+// not be restored are reported in the returned error.
+func (c *conversion) commitWrites(writes []fileWrite, done func(path string) error) error {
+	for i, w := range writes {
+		if err := c.fsys.WriteFile(w.path, w.newblob, w.perm); err != nil {
+			return c.restoreWrites(writes[:i], err)
+		}
+		if err := done(w.path); err != nil {
+			return c.restoreWrites(writes[:i+1], err)
+		}
+	}
+	return nil
+}
+
+// restoreWrites reverts a batch of already executed rewrites after a failure,
+// returning the original failure annotated with any files left unrestored.
+func (c *conversion) restoreWrites(writes []fileWrite, err error) error {
+	var failed []string
+	for _, w := range writes {
+		if rerr := c.fsys.WriteFile(w.path, w.oldblob, w.perm); rerr != nil {
+			failed = append(failed, w.path)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%v (failed to restore %s)", err, strings.Join(failed, ", "))
+	}
+	return err
+}
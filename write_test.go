@@ -0,0 +1,196 @@
+// Copyright 2018 Péter Szilágyi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ungx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Tests that rewritten files keep their original permissions.
+func TestRewritePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions not supported")
+	}
+
+	tests := []struct {
+		name string
+		perm os.FileMode
+	}{
+		{"world readable", 0644},
+		{"owner only", 0600},
+	}
+	for _, tt := range tests {
+		dir := diskProject(t, map[string]string{"p.go": "package p\n\nimport \"gx/ipfs/QmA/foo\"\n"})
+		if err := os.Chmod(filepath.Join(dir, "p.go"), tt.perm); err != nil {
+			t.Fatalf("%s: failed to set permissions: %v", tt.name, err)
+		}
+		c := newConversion(Options{FS: osFS{dir: dir}, Quiet: true})
+
+		var diff bytes.Buffer
+		writes, err := c.rewriteTree(map[string]string{"gx/ipfs/QmA/foo": "github.com/a/foo"}, "example.com/proj", nil, nil, nil, new(Report), &diff)
+		if err != nil {
+			t.Fatalf("%s: failed to rewrite tree: %v", tt.name, err)
+		}
+		if len(writes) != 1 {
+			t.Fatalf("%s: rewrite count mismatch: have %d, want 1", tt.name, len(writes))
+		}
+		if err := c.applyRewrites(writes, &diff); err != nil {
+			t.Fatalf("%s: failed to apply rewrites: %v", tt.name, err)
+		}
+		info, err := os.Stat(filepath.Join(dir, "p.go"))
+		if err != nil {
+			t.Fatalf("%s: failed to stat rewritten file: %v", tt.name, err)
+		}
+		if info.Mode().Perm() != tt.perm {
+			t.Errorf("%s: permission mismatch: have %v, want %v", tt.name, info.Mode().Perm(), tt.perm)
+		}
+	}
+}
+
+// failingFS is an in-memory file system failing a single file write, the same
+// way a full disk or a revoked permission would midway through a rewrite.
+type failingFS struct {
+	*MemFS
+	fail   int  // Index of the write to fail (starting at 1)
+	writes *int // Number of writes attempted so far
+}
+
+func (fs failingFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if *fs.writes++; *fs.writes == fs.fail {
+		return &os.PathError{Op: "write", Path: path, Err: os.ErrPermission}
+	}
+	return fs.MemFS.WriteFile(path, data, perm)
+}
+
+// Tests that a write failing midway through the rewrite phase restores the files
+// already rewritten, leaving the whole tree as it was.
+func TestApplyRewritesFailure(t *testing.T) {
+	files := map[string]string{
+		"a.go":     "package p\n\nimport \"gx/ipfs/QmA/foo\"\n",
+		"b/b.go":   "package b\n\nimport \"gx/ipfs/QmA/foo\"\n",
+		"c/c/c.go": "package c\n\nimport \"gx/ipfs/QmA/foo\"\n",
+	}
+	for fail := 1; fail <= len(files); fail++ {
+		mem := memProject(t, files)
+		c := newConversion(Options{FS: failingFS{MemFS: mem, fail: fail, writes: new(int)}, Quiet: true})
+
+		var diff bytes.Buffer
+		writes, err := c.rewriteTree(map[string]string{"gx/ipfs/QmA/foo": "github.com/a/foo"}, "example.com/proj", nil, nil, nil, new(Report), &diff)
+		if err != nil {
+			t.Fatalf("write %d: failed to rewrite tree: %v", fail, err)
+		}
+		if len(writes) != len(files) {
+			t.Fatalf("write %d: rewrite count mismatch: have %d, want %d", fail, len(writes), len(files))
+		}
+		if err := c.applyRewrites(writes, &diff); err == nil {
+			t.Errorf("write %d: failure not reported", fail)
+		}
+		if have := fsFiles(t, mem); !reflect.DeepEqual(have, files) {
+			t.Errorf("write %d: tree not restored:\nhave %q\nwant %q", fail, have, files)
+		}
+	}
+}
+
+// Tests that rewrites breaking the syntax of a Go file are rejected before any
+// file is written, unless the original didn't parse either.
+func TestValidateWrites(t *testing.T) {
+	const (
+		valid  = "package p\n\nimport \"github.com/a/foo\"\n"
+		broken = "package p\n\nimport \"github.com/a/foo\n"
+	)
+	tests := []struct {
+		name  string
+		write fileWrite
+		fails bool
+	}{
+		{"valid rewrite", fileWrite{path: "p.go", oldblob: []byte(valid), newblob: []byte(valid)}, false},
+		{"broken rewrite", fileWrite{path: "p.go", oldblob: []byte(valid), newblob: []byte(broken)}, true},
+		{"already broken", fileWrite{path: "p.go", oldblob: []byte(broken), newblob: []byte(broken)}, false},
+		{"non-Go file", fileWrite{path: "p.proto", oldblob: []byte(valid), newblob: []byte(broken)}, false},
+	}
+	for _, tt := range tests {
+		if err := validateWrites([]fileWrite{tt.write}); (err != nil) != tt.fails {
+			t.Errorf("%s: validation failure mismatch: have %v, want failure %v", tt.name, err, tt.fails)
+		}
+	}
+}
+
+// Tests that many concurrent atomic writes into the same folder neither collide
+// on their temporary files nor leave any of them behind, and that every file
+// ends up holding one complete write.
+func TestWriteFileAtomicConcurrent(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   int // Number of distinct files written in the folder
+		writers int // Number of concurrent writers of each file
+	}{
+		{"distinct files", 128, 1},
+		{"same file", 1, 64},
+		{"mixed", 16, 8},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		var (
+			pend sync.WaitGroup
+			errs = make(chan error, tt.files*tt.writers)
+		)
+		for i := 0; i < tt.files; i++ {
+			for j := 0; j < tt.writers; j++ {
+				pend.Add(1)
+				go func(i, j int) {
+					defer pend.Done()
+
+					path := filepath.Join(dir, fmt.Sprintf("file%d.go", i))
+					errs <- writeFileAtomic(path, bytes.Repeat([]byte(fmt.Sprintf("writer %d\n", j)), 4096), 0644)
+				}(i, j)
+			}
+		}
+		pend.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Errorf("%s: failed to write file: %v", tt.name, err)
+			}
+		}
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("%s: failed to list folder: %v", tt.name, err)
+		}
+		var have, want []string
+		for _, info := range infos {
+			have = append(have, info.Name())
+		}
+		for i := 0; i < tt.files; i++ {
+			want = append(want, fmt.Sprintf("file%d.go", i))
+		}
+		sort.Strings(want)
+		if strings.Join(have, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: folder contents mismatch: have %v, want %v", tt.name, have, want)
+		}
+		for _, name := range want {
+			blob, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Errorf("%s: failed to read %s: %v", tt.name, name, err)
+				continue
+			}
+			line := blob[:bytes.IndexByte(blob, '\n')+1]
+			if !bytes.Equal(blob, bytes.Repeat(line, 4096)) {
+				t.Errorf("%s: %s holds a torn write", tt.name, name)
+			}
+		}
+	}
+}